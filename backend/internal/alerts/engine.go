@@ -0,0 +1,218 @@
+package alerts
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/handlers"
+	"github.com/KuberTheGreat/Sentrinet/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// NotificationType marks notifications raised by alert rules.
+const NotificationType = "alert"
+
+// probe is the part of a probe result the rules look at.
+type probe struct {
+	Target      string     `db:"target"`
+	Port        int        `db:"port"`
+	Kind        string     `db:"kind"`
+	TLSNotAfter *time.Time `db:"tls_not_after"`
+	HTTPServer  *string    `db:"http_server"`
+}
+
+// results is what one scan or run produced.
+type results struct {
+	workspaceID int64
+	// runID is the scheduled run the results come from, or 0 for an ad-hoc
+	// scan.
+	runID  int64
+	scans  []models.ScanResult
+	probes []probe
+}
+
+// match is one finding of a rule on host:port. scanID is the scan row the
+// finding is about, or 0 when the run stored none for it.
+type match struct {
+	scanID  int64
+	host    string
+	port    int
+	message string
+}
+
+// EvaluateRun applies the workspace's rules to everything a scheduled run
+// stored.
+func EvaluateRun(db *sqlx.DB, runID int64) error {
+	res := results{runID: runID}
+	if err := db.Get(&res.workspaceID, `SELECT j.workspace_id FROM job_runs r JOIN jobs j ON j.id = r.job_id
+		WHERE r.id = ?`, runID); err != nil {
+		return err
+	}
+	if err := db.Select(&res.scans, `SELECT * FROM scans WHERE run_id = ? ORDER BY id`, runID); err != nil {
+		return err
+	}
+	if err := db.Select(&res.probes, `SELECT target, port, kind, tls_not_after, http_server FROM probe_results
+		WHERE run_id = ?`, runID); err != nil {
+		return err
+	}
+	return evaluate(db, res)
+}
+
+// EvaluateScans applies the workspace's rules to the rows of an ad-hoc scan.
+func EvaluateScans(db *sqlx.DB, workspaceID int64, scanIDs []int64) error {
+	if len(scanIDs) == 0 {
+		return nil
+	}
+	query, args, err := sqlx.In(`SELECT * FROM scans WHERE workspace_id = ? AND id IN (?) ORDER BY id`, workspaceID, scanIDs)
+	if err != nil {
+		return err
+	}
+	res := results{workspaceID: workspaceID}
+	if err := db.Select(&res.scans, db.Rebind(query), args...); err != nil {
+		return err
+	}
+	return evaluate(db, res)
+}
+
+func evaluate(db *sqlx.DB, res results) error {
+	rules := []Rule{}
+	if err := db.Select(&rules, `SELECT * FROM alert_rules WHERE workspace_id = ? AND enabled = 1`, res.workspaceID); err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	tags, err := hostTags(db, res.workspaceID)
+	if err != nil {
+		return err
+	}
+	scanIDs := map[string]int64{}
+	for _, s := range res.scans {
+		scanIDs[endpoint(s.Target, s.Port)] = s.ID
+	}
+
+	for _, r := range rules {
+		r.decode()
+		matches, err := r.apply(db, res, tags, scanIDs)
+		if err != nil {
+			fmt.Printf("[Alerts] rule %d: %v\n", r.ID, err)
+			continue
+		}
+		for _, m := range matches {
+			ruleID := r.ID
+			// Repeats of a rule on the same endpoint are one group, so
+			// every scheduled run does not notify again.
+			key := fmt.Sprintf("alert:%d:%s", r.ID, endpoint(m.host, m.port))
+			n := models.Notification{
+				UserID:      int(r.UserID),
+				WorkspaceID: r.WorkspaceID,
+				ScanID:      int(m.scanID),
+				Type:        NotificationType,
+				Message:     fmt.Sprintf("[%s] %s: %s", strings.ToUpper(r.Severity), r.Name, m.message),
+				Severity:    r.Severity,
+				RuleID:      &ruleID,
+				DedupKey:    &key,
+			}
+			if res.runID != 0 {
+				n.RunID = &res.runID
+			}
+			if _, err := handlers.Notify(db, n); err != nil {
+				fmt.Printf("[Alerts] rule %d notification: %v\n", r.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func endpoint(host string, port int) string {
+	return fmt.Sprintf("%s:%d", host, port)
+}
+
+func hostTags(db *sqlx.DB, workspaceID int64) (map[string]map[string]bool, error) {
+	list, err := ListHostTags(db, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]map[string]bool{}
+	for _, t := range list {
+		if tags[t.Host] == nil {
+			tags[t.Host] = map[string]bool{}
+		}
+		tags[t.Host][t.Tag] = true
+	}
+	return tags, nil
+}
+
+// scope reports whether a finding on host:port falls under the rule's port
+// and host tag filters.
+func (r Rule) scope(host string, port int, tags map[string]map[string]bool) bool {
+	if r.HostTag != "" && !tags[strings.ToLower(host)][r.HostTag] {
+		return false
+	}
+	if len(r.Ports) == 0 {
+		return true
+	}
+	for _, p := range r.Ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) apply(db *sqlx.DB, res results, tags map[string]map[string]bool, scanIDs map[string]int64) ([]match, error) {
+	matches := []match{}
+	switch r.Kind {
+	case KindOpenPort, KindNewOpenPort:
+		for _, s := range res.scans {
+			if !s.IsOpen || !r.scope(s.Target, s.Port, tags) {
+				continue
+			}
+			if r.Kind == KindNewOpenPort {
+				var seen int
+				if err := db.Get(&seen, `SELECT COUNT(*) FROM scans WHERE workspace_id = ? AND target = ? AND port = ?
+					AND is_open = 1 AND id < ?`, res.workspaceID, s.Target, s.Port, s.ID); err != nil {
+					return nil, err
+				}
+				if seen > 0 {
+					continue
+				}
+				matches = append(matches, match{s.ID, s.Target, s.Port, fmt.Sprintf("new open port %d on %s", s.Port, s.Target)})
+				continue
+			}
+			matches = append(matches, match{s.ID, s.Target, s.Port, fmt.Sprintf("port %d open on %s", s.Port, s.Target)})
+		}
+
+	case KindTLSExpiry:
+		deadline := time.Now().AddDate(0, 0, r.WithinDays)
+		for _, p := range res.probes {
+			if p.TLSNotAfter == nil || p.TLSNotAfter.After(deadline) || !r.scope(p.Target, p.Port, tags) {
+				continue
+			}
+			days := int(time.Until(*p.TLSNotAfter).Hours() / 24)
+			msg := fmt.Sprintf("TLS certificate on %s expires in %d days (%s)",
+				endpoint(p.Target, p.Port), days, p.TLSNotAfter.UTC().Format("2006-01-02"))
+			if days < 0 {
+				msg = fmt.Sprintf("TLS certificate on %s expired on %s",
+					endpoint(p.Target, p.Port), p.TLSNotAfter.UTC().Format("2006-01-02"))
+			}
+			matches = append(matches, match{scanIDs[endpoint(p.Target, p.Port)], p.Target, p.Port, msg})
+		}
+
+	case KindServiceMatch:
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range res.probes {
+			if p.HTTPServer == nil || !re.MatchString(*p.HTTPServer) || !r.scope(p.Target, p.Port, tags) {
+				continue
+			}
+			matches = append(matches, match{scanIDs[endpoint(p.Target, p.Port)], p.Target, p.Port,
+				fmt.Sprintf("service %q on %s matches %s", *p.HTTPServer, endpoint(p.Target, p.Port), r.Pattern)})
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,217 @@
+// Package alerts evaluates user defined rules against the results of every
+// scan and scheduled run and raises a notification for each match.
+package alerts
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// Rule kinds.
+const (
+	// KindOpenPort matches every open port, optionally limited to Ports.
+	KindOpenPort = "open_port"
+	// KindNewOpenPort matches ports found open on a host for the first time
+	// in the workspace.
+	KindNewOpenPort = "new_open_port"
+	// KindTLSExpiry matches certificates expiring within WithinDays, or
+	// already expired.
+	KindTLSExpiry = "tls_expiry"
+	// KindServiceMatch matches HTTP Server headers, which carry the service
+	// version, against the regular expression Pattern.
+	KindServiceMatch = "service_match"
+)
+
+var kinds = []string{KindOpenPort, KindNewOpenPort, KindTLSExpiry, KindServiceMatch}
+
+// Rule is an alert rule of a workspace. Every rule may be narrowed to Ports
+// and to hosts tagged HostTag. Matches notify the user who owns the rule.
+type Rule struct {
+	ID          int64     `db:"id" json:"id"`
+	WorkspaceID int64     `db:"workspace_id" json:"workspace_id"`
+	UserID      int64     `db:"user_id" json:"user_id"`
+	Name        string    `db:"name" json:"name"`
+	Kind        string    `db:"kind" json:"kind"`
+	Severity    string    `db:"severity" json:"severity"`
+	Enabled     bool      `db:"enabled" json:"enabled"`
+	PortList    string    `db:"ports" json:"-"`
+	Ports       []int     `db:"-" json:"ports"`
+	HostTag     string    `db:"host_tag" json:"host_tag,omitempty"`
+	WithinDays  int       `db:"within_days" json:"within_days,omitempty"`
+	Pattern     string    `db:"pattern" json:"pattern,omitempty"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+func (r *Rule) decode() {
+	r.Ports = []int{}
+	for _, p := range strings.Split(r.PortList, ",") {
+		if n, err := strconv.Atoi(p); err == nil {
+			r.Ports = append(r.Ports, n)
+		}
+	}
+}
+
+func (r *Rule) encode() {
+	parts := make([]string, len(r.Ports))
+	for i, p := range r.Ports {
+		parts[i] = strconv.Itoa(p)
+	}
+	r.PortList = strings.Join(parts, ",")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks a rule before it is stored.
+func (r *Rule) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !contains(kinds, r.Kind) {
+		return fmt.Errorf("kind must be one of %s", strings.Join(kinds, ", "))
+	}
+	if r.Severity == "" {
+		r.Severity = models.SeverityMedium
+	}
+	if !models.ValidSeverity(r.Severity) {
+		return fmt.Errorf("severity must be one of %s", strings.Join(models.Severities, ", "))
+	}
+	for _, p := range r.Ports {
+		if p <= 0 || p > 65535 {
+			return fmt.Errorf("invalid port %d", p)
+		}
+	}
+	switch r.Kind {
+	case KindTLSExpiry:
+		if r.WithinDays <= 0 {
+			return fmt.Errorf("within_days must be positive")
+		}
+	case KindServiceMatch:
+		if _, err := regexp.Compile(r.Pattern); err != nil || r.Pattern == "" {
+			return fmt.Errorf("pattern must be a valid regular expression")
+		}
+	}
+	return nil
+}
+
+func ListRules(db *sqlx.DB, workspaceID int64) ([]Rule, error) {
+	rules := []Rule{}
+	if err := db.Select(&rules, `SELECT * FROM alert_rules WHERE workspace_id = ? ORDER BY name`, workspaceID); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		rules[i].decode()
+	}
+	return rules, nil
+}
+
+// GetRule returns a rule of workspaceID or sql.ErrNoRows.
+func GetRule(db *sqlx.DB, workspaceID, id int64) (Rule, error) {
+	var r Rule
+	if err := db.Get(&r, `SELECT * FROM alert_rules WHERE id = ? AND workspace_id = ?`, id, workspaceID); err != nil {
+		return r, err
+	}
+	r.decode()
+	return r, nil
+}
+
+func CreateRule(db *sqlx.DB, r Rule) (Rule, error) {
+	if err := r.Validate(); err != nil {
+		return r, err
+	}
+	r.encode()
+	res, err := db.NamedExec(`INSERT INTO alert_rules (workspace_id, user_id, name, kind, severity, enabled, ports,
+		host_tag, within_days, pattern) VALUES (:workspace_id, :user_id, :name, :kind, :severity, :enabled, :ports,
+		:host_tag, :within_days, :pattern)`, r)
+	if err != nil {
+		return r, err
+	}
+	id, _ := res.LastInsertId()
+	return GetRule(db, r.WorkspaceID, id)
+}
+
+// UpdateRule replaces the editable fields of a rule; its owner and workspace
+// do not change.
+func UpdateRule(db *sqlx.DB, r Rule) (Rule, error) {
+	if err := r.Validate(); err != nil {
+		return r, err
+	}
+	r.encode()
+	res, err := db.NamedExec(`UPDATE alert_rules SET name = :name, kind = :kind, severity = :severity,
+		enabled = :enabled, ports = :ports, host_tag = :host_tag, within_days = :within_days, pattern = :pattern
+		WHERE id = :id AND workspace_id = :workspace_id`, r)
+	if err != nil {
+		return r, err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return r, sql.ErrNoRows
+	}
+	return GetRule(db, r.WorkspaceID, r.ID)
+}
+
+func DeleteRule(db *sqlx.DB, workspaceID, id int64) error {
+	res, err := db.Exec(`DELETE FROM alert_rules WHERE id = ? AND workspace_id = ?`, id, workspaceID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// HostTag labels a host of a workspace, e.g. as production, so rules can be
+// limited to it.
+type HostTag struct {
+	ID          int64     `db:"id" json:"id"`
+	WorkspaceID int64     `db:"workspace_id" json:"workspace_id"`
+	Host        string    `db:"host" json:"host"`
+	Tag         string    `db:"tag" json:"tag"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+func ListHostTags(db *sqlx.DB, workspaceID int64) ([]HostTag, error) {
+	tags := []HostTag{}
+	err := db.Select(&tags, `SELECT * FROM host_tags WHERE workspace_id = ? ORDER BY host, tag`, workspaceID)
+	return tags, err
+}
+
+func AddHostTag(db *sqlx.DB, workspaceID int64, host, tag string) (HostTag, error) {
+	host = strings.ToLower(strings.TrimSpace(host))
+	tag = strings.TrimSpace(tag)
+	if host == "" || tag == "" {
+		return HostTag{}, fmt.Errorf("host and tag are required")
+	}
+	if _, err := db.Exec(`INSERT OR IGNORE INTO host_tags (workspace_id, host, tag) VALUES (?, ?, ?)`,
+		workspaceID, host, tag); err != nil {
+		return HostTag{}, err
+	}
+	var t HostTag
+	err := db.Get(&t, `SELECT * FROM host_tags WHERE workspace_id = ? AND host = ? AND tag = ?`, workspaceID, host, tag)
+	return t, err
+}
+
+func DeleteHostTag(db *sqlx.DB, workspaceID, id int64) error {
+	res, err := db.Exec(`DELETE FROM host_tags WHERE id = ? AND workspace_id = ?`, id, workspaceID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
+	"github.com/KuberTheGreat/Sentrinet/internal/scheduler"
+	"github.com/gofiber/fiber/v2"
+)
+
+func paramID(c *fiber.Ctx, name string) (int64, error) {
+	id, err := strconv.ParseInt(c.Params(name), 10, 64)
+	if err != nil {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "invalid "+name)
+	}
+	return id, nil
+}
+
+// callerJob loads the job named by the :id parameter and checks that the
+// job belongs to the active workspace. Jobs of other workspaces are reported
+// as not found so that their ids are not disclosed; admins may access every
+// job.
+func callerJob(c *fiber.Ctx, m *scheduler.Manager) (scheduler.JobRow, error) {
+	id, err := paramID(c, "id")
+	if err != nil {
+		return scheduler.JobRow{}, err
+	}
+	jr, err := m.GetJob(id)
+	if err == sql.ErrNoRows || (err == nil && !auth.Caller(c).InWorkspace(jr.WorkspaceID)) {
+		return scheduler.JobRow{}, fiber.NewError(fiber.StatusNotFound, "job not found")
+	}
+	return jr, err
+}
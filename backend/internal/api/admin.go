@@ -0,0 +1,168 @@
+package api
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
+	"github.com/KuberTheGreat/Sentrinet/internal/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+// setupAdminRoutes registers the /admin endpoints. Every route needs the
+// admin:access permission plus the permission for the area it manages.
+func setupAdminRoutes(app *fiber.App, database *sqlx.DB) {
+	admin := app.Group("/admin", auth.JWTMiddleware, auth.RequirePermission(auth.PermAdminAccess))
+	setupAuditRoutes(admin, database)
+	setupTargetRoutes(admin, database)
+	setupQuotaRoutes(admin, database)
+
+	admin.Get("/roles", auth.RequirePermission(auth.PermUsersManage), func(c *fiber.Ctx) error {
+		roles, err := auth.ListRoles(database)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(roles)
+	})
+
+	admin.Get("/users", auth.RequirePermission(auth.PermUsersManage), func(c *fiber.Ctx) error {
+		users := []auth.User{}
+		if err := database.Select(&users, `SELECT * FROM users ORDER BY id`); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(users)
+	})
+
+	admin.Put("/users/:id/role", auth.RequirePermission(auth.PermUsersManage), func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		ok, err := auth.RoleExists(database, req.Role)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown role " + req.Role})
+		}
+		if id == auth.Caller(c).UserID && req.Role != auth.RoleAdmin {
+			return c.Status(400).JSON(fiber.Map{"error": "admins cannot demote themselves"})
+		}
+
+		var before string
+		if err := database.Get(&before, `SELECT role FROM users WHERE id = ?`, id); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(404).JSON(fiber.Map{"error": "user not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if _, err := database.Exec(`UPDATE users SET role = ? WHERE id = ?`, req.Role, id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		// Tokens carry the role's permissions, so sessions from before the
+		// change must not mint new ones.
+		if before != req.Role {
+			if _, err := auth.RevokeUserSessions(database, id); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+
+		var user auth.User
+		if err := database.Get(&user, `SELECT * FROM users WHERE id = ?`, id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionUserRole, ResourceType: "user", ResourceID: strconv.FormatInt(id, 10),
+			Target: user.Username, Before: fiber.Map{"role": before}, After: fiber.Map{"role": user.Role},
+		})
+		return c.JSON(user)
+	})
+
+	admin.Post("/users/:id/revoke-sessions", auth.RequirePermission(auth.PermUsersManage), func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		count, err := auth.RevokeUserSessions(database, id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionRevokeSessions, ResourceType: "user", ResourceID: strconv.FormatInt(id, 10),
+			After: fiber.Map{"revoked": count},
+		})
+		return c.JSON(fiber.Map{"revoked": count})
+	})
+
+	admin.Post("/users/:id/reset-2fa", auth.RequirePermission(auth.PermUsersManage), func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		if err := auth.DisableTOTP(database, id); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionReset2FA, ResourceType: "user", ResourceID: strconv.FormatInt(id, 10),
+		})
+		return c.JSON(fiber.Map{"message": "two-factor authentication reset"})
+	})
+
+	admin.Get("/cleanup-logs", auth.RequirePermission(auth.PermCleanupManage), func(c *fiber.Ctx) error {
+		logs := []struct {
+			ID           int64     `db:"id" json:"id"`
+			DeletedCount int64     `db:"deleted_count" json:"deleted_count"`
+			RunTimeMs    int64     `db:"run_time_ms" json:"run_time_ms"`
+			CreatedAt    time.Time `db:"created_at" json:"created_at"`
+		}{}
+
+		err := database.Select(&logs, "SELECT * FROM cleanup_logs ORDER BY created_at DESC LIMIT 20")
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(logs)
+	})
+
+	admin.Post("/cleanup", auth.RequirePermission(auth.PermCleanupManage), func(c *fiber.Ctx) error {
+		if err := db.RunCleanup(database); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{Action: audit.ActionCleanupRun})
+		return c.JSON(fiber.Map{"message": "cleanup complete"})
+	})
+
+	admin.Get("/retention", auth.RequirePermission(auth.PermCleanupManage), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"closed_hours": int(db.ClosedRetention(database).Hours())})
+	})
+
+	admin.Put("/retention", auth.RequirePermission(auth.PermCleanupManage), func(c *fiber.Ctx) error {
+		var req struct {
+			ClosedHours int `json:"closed_hours"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if req.ClosedHours <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "closed_hours must be positive"})
+		}
+		before := int(db.ClosedRetention(database).Hours())
+		if err := db.SetSetting(database, db.SettingClosedRetentionHours, strconv.Itoa(req.ClosedHours)); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionRetention, ResourceType: "setting", ResourceID: db.SettingClosedRetentionHours,
+			Before: fiber.Map{"closed_hours": before}, After: fiber.Map{"closed_hours": req.ClosedHours},
+		})
+		return c.JSON(fiber.Map{"closed_hours": req.ClosedHours})
+	})
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/alerts"
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+// alertRuleRequest is the editable part of a rule. Enabled defaults to true.
+type alertRuleRequest struct {
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	Severity   string `json:"severity"`
+	Enabled    *bool  `json:"enabled"`
+	Ports      []int  `json:"ports"`
+	HostTag    string `json:"host_tag"`
+	WithinDays int    `json:"within_days"`
+	Pattern    string `json:"pattern"`
+}
+
+func (req alertRuleRequest) apply(r *alerts.Rule) {
+	r.Name, r.Kind, r.Severity = req.Name, req.Kind, req.Severity
+	r.Ports, r.HostTag, r.WithinDays, r.Pattern = req.Ports, req.HostTag, req.WithinDays, req.Pattern
+	r.Enabled = req.Enabled == nil || *req.Enabled
+}
+
+func setupAlertRoutes(app *fiber.App, database *sqlx.DB, inWorkspace fiber.Handler) {
+	app.Get("/alert-rules", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), func(c *fiber.Ctx) error {
+		rules, err := alerts.ListRules(database, auth.Caller(c).WorkspaceID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(rules)
+	})
+
+	app.Post("/alert-rules", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermAlertsWrite), func(c *fiber.Ctx) error {
+		var req alertRuleRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		caller := auth.Caller(c)
+		rule := alerts.Rule{WorkspaceID: caller.WorkspaceID, UserID: caller.UserID}
+		req.apply(&rule)
+		rule, err := alerts.CreateRule(database, rule)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionAlertRuleCreate, ResourceType: "alert_rule", ResourceID: strconv.FormatInt(rule.ID, 10),
+			After: rule,
+		})
+		return c.Status(fiber.StatusCreated).JSON(rule)
+	})
+
+	app.Put("/alert-rules/:id", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermAlertsWrite), func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		var req alertRuleRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		before, err := alerts.GetRule(database, auth.Caller(c).WorkspaceID, id)
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "alert rule not found"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		rule := before
+		req.apply(&rule)
+		rule, err = alerts.UpdateRule(database, rule)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionAlertRuleUpdate, ResourceType: "alert_rule", ResourceID: strconv.FormatInt(id, 10),
+			Before: before, After: rule,
+		})
+		return c.JSON(rule)
+	})
+
+	app.Delete("/alert-rules/:id", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermAlertsWrite), func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		if err := alerts.DeleteRule(database, auth.Caller(c).WorkspaceID, id); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(404).JSON(fiber.Map{"error": "alert rule not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionAlertRuleDelete, ResourceType: "alert_rule", ResourceID: strconv.FormatInt(id, 10),
+		})
+		return c.JSON(fiber.Map{"message": "deleted"})
+	})
+
+	app.Get("/host-tags", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermScansRead), func(c *fiber.Ctx) error {
+		tags, err := alerts.ListHostTags(database, auth.Caller(c).WorkspaceID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(tags)
+	})
+
+	app.Post("/host-tags", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermAlertsWrite), func(c *fiber.Ctx) error {
+		var req struct {
+			Host string `json:"host"`
+			Tag  string `json:"tag"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		tag, err := alerts.AddHostTag(database, auth.Caller(c).WorkspaceID, req.Host, req.Tag)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusCreated).JSON(tag)
+	})
+
+	app.Delete("/host-tags/:id", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermAlertsWrite), func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		if err := alerts.DeleteHostTag(database, auth.Caller(c).WorkspaceID, id); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(404).JSON(fiber.Map{"error": "host tag not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"message": "deleted"})
+	})
+}
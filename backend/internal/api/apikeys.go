@@ -0,0 +1,74 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+func setupAPIKeyRoutes(app *fiber.App, database *sqlx.DB) {
+	app.Get("/api-keys", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		keys, err := auth.ListAPIKeys(database, auth.Caller(c).UserID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(keys)
+	})
+
+	app.Post("/api-keys", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		// A leaked key must not be able to mint further keys.
+		if auth.ViaAPIKey(c) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "api keys cannot create api keys"})
+		}
+		var req struct {
+			Name          string     `json:"name"`
+			Permissions   []string   `json:"permissions"`
+			ExpiresAt     *time.Time `json:"expires_at"`
+			ExpiresInDays int        `json:"expires_in_days"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "name is required"})
+		}
+		if req.ExpiresAt == nil && req.ExpiresInDays > 0 {
+			exp := time.Now().AddDate(0, 0, req.ExpiresInDays)
+			req.ExpiresAt = &exp
+		}
+
+		key, plaintext, err := auth.CreateAPIKey(database, auth.Caller(c).UserID, req.Name, req.Permissions, req.ExpiresAt)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionAPIKeyCreate, ResourceType: "api_key", ResourceID: fmt.Sprint(key.ID),
+			After: key,
+		})
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"key": plaintext, "api_key": key})
+	})
+
+	app.Delete("/api-keys/:id", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		if err := auth.RevokeAPIKey(database, auth.Caller(c).UserID, id); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(404).JSON(fiber.Map{"error": "api key not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionAPIKeyRevoke, ResourceType: "api_key", ResourceID: fmt.Sprint(id),
+		})
+		return c.JSON(fiber.Map{"message": "revoked"})
+	})
+}
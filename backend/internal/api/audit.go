@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
+	"github.com/KuberTheGreat/Sentrinet/internal/scheduler"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+// record writes an audit event for the current request, filling in the
+// caller, their active workspace and the client IP.
+func record(c *fiber.Ctx, database *sqlx.DB, e audit.Event) {
+	caller := auth.Caller(c)
+	e.ActorID = caller.UserID
+	if e.WorkspaceID == 0 {
+		e.WorkspaceID = caller.WorkspaceID
+	}
+	e.IP = c.IP()
+	audit.Record(database, e)
+}
+
+func recordJob(c *fiber.Ctx, database *sqlx.DB, action string, job scheduler.JobRow, before, after interface{}) {
+	record(c, database, audit.Event{
+		Action: action, ResourceType: "job", ResourceID: strconv.FormatInt(job.ID, 10), Target: job.Target,
+		WorkspaceID: job.WorkspaceID, Before: before, After: after,
+	})
+}
+
+func auditFilter(c *fiber.Ctx) (audit.Filter, error) {
+	f := audit.Filter{
+		Action:       c.Query("action"),
+		ResourceType: c.Query("resource_type"),
+		ResourceID:   c.Query("resource_id"),
+		IP:           c.Query("ip"),
+		Limit:        c.QueryInt("limit", 50),
+		Offset:       c.QueryInt("offset", 0),
+	}
+	if v := c.Query("actor_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return f, fiber.NewError(fiber.StatusBadRequest, "invalid actor_id")
+		}
+		f.ActorID = id
+	}
+	if v := c.Query("workspace_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return f, fiber.NewError(fiber.StatusBadRequest, "invalid workspace_id")
+		}
+		f.WorkspaceID = id
+	}
+	for name, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, fiber.NewError(fiber.StatusBadRequest, "invalid "+name+", want RFC 3339")
+			}
+			*dst = t
+		}
+	}
+	if f.Limit <= 0 || f.Limit > 500 {
+		f.Limit = 50
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+	return f, nil
+}
+
+func setupAuditRoutes(admin fiber.Router, database *sqlx.DB) {
+	admin.Get("/audit", auth.RequirePermission(auth.PermAuditRead), func(c *fiber.Ctx) error {
+		f, err := auditFilter(c)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		rows, total, err := audit.List(database, f)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"limit": f.Limit, "offset": f.Offset, "total": total, "data": rows})
+	})
+
+	// Export ignores paging and returns every matching event as a download.
+	admin.Get("/audit/export", auth.RequirePermission(auth.PermAuditRead), func(c *fiber.Ctx) error {
+		f, err := auditFilter(c)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		f.Limit, f.Offset = 0, 0
+		rows, _, err := audit.List(database, f)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		name := "sentrinet-audit-" + time.Now().UTC().Format("20060102T150405")
+		if c.Query("format", "json") != "csv" {
+			c.Attachment(name + ".json")
+			return c.JSON(rows)
+		}
+
+		c.Attachment(name + ".csv")
+		c.Set(fiber.HeaderContentType, "text/csv")
+		w := csv.NewWriter(c.Response().BodyWriter())
+		_ = w.Write([]string{"id", "created_at", "actor_id", "workspace_id", "action", "resource_type",
+			"resource_id", "target", "ip", "detail", "before", "after"})
+		str := func(s *string) string {
+			if s == nil {
+				return ""
+			}
+			return csvSafe(*s)
+		}
+		num := func(n *int64) string {
+			if n == nil {
+				return ""
+			}
+			return fmt.Sprint(*n)
+		}
+		for _, r := range rows {
+			_ = w.Write([]string{fmt.Sprint(r.ID), r.CreatedAt.UTC().Format(time.RFC3339), num(r.ActorID),
+				num(r.WorkspaceID), r.Action, str(r.ResourceType), str(r.ResourceID), str(r.Target), str(r.IP),
+				str(r.Detail), str(r.Before), str(r.After)})
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
+
+// csvSafe keeps a spreadsheet from evaluating a cell as a formula. Audit
+// events hold text chosen by whoever triggered them, such as the username of
+// a failed login.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
+	"github.com/KuberTheGreat/Sentrinet/internal/chatops"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+// channelRequest is the editable part of a chat channel. Enabled defaults to
+// true, RateLimit to 20 messages per hour; an update without URL keeps the
+// stored one.
+type channelRequest struct {
+	Name        string   `json:"name"`
+	Kind        string   `json:"kind"`
+	URL         string   `json:"url"`
+	Enabled     *bool    `json:"enabled"`
+	Types       []string `json:"types"`
+	RuleIDs     []int64  `json:"rule_ids"`
+	MinSeverity string   `json:"min_severity"`
+	RateLimit   *int     `json:"rate_limit_per_hour"`
+}
+
+func (req channelRequest) apply(ch *chatops.Channel) {
+	ch.Name, ch.Kind, ch.MinSeverity = req.Name, req.Kind, req.MinSeverity
+	ch.Types, ch.RuleIDs = req.Types, req.RuleIDs
+	if req.URL != "" {
+		ch.URL = req.URL
+	}
+	ch.Enabled = req.Enabled == nil || *req.Enabled
+	if req.RateLimit != nil {
+		ch.RateLimit = *req.RateLimit
+	} else if ch.ID == 0 {
+		ch.RateLimit = 20
+	}
+}
+
+func setupChannelRoutes(app *fiber.App, database *sqlx.DB, inWorkspace fiber.Handler) {
+	app.Get("/notification-channels", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), func(c *fiber.Ctx) error {
+		channels, err := chatops.List(database, auth.Caller(c).WorkspaceID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(channels)
+	})
+
+	app.Post("/notification-channels", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermAlertsWrite), func(c *fiber.Ctx) error {
+		var req channelRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		caller := auth.Caller(c)
+		ch := chatops.Channel{WorkspaceID: caller.WorkspaceID, CreatedBy: &caller.UserID}
+		req.apply(&ch)
+		ch, err := chatops.Create(database, ch)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionChannelCreate, ResourceType: "notification_channel", ResourceID: strconv.FormatInt(ch.ID, 10),
+			After: ch,
+		})
+		return c.Status(fiber.StatusCreated).JSON(ch)
+	})
+
+	app.Put("/notification-channels/:id", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermAlertsWrite), func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		var req channelRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		before, err := chatops.Get(database, auth.Caller(c).WorkspaceID, id)
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "channel not found"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		ch := before
+		req.apply(&ch)
+		ch, err = chatops.Update(database, ch)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionChannelUpdate, ResourceType: "notification_channel", ResourceID: strconv.FormatInt(id, 10),
+			Before: before, After: ch,
+		})
+		return c.JSON(ch)
+	})
+
+	app.Delete("/notification-channels/:id", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermAlertsWrite), func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		if err := chatops.Delete(database, auth.Caller(c).WorkspaceID, id); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(404).JSON(fiber.Map{"error": "channel not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionChannelDelete, ResourceType: "notification_channel", ResourceID: strconv.FormatInt(id, 10),
+		})
+		return c.JSON(fiber.Map{"message": "deleted"})
+	})
+
+	// Test posts a sample message right away and reports the chat service's
+	// answer.
+	app.Post("/notification-channels/:id/test", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermAlertsWrite), func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		ch, err := chatops.Get(database, auth.Caller(c).WorkspaceID, id)
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "channel not found"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := chatops.Test(database, ch); err != nil {
+			return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"status": chatops.StatusSent})
+	})
+
+	app.Get("/notification-channels/:id/messages", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		if _, err := chatops.Get(database, auth.Caller(c).WorkspaceID, id); err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "channel not found"})
+		} else if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		limit := c.QueryInt("limit", 50)
+		if limit <= 0 || limit > 500 {
+			limit = 50
+		}
+		messages, err := chatops.Messages(database, id, limit)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(messages)
+	})
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
+	"github.com/KuberTheGreat/Sentrinet/internal/email"
+	"github.com/KuberTheGreat/Sentrinet/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+// setupEmailRoutes registers the caller's email preferences and endpoints to
+// try them out against the configured SMTP server.
+func setupEmailRoutes(app *fiber.App, database *sqlx.DB) {
+	app.Get("/email/preferences", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		p, err := email.GetPreferences(database, auth.Caller(c).UserID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"smtp_enabled": email.Enabled(), "preferences": p})
+	})
+
+	app.Put("/email/preferences", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		var p email.Preferences
+		if err := c.BodyParser(&p); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		p.UserID = auth.Caller(c).UserID
+		p, err := email.SetPreferences(database, p)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(p)
+	})
+
+	// Test sends a sample notification email to the caller's address and
+	// reports the SMTP error, if any.
+	app.Post("/email/test", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		m, p, err := emailTarget(c, database)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		err = m.SendNotification(p.Email, models.Notification{
+			UserID: int(p.UserID), Type: "test", Severity: models.SeverityInfo,
+			Message: "This is a test email from Sentrinet.", CreatedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"status": "sent", "to": p.Email})
+	})
+
+	// Digest sends the digest of the period ending now, even when it is empty,
+	// and returns its content. The regular schedule is not affected.
+	app.Post("/email/digest", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		m, p, err := emailTarget(c, database)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		d, err := m.SendDigestNow(p, time.Now().UTC())
+		if err != nil {
+			return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(d)
+	})
+}
+
+// emailTarget returns the mailer and the caller's preferences, or an error if
+// email is disabled or the caller has no address.
+func emailTarget(c *fiber.Ctx, database *sqlx.DB) (*email.Mailer, email.Preferences, error) {
+	m := email.Default()
+	if m == nil {
+		return nil, email.Preferences{}, fiber.NewError(fiber.StatusServiceUnavailable, "email is not configured")
+	}
+	p, err := email.GetPreferences(database, auth.Caller(c).UserID)
+	if err != nil {
+		return nil, p, err
+	}
+	if p.Email == "" {
+		return nil, p, fiber.NewError(fiber.StatusBadRequest, "set an email address in your preferences first")
+	}
+	return m, p, nil
+}
@@ -1,17 +1,19 @@
 package api
 
 import (
-	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/KuberTheGreat/Sentrinet/internal/alerts"
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
 	"github.com/KuberTheGreat/Sentrinet/internal/auth"
 	"github.com/KuberTheGreat/Sentrinet/internal/handlers"
 	"github.com/KuberTheGreat/Sentrinet/internal/models"
+	"github.com/KuberTheGreat/Sentrinet/internal/quota"
 	"github.com/KuberTheGreat/Sentrinet/internal/realtime"
 	"github.com/KuberTheGreat/Sentrinet/internal/scan"
 	"github.com/KuberTheGreat/Sentrinet/internal/scheduler"
@@ -26,53 +28,85 @@ type ScanRequest struct {
 	EndPort int `json:"end_port"`
 }
 
-func SetupRoutes(app *fiber.App, db *sqlx.DB, wsManager *realtime.Manager){
-	app.Post("/scan", auth.JWTMiddleware, func(c *fiber.Ctx) error {
-		userID := c.Locals("user_id").(int64)
-		println("User id: ", userID)
+func SetupRoutes(app *fiber.App, db *sqlx.DB, wsManager *realtime.Manager, schManager *scheduler.Manager){
+	inWorkspace := auth.WorkspaceMiddleware(db)
+	app.Use(auth.APIKeyMiddleware(db))
+
+	app.Post("/scan", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermScansRun), func(c *fiber.Ctx) error {
+		caller := auth.Caller(c)
 		var req ScanRequest
 		if err := c.BodyParser(&req); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
 
+		if err := checkTarget(c, db, req.Target); err != nil {
+			return auth.JSONError(c, err)
+		}
+		release, err := quota.Acquire(db, caller.UserID, caller.WorkspaceID)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		defer release()
+		if req.EndPort >= req.StartPort {
+			if err := quota.UsePorts(db, caller.UserID, caller.WorkspaceID, int64(req.EndPort-req.StartPort+1)); err != nil {
+				return auth.JSONError(c, err)
+			}
+		}
+		record(c, db, audit.Event{
+			Action: audit.ActionScanRun, ResourceType: "target", ResourceID: req.Target, Target: req.Target,
+			After: req,
+		})
+
 		results := scan.ScanRange(req.Target, req.StartPort, req.EndPort)
+		scanIDs := []int64{}
+		failed := false
 		for _, r := range results{
 			res, err := db.NamedExec(
-				"INSERT INTO scans (target, port, is_open, duration_ms, user_id) VALUES (:target, :port, :is_open, :duration_ms, :user_id)",
+				"INSERT INTO scans (target, port, is_open, duration_ms, user_id, workspace_id) VALUES (:target, :port, :is_open, :duration_ms, :user_id, :workspace_id)",
 				map[string]interface{}{
 					"target": req.Target,
 					"port": r.Port,
 					"is_open": r.IsOpen,
 					"duration_ms": r.Duration,
-					"user_id": userID,
+					"user_id": caller.UserID,
+					"workspace_id": caller.WorkspaceID,
 				},
 			)
 
 			if err != nil{
-				handlers.CreateNotification(db, 1, 1, "scan_failed", fmt.Sprintf("Scan for %s failed to complete.", req.Target))
+				failed = true
 				fmt.Println("Insert error: ", err)
 			} else{
 				id, _ := res.LastInsertId()
 				fmt.Println("Inserted row ID:", id)
+				scanIDs = append(scanIDs, id)
 			}
 		}
+		if failed{
+			handlers.CreateNotification(db, int(caller.UserID), caller.WorkspaceID, 0, "scan_failed", fmt.Sprintf("Scan for %s failed to complete.", req.Target))
+		}
+		if err := alerts.EvaluateScans(db, caller.WorkspaceID, scanIDs); err != nil{
+			fmt.Println("Alert evaluation error: ", err)
+		}
 
+		// Results belong to the caller; connections of other users and
+		// anonymous ones must not see them.
 		data, _ := json.Marshal(results)
-		wsManager.Broadcast("Scan complete", data)
+		wsManager.SendToUser(caller.UserID, "Scan complete", data)
 
 		return c.JSON(results)
 	})
 
-	app.Get("/scans", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+	app.Get("/scans", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermScansRead), func(c *fiber.Ctx) error {
 		target := c.Query("target", "")
 		openOnly := c.Query("open_only", "")
 
-		userID := c.Locals("user_id").(int64)
-		
-		
 		args := []interface{}{}
-		query := `SELECT * FROM scans WHERE user_id = ?`
-		args = append(args, userID)
+		query := `SELECT * FROM scans WHERE 1 = 1`
+		if ws := auth.WorkspaceFilter(c); ws != 0{
+			query += " AND workspace_id = ?"
+			args = append(args, ws)
+		}
 
 		if target != ""{
 			query += " AND target LIKE ?"
@@ -95,20 +129,27 @@ func SetupRoutes(app *fiber.App, db *sqlx.DB, wsManager *realtime.Manager){
 		return c.JSON(scans)
 	})
 
-	app.Get("/stats", func(c *fiber.Ctx) error {
+	app.Get("/stats", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermScansRead), func(c *fiber.Ctx) error {
 		var totalScans int
 		var openPorts int
 		var avgDuration float64
 
-		if err := db.Get(&totalScans, "SELECT COUNT(*) FROM scans"); err != nil {
+		where := "WHERE 1 = 1"
+		args := []interface{}{}
+		if ws := auth.WorkspaceFilter(c); ws != 0{
+			where = "WHERE workspace_id = ?"
+			args = append(args, ws)
+		}
+
+		if err := db.Get(&totalScans, "SELECT COUNT(*) FROM scans "+where, args...); err != nil {
 			totalScans = 0
 		}
 
-		if err := db.Get(&openPorts, "SELECT COUNT(*) FROM scans WHERE is_open = true"); err != nil{
+		if err := db.Get(&openPorts, "SELECT COUNT(*) FROM scans "+where+" AND is_open = true", args...); err != nil{
 			openPorts = 0
 		}
 
-		if err := db.Get(&avgDuration, "SELECT AVG(duration_ms) FROM scans"); err != nil{
+		if err := db.Get(&avgDuration, "SELECT COALESCE(AVG(duration_ms), 0) FROM scans "+where, args...); err != nil{
 			avgDuration = 0
 		}
 
@@ -121,67 +162,97 @@ func SetupRoutes(app *fiber.App, db *sqlx.DB, wsManager *realtime.Manager){
 		return c.JSON(stats)
 	})
 
-	app.Delete("/scans/:id", func(c *fiber.Ctx) error {
+	app.Delete("/scans/:id", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermScansDelete), func(c *fiber.Ctx) error {
 		id := c.Params("id")
-		_, err := db.Exec("DELETE FROM scans WHERE id = ?", id)
+		where := " WHERE id = ?"
+		args := []interface{}{id}
+		if caller := auth.Caller(c); !caller.IsAdmin(){
+			where += " AND workspace_id = ?"
+			args = append(args, caller.WorkspaceID)
+		}
+
+		var before models.ScanResult
+		if err := db.Get(&before, "SELECT * FROM scans"+where, args...); err != nil{
+			if err == sql.ErrNoRows{
+				return c.Status(404).JSON(fiber.Map{"error": "scan not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		res, err := db.Exec("DELETE FROM scans"+where, args...)
 		if err != nil{
 			log.Println("Delete error: ", err)
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to delete record"})
 		}
+		if count, _ := res.RowsAffected(); count == 0{
+			return c.Status(404).JSON(fiber.Map{"error": "scan not found"})
+		}
+		record(c, db, audit.Event{
+			Action: audit.ActionScanDelete, ResourceType: "scan", ResourceID: id, Target: before.Target,
+			WorkspaceID: before.WorkspaceID, Before: before,
+		})
 
 		return c.JSON(fiber.Map{"message": fmt.Sprintf("Deleted scan with id %s", id)})
 	})
 
-	app.Delete("/scans", func(c *fiber.Ctx) error{
+	app.Delete("/scans", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermScansDelete), func(c *fiber.Ctx) error{
 		target := c.Query("target", "")
 		if target == ""{
 			return c.Status(400).JSON(fiber.Map{"error": "target query parameter required"})
 		}
 
-		res, err := db.Exec("DELETE FROM scans WHERE target = ?", target)
+		query := "DELETE FROM scans WHERE target = ?"
+		args := []interface{}{target}
+		if ws := auth.WorkspaceFilter(c); ws != 0{
+			query += " AND workspace_id = ?"
+			args = append(args, ws)
+		}
+
+		res, err := db.Exec(query, args...)
 		if err != nil{
 			log.Println("Delete error: ", err)
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to delete records"})
 		}
 
 		count, _ := res.RowsAffected()
+		record(c, db, audit.Event{
+			Action: audit.ActionScanDeleteTarget, ResourceType: "target", ResourceID: target, Target: target,
+			Before: fiber.Map{"scans": count},
+		})
 		return c.JSON(fiber.Map{"message": fmt.Sprintf("Delete %d scans for target %s", count, target)})
 	})
 
-	schManager := scheduler.NewManager(context.Background(), db)
-	if err := schManager.LoadAndStartAll(); err != nil {
-		fmt.Println("[Scheduler] failed to load jobs: ", err)
-	}
-
-	app.Post("/schedules", auth.JWTMiddleware, func(c *fiber.Ctx) error {
-		var req struct{
-			Target    string `json:"target"`
-			StartPort int    `json:"start_port"`
-			EndPort   int    `json:"end_port"`
-			IntervalSeconds int `json:"interval_seconds"`
-			Active    bool   `json:"active"`
-			UserID int `json:"user_id"`
-		}
+	app.Post("/schedules", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesWrite), func(c *fiber.Ctx) error {
+		var req scheduler.JobSpec
 
-		userID := c.Locals("user_id").(int64)
+		caller := auth.Caller(c)
 		
 		if err := c.BodyParser(&req); err != nil{
 			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
-		if req.Target == "" || req.StartPort <= 0 || req.EndPort <= 0 || req.IntervalSeconds <= 0 {
-			return c.Status(400).JSON(fiber.Map{"error": "target, ports and interval seconds are required"})
+		if err := req.Validate(); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := checkTarget(c, db, req.Target); err != nil {
+			return auth.JSONError(c, err)
+		}
+		if err := quota.CheckSchedule(db, caller.UserID, caller.WorkspaceID, 0, req.IntervalSeconds, req.PortsPerRun(), req.Active); err != nil {
+			return auth.JSONError(c, err)
 		}
-		interval := time.Duration(req.IntervalSeconds) * time.Second
-		id, err := schManager.CreateJob(req.Target, req.StartPort, req.EndPort, interval, req.Active,userID)
+		id, err := schManager.CreateJob(req, caller.UserID, caller.WorkspaceID)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
+		record(c, db, audit.Event{
+			Action: audit.ActionScheduleCreate, ResourceType: "job", ResourceID: fmt.Sprint(id), Target: req.Target,
+			After: req,
+		})
 
 		return c.JSON(fiber.Map{"id": id})
 	})
 
-	app.Get("/schedules", func(c *fiber.Ctx) error {
-		rows, err := schManager.ListJobs()
+	app.Get("/schedules", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesRead), func(c *fiber.Ctx) error {
+		rows, err := schManager.ListJobs(auth.WorkspaceFilter(c))
 		if err != nil{
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
@@ -189,53 +260,178 @@ func SetupRoutes(app *fiber.App, db *sqlx.DB, wsManager *realtime.Manager){
 		return c.JSON(rows)
 	})
 
-	app.Post("/schedules/:id/stop", func(c *fiber.Ctx) error{
-		idStr := c.Params("id")
-		id, err := strconv.ParseInt(idStr, 10, 64)
+	app.Get("/schedules/queue", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesRead), func(c *fiber.Ctx) error {
+		return c.JSON(schManager.QueueStatus(auth.WorkspaceFilter(c)))
+	})
+
+	app.Post("/schedules/:id/run", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesWrite), func(c *fiber.Ctx) error {
+		job, err := callerJob(c, schManager)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		// The run is charged when it starts; one the daily quota cannot cover
+		// is refused here rather than left to fail in the queue.
+		if err := quota.CheckPorts(db, job.UserID, job.WorkspaceID, job.Spec().PortsPerRun()); err != nil {
+			return auth.JSONError(c, err)
+		}
+		runID, err := schManager.RunNow(job.ID)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return c.Status(404).JSON(fiber.Map{"error": "job not found"})
+			case scheduler.ErrJobBusy:
+				return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		recordJob(c, db, audit.ActionScheduleRunNow, job, nil, fiber.Map{"run_id": runID})
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"run_id": runID})
+	})
+
+	app.Get("/schedules/:id/runs", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesRead), func(c *fiber.Ctx) error {
+		job, err := callerJob(c, schManager)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		limit, err := strconv.Atoi(c.Query("limit", "20"))
+		if err != nil || limit <= 0 {
+			limit = 20
+		}
+		runs, err := schManager.ListRuns(job.ID, limit)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(runs)
+	})
+
+	app.Get("/schedules/:id/runs/:runId/stages", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesRead), func(c *fiber.Ctx) error {
+		job, err := callerJob(c, schManager)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		runID, err := paramID(c, "runId")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		if _, err := schManager.GetRun(job.ID, runID); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(404).JSON(fiber.Map{"error": "run not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		stages, err := schManager.ListStageRuns(runID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		probes, err := schManager.ListProbes(runID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"stages": stages, "probes": probes})
+	})
+
+	app.Post("/schedules/:id/stop", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesWrite), func(c *fiber.Ctx) error{
+		job, err := callerJob(c, schManager)
 		if err != nil{
-			return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+			return auth.JSONError(c, err)
 		}
-		if err := schManager.StopJob(id); err != nil{
+		if err := schManager.StopJob(job.ID); err != nil{
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
+		recordJob(c, db, audit.ActionScheduleStop, job, fiber.Map{"active": job.Active == 1}, fiber.Map{"active": false})
 
 		return c.JSON(fiber.Map{"message": "stopped"})
 	})
 
-	app.Post("/schedules/:id/start", func(c *fiber.Ctx) error {
-		idStr := c.Params("id")
-		id, err := strconv.ParseInt(idStr, 10, 64)
+	app.Post("/schedules/:id/start", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesWrite), func(c *fiber.Ctx) error {
+		job, err := callerJob(c, schManager)
 		if err != nil {
-			return c.Status(400).JSON(fiber.Map{"error":"invalid id"})
+			return auth.JSONError(c, err)
 		}
-		if err := schManager.StartJobByID(id); err != nil {
+		if err := quota.CheckSchedule(db, job.UserID, job.WorkspaceID, job.ID, job.IntervalSeconds, job.Spec().PortsPerRun(), true); err != nil {
+			return auth.JSONError(c, err)
+		}
+		if err := schManager.StartJobByID(job.ID); err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
+		recordJob(c, db, audit.ActionScheduleStart, job, fiber.Map{"active": job.Active == 1}, fiber.Map{"active": true})
 		return c.JSON(fiber.Map{"message":"started"})
 	})
 
-	app.Delete("/schedules/:id", func(c *fiber.Ctx) error {
-		idStr := c.Params("id")
-		id, err := strconv.ParseInt(idStr, 10, 64)
+	app.Patch("/schedules/:id", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesWrite), func(c *fiber.Ctx) error {
+		current, err := callerJob(c, schManager)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+
+		var req scheduler.JobUpdate
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if req.Target != nil {
+			if err := checkTarget(c, db, *req.Target); err != nil {
+				return auth.JSONError(c, err)
+			}
+		}
+		// Quotas apply to the schedule as it will be after the update.
+		next, err := req.Apply(current)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		spec := next.Spec()
+		if err := spec.Validate(); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := quota.CheckSchedule(db, current.UserID, current.WorkspaceID, current.ID, spec.IntervalSeconds, spec.PortsPerRun(), spec.Active); err != nil {
+			return auth.JSONError(c, err)
+		}
+
+		job, err := schManager.UpdateJob(current.ID, req)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		recordJob(c, db, audit.ActionScheduleUpdate, current, current.Spec(), job.Spec())
+		return c.JSON(job)
+	})
+
+	app.Delete("/schedules/:id", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesWrite), func(c *fiber.Ctx) error {
+		job, err := callerJob(c, schManager)
 		if err != nil {
-			return c.Status(400).JSON(fiber.Map{"error":"invalid id"})
+			return auth.JSONError(c, err)
 		}
-		if err := schManager.DeleteJob(id); err != nil {
+		if err := schManager.DeleteJob(job.ID); err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
+		recordJob(c, db, audit.ActionScheduleDelete, job, job.Spec(), nil)
 		return c.JSON(fiber.Map{"message":"deleted"})
 	})
 
-	app.Get("/api/scans", handlers.GetScansHandler(db))
-	app.Get("/api/jobs", handlers.GetJobsHandler(db))
+	app.Get("/api/scans", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermScansRead), handlers.GetScansHandler(db))
+	app.Get("/api/jobs", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesRead), handlers.GetJobsHandler(db))
 
 	//Notifications
-	app.Get("/notifications/:userId", handlers.GetUserNotifications(db))
-	app.Put("/notifications/:id/read", handlers.MarkNotificationRead(db))
+	app.Get("/notifications", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), handlers.GetUserNotifications(db))
+	app.Get("/notifications/unread-count", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), handlers.UnreadNotificationCount(db))
+	app.Post("/notifications/read-all", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), handlers.MarkAllNotificationsRead(db))
+	app.Post("/notifications/delete", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), handlers.DeleteNotifications(db))
+	app.Put("/notifications/:id/read", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), handlers.MarkNotificationRead(db))
 
 	//Websocket endpoints
-	app.Get("/ws", websocket.New(func(c *websocket.Conn) {
-		wsManager.Register(c)
+	// Browsers cannot set headers on a WebSocket handshake, so the token comes
+	// as ?token=. Only connections with a token receive the user's scan
+	// results and notifications; those without one only get broadcasts.
+	app.Get("/ws", func(c *fiber.Ctx) error {
+		token := c.Query("token")
+		if token == "" {
+			return c.Next()
+		}
+		c.Request().Header.Set("Authorization", "Bearer "+token)
+		return auth.JWTMiddleware(c)
+	}, websocket.New(func(c *websocket.Conn) {
+		userID, _ := c.Locals("user_id").(int64)
+		wsManager.Register(c, userID)
 		defer wsManager.Unregister(c)
 		for {
 			_, msg, err := c.ReadMessage()
@@ -249,28 +445,39 @@ func SetupRoutes(app *fiber.App, db *sqlx.DB, wsManager *realtime.Manager){
 	//Authentication
 
 	authHandler := auth.NewAuthHandler(db)
+	auth.UseSessions(db)
 	
 	app.Post("/register", authHandler.Register)
 	app.Post("/login", authHandler.Login)
+	app.Post("/refresh", authHandler.Refresh)
+	app.Post("/logout", auth.JWTMiddleware, authHandler.Logout)
+	app.Post("/login/2fa", authHandler.LoginMFA)
+	if cfg := auth.OIDCConfigFromEnv(); cfg != nil {
+		sso := auth.NewOIDC(db, *cfg)
+		app.Get("/oidc/login", sso.Login)
+		app.Get("/oidc/callback", sso.Callback)
+	}
+	app.Post("/2fa/enroll", auth.JWTMiddleware, authHandler.EnrollTOTP)
+	app.Post("/2fa/confirm", auth.JWTMiddleware, authHandler.ConfirmTOTP)
+	app.Post("/2fa/disable", auth.JWTMiddleware, authHandler.DisableTOTP)
+	app.Post("/2fa/recovery-codes", auth.JWTMiddleware, authHandler.RegenerateRecoveryCodes)
+	app.Get("/.well-known/jwks.json", func(c *fiber.Ctx) error {
+		return c.JSON(auth.JWKS())
+	})
 	
 	app.Get("/secure", auth.JWTMiddleware, func (c *fiber.Ctx) error {
-		userID := c.Locals("user_id")
-		return c.JSON(fiber.Map{"message": "Hello user!", "id": userID})
+		caller := auth.Caller(c)
+		return c.JSON(fiber.Map{"message": "Hello user!", "id": caller.UserID, "role": caller.Role, "permissions": caller.Permissions})
 	})
 
-	app.Get("/admin/cleanup-logs", func(c *fiber.Ctx) error {
-		logs := []struct {
-			ID           int64     `db:"id" json:"id"`
-			DeletedCount int64     `db:"deleted_count" json:"deleted_count"`
-			RunTimeMs    int64     `db:"run_time_ms" json:"run_time_ms"`
-			CreatedAt    time.Time `db:"created_at" json:"created_at"`
-		}{}
-
-		err := db.Select(&logs, "SELECT * FROM cleanup_logs ORDER BY created_at DESC LIMIT 20")
-		if err != nil{
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-		}
-
-		return c.JSON(logs)
-	})
+	setupWorkspaceRoutes(app, db)
+	setupAPIKeyRoutes(app, db)
+	setupVerificationRoutes(app, db, inWorkspace)
+	setupUsageRoutes(app, db, inWorkspace)
+	setupAlertRoutes(app, db, inWorkspace)
+	setupWebhookRoutes(app, db, inWorkspace)
+	setupChannelRoutes(app, db, inWorkspace)
+	setupEmailRoutes(app, db)
+	setupPreferenceRoutes(app, db)
+	setupAdminRoutes(app, db)
 }
\ No newline at end of file
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
+	"github.com/KuberTheGreat/Sentrinet/internal/prefs"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+// groupRequest names a notification group by its key or by one of its
+// notifications.
+type groupRequest struct {
+	Key            string `json:"key"`
+	NotificationID int64  `json:"notification_id"`
+}
+
+func (req groupRequest) key(c *fiber.Ctx, database *sqlx.DB) (string, error) {
+	if req.Key != "" {
+		return req.Key, nil
+	}
+	if req.NotificationID == 0 {
+		return "", fiber.NewError(fiber.StatusBadRequest, "key or notification_id is required")
+	}
+	key, err := prefs.KeyOf(database, auth.Caller(c).UserID, req.NotificationID)
+	if err == sql.ErrNoRows {
+		return "", fiber.NewError(fiber.StatusNotFound, "notification not found or not grouped")
+	}
+	return key, err
+}
+
+func groupResult(c *fiber.Ctx, g prefs.Group, err error) error {
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "notification group not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(g)
+}
+
+// setupPreferenceRoutes registers the caller's notification preferences and
+// the groups deduplicated notifications are collected in.
+func setupPreferenceRoutes(app *fiber.App, database *sqlx.DB) {
+	app.Get("/notification-preferences", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		p, err := prefs.Get(database, auth.Caller(c).UserID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(p)
+	})
+
+	// The body replaces every preference; renotify_seconds defaults to the
+	// server wide interval and types left out go to every channel.
+	app.Put("/notification-preferences", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		var req struct {
+			RenotifySeconds *int              `json:"renotify_seconds"`
+			Types           []prefs.TypePrefs `json:"types"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		p := prefs.Preferences{RenotifySeconds: int(prefs.DefaultRenotify() / time.Second), Types: req.Types}
+		if req.RenotifySeconds != nil {
+			p.RenotifySeconds = *req.RenotifySeconds
+		}
+		p, err := prefs.Set(database, auth.Caller(c).UserID, p)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(p)
+	})
+
+	// Groups are listed most recently seen first, optionally only those
+	// ?state=snoozed or acknowledged.
+	app.Get("/notification-groups", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		limit := c.QueryInt("limit", 100)
+		if limit <= 0 || limit > 500 {
+			limit = 100
+		}
+		groups, err := prefs.ListGroups(database, auth.Caller(c).UserID, c.Query("state"), limit)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(groups)
+	})
+
+	// Snooze silences a group for duration_seconds; 0 ends the snooze.
+	app.Post("/notification-groups/snooze", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		var req struct {
+			groupRequest
+			DurationSeconds int `json:"duration_seconds"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if req.DurationSeconds < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "duration_seconds must not be negative"})
+		}
+		key, err := req.key(c, database)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		var until time.Time
+		if req.DurationSeconds > 0 {
+			until = time.Now().Add(time.Duration(req.DurationSeconds) * time.Second)
+		}
+		g, err := prefs.Snooze(database, auth.Caller(c).UserID, key, until)
+		return groupResult(c, g, err)
+	})
+
+	// Acknowledge silences a group until the finding clears; send
+	// "acknowledged": false to lift it.
+	app.Post("/notification-groups/acknowledge", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		var req struct {
+			groupRequest
+			Acknowledged *bool `json:"acknowledged"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		key, err := req.key(c, database)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		g, err := prefs.Acknowledge(database, auth.Caller(c).UserID, key, req.Acknowledged == nil || *req.Acknowledged)
+		return groupResult(c, g, err)
+	})
+}
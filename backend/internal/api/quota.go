@@ -0,0 +1,83 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
+	"github.com/KuberTheGreat/Sentrinet/internal/quota"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+func setupUsageRoutes(app *fiber.App, database *sqlx.DB, inWorkspace fiber.Handler) {
+	// Usage reports the caller's own consumption and that of the active
+	// workspace against their quotas.
+	app.Get("/usage", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermScansRead), func(c *fiber.Ctx) error {
+		caller := auth.Caller(c)
+		user, err := quota.UsageOf(database, quota.ScopeUser, caller.UserID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		ws, err := quota.UsageOf(database, quota.ScopeWorkspace, caller.WorkspaceID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"workspace_id": caller.WorkspaceID, "user": user, "workspace": ws})
+	})
+}
+
+func setupQuotaRoutes(admin fiber.Router, database *sqlx.DB) {
+	admin.Get("/quotas", auth.RequirePermission(auth.PermQuotasManage), func(c *fiber.Ctx) error {
+		overrides := []struct {
+			Scope     string `db:"scope" json:"scope"`
+			SubjectID int64  `db:"subject_id" json:"subject_id"`
+			quota.Override
+		}{}
+		if err := database.Select(&overrides, `SELECT scope, subject_id, ports_per_day, concurrent_scans,
+			active_schedules, min_interval_seconds FROM quotas ORDER BY scope, subject_id`); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{
+			"defaults": fiber.Map{
+				quota.ScopeUser:      quota.Defaults(quota.ScopeUser),
+				quota.ScopeWorkspace: quota.Defaults(quota.ScopeWorkspace),
+			},
+			"overrides": overrides,
+		})
+	})
+
+	// Fields left out of the body fall back to the defaults; an empty body
+	// removes the override.
+	admin.Put("/quotas/:scope/:id", auth.RequirePermission(auth.PermQuotasManage), func(c *fiber.Ctx) error {
+		scope := c.Params("scope")
+		if scope != quota.ScopeUser && scope != quota.ScopeWorkspace {
+			return c.Status(400).JSON(fiber.Map{"error": "scope must be user or workspace"})
+		}
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		var req quota.Override
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		before, err := quota.Get(database, scope, id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := quota.Set(database, scope, id, req); err != nil {
+			return auth.JSONError(c, err)
+		}
+		after, err := quota.Get(database, scope, id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionQuota, ResourceType: scope, ResourceID: strconv.FormatInt(id, 10),
+			Before: before, After: after,
+		})
+		return c.JSON(after)
+	})
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
+	"github.com/KuberTheGreat/Sentrinet/internal/targets"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+// checkTarget enforces the target rules and ownership verification for a
+// scan or schedule requested by the caller. Rejections are audited and
+// reported as 403.
+func checkTarget(c *fiber.Ctx, database *sqlx.DB, target string) error {
+	err := targets.Authorize(database, auth.Caller(c).WorkspaceID, target)
+	if denied, ok := err.(*targets.DeniedError); ok {
+		record(c, database, audit.Event{
+			Action: audit.ActionTargetDenied, ResourceType: "target", ResourceID: target, Target: target,
+			Detail: denied.Reason,
+		})
+		return fiber.NewError(fiber.StatusForbidden, denied.Error())
+	}
+	return err
+}
+
+func setupTargetRoutes(admin fiber.Router, database *sqlx.DB) {
+	admin.Get("/target-rules", auth.RequirePermission(auth.PermTargetsManage), func(c *fiber.Ctx) error {
+		rules, err := targets.ListRules(database)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(rules)
+	})
+
+	admin.Post("/target-rules", auth.RequirePermission(auth.PermTargetsManage), func(c *fiber.Ctx) error {
+		var req struct {
+			Kind    string `json:"kind"`
+			Pattern string `json:"pattern"`
+			Comment string `json:"comment"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		rule, err := targets.AddRule(database, req.Kind, req.Pattern, req.Comment, auth.Caller(c).UserID)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionTargetRuleAdd, ResourceType: "target_rule", ResourceID: strconv.FormatInt(rule.ID, 10),
+			Target: rule.Pattern, After: rule,
+		})
+		return c.Status(fiber.StatusCreated).JSON(rule)
+	})
+
+	admin.Delete("/target-rules/:id", auth.RequirePermission(auth.PermTargetsManage), func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		rule, err := targets.DeleteRule(database, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(404).JSON(fiber.Map{"error": "rule not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionTargetRuleDel, ResourceType: "target_rule", ResourceID: strconv.FormatInt(id, 10),
+			Target: rule.Pattern, Before: rule,
+		})
+		return c.JSON(fiber.Map{"message": "deleted"})
+	})
+}
+
+// setupVerificationRoutes registers the ownership challenges of the active
+// workspace. Proving ownership is part of scheduling, so it takes the same
+// permission.
+func setupVerificationRoutes(app *fiber.App, database *sqlx.DB, inWorkspace fiber.Handler) {
+	verifier := targets.NewVerifier(database)
+	respond := func(c *fiber.Ctx, v targets.Verification) error {
+		return c.JSON(fiber.Map{"verification": v, "instructions": v.Instructions()})
+	}
+
+	app.Get("/verifications", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesRead), func(c *fiber.Ctx) error {
+		list, err := verifier.List(auth.Caller(c).WorkspaceID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(list)
+	})
+
+	app.Post("/verifications", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesWrite), func(c *fiber.Ctx) error {
+		var req struct {
+			Target string `json:"target"`
+			Method string `json:"method"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		caller := auth.Caller(c)
+		v, err := verifier.Start(caller.WorkspaceID, caller.UserID, req.Target, req.Method)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionVerifyStart, ResourceType: "verification", ResourceID: fmt.Sprint(v.ID),
+			Target: v.Target, Detail: v.Method,
+		})
+		c.Status(fiber.StatusCreated)
+		return respond(c, v)
+	})
+
+	app.Get("/verifications/:id", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesRead), func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		v, err := verifier.Get(auth.Caller(c).WorkspaceID, id)
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "verification not found"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return respond(c, v)
+	})
+
+	app.Post("/verifications/:id/check", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesWrite), func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		v, err := verifier.Check(c.Context(), auth.Caller(c).WorkspaceID, id)
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "verification not found"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		e := audit.Event{
+			Action: audit.ActionVerified, ResourceType: "verification", ResourceID: fmt.Sprint(v.ID),
+			Target: v.Target, Detail: v.Method,
+		}
+		if v.Status != targets.StatusVerified {
+			e.Action = audit.ActionVerifyFailed
+			if v.LastError != nil {
+				e.Detail = *v.LastError
+			}
+		}
+		record(c, database, e)
+		return respond(c, v)
+	})
+
+	app.Delete("/verifications/:id", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermSchedulesWrite), func(c *fiber.Ctx) error {
+		id, err := paramID(c, "id")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		if err := verifier.Delete(auth.Caller(c).WorkspaceID, id); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(404).JSON(fiber.Map{"error": "verification not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionVerifyDelete, ResourceType: "verification", ResourceID: fmt.Sprint(id),
+		})
+		return c.JSON(fiber.Map{"message": "deleted"})
+	})
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
+	"github.com/KuberTheGreat/Sentrinet/internal/webhooks"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+// webhookOf loads the webhook named by :id. User webhooks belong to their
+// owner and workspace webhooks to the active workspace; anything else is
+// reported as not found. manage additionally requires the right to change it:
+// workspace webhooks are changed by workspace admins only.
+func webhookOf(c *fiber.Ctx, database *sqlx.DB, manage bool) (webhooks.Webhook, error) {
+	id, err := paramID(c, "id")
+	if err != nil {
+		return webhooks.Webhook{}, err
+	}
+	w, err := webhooks.Get(database, id)
+	if err == sql.ErrNoRows {
+		return w, fiber.NewError(fiber.StatusNotFound, "webhook not found")
+	}
+	if err != nil {
+		return w, err
+	}
+	caller := auth.Caller(c)
+	if w.Scope == webhooks.ScopeUser && !caller.CanAccess(w.UserID) ||
+		w.Scope == webhooks.ScopeWorkspace && (w.WorkspaceID == nil || !caller.InWorkspace(*w.WorkspaceID)) {
+		return w, fiber.NewError(fiber.StatusNotFound, "webhook not found")
+	}
+	if manage && w.Scope == webhooks.ScopeWorkspace && !workspaceAdmin(caller) {
+		return w, fiber.NewError(fiber.StatusForbidden, "only workspace admins manage workspace webhooks")
+	}
+	return w, nil
+}
+
+func workspaceAdmin(caller auth.Identity) bool {
+	return caller.WorkspaceRole == auth.RoleAdmin || caller.IsAdmin()
+}
+
+// setupWebhookRoutes registers the webhook subscriptions of the caller and the
+// active workspace, and the delivery log of each.
+func setupWebhookRoutes(app *fiber.App, database *sqlx.DB, inWorkspace fiber.Handler) {
+	app.Get("/webhooks", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), func(c *fiber.Ctx) error {
+		caller := auth.Caller(c)
+		hooks, err := webhooks.List(database, caller.UserID, caller.WorkspaceID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(hooks)
+	})
+
+	// The signing secret is only part of this response.
+	app.Post("/webhooks", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), func(c *fiber.Ctx) error {
+		var req struct {
+			Scope      string   `json:"scope"`
+			URL        string   `json:"url"`
+			EventTypes []string `json:"event_types"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		caller := auth.Caller(c)
+		w := webhooks.Webhook{Scope: req.Scope, UserID: caller.UserID, URL: req.URL, EventTypes: req.EventTypes, Enabled: true}
+		if w.Scope == "" {
+			w.Scope = webhooks.ScopeUser
+		}
+		if w.Scope == webhooks.ScopeWorkspace {
+			if !workspaceAdmin(caller) {
+				return c.Status(403).JSON(fiber.Map{"error": "only workspace admins manage workspace webhooks"})
+			}
+			w.WorkspaceID = &caller.WorkspaceID
+		}
+		w, secret, err := webhooks.Create(database, w)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionWebhookCreate, ResourceType: "webhook", ResourceID: strconv.FormatInt(w.ID, 10),
+			After: w,
+		})
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"webhook": w, "secret": secret})
+	})
+
+	app.Delete("/webhooks/:id", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), func(c *fiber.Ctx) error {
+		w, err := webhookOf(c, database, true)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		if err := webhooks.Delete(database, w.ID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionWebhookDelete, ResourceType: "webhook", ResourceID: strconv.FormatInt(w.ID, 10),
+			Before: w,
+		})
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	// Test queues a ping delivery, which ignores the event filters.
+	app.Post("/webhooks/:id/test", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), func(c *fiber.Ctx) error {
+		w, err := webhookOf(c, database, true)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		if err := webhooks.Ping(database, w); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"status": "queued"})
+	})
+
+	// Deliveries are listed newest first, filtered by ?status=pending,
+	// delivered or dead and capped by ?limit (default 50, at most 500).
+	app.Get("/webhooks/:id/deliveries", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), func(c *fiber.Ctx) error {
+		w, err := webhookOf(c, database, false)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		limit := c.QueryInt("limit", 50)
+		if limit <= 0 || limit > 500 {
+			limit = 50
+		}
+		list, err := webhooks.Deliveries(database, w.ID, c.Query("status"), limit)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(list)
+	})
+
+	app.Get("/webhooks/:id/deliveries/:deliveryId", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), func(c *fiber.Ctx) error {
+		w, err := webhookOf(c, database, false)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		deliveryID, err := paramID(c, "deliveryId")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		del, attempts, err := webhooks.GetDelivery(database, w.ID, deliveryID)
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "delivery not found"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"delivery": del, "attempts": attempts})
+	})
+
+	// Retry moves a dead or delivered delivery back into the outbox.
+	app.Post("/webhooks/:id/deliveries/:deliveryId/retry", auth.JWTMiddleware, inWorkspace, auth.RequirePermission(auth.PermNotificationsRead), func(c *fiber.Ctx) error {
+		w, err := webhookOf(c, database, true)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		deliveryID, err := paramID(c, "deliveryId")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		if err := webhooks.Redeliver(database, w.ID, deliveryID); err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "delivery not found or already pending"})
+		} else if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			Action: audit.ActionWebhookRedeliver, ResourceType: "webhook_delivery",
+			ResourceID: strconv.FormatInt(deliveryID, 10),
+		})
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"status": "queued"})
+	})
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+// workspaceRole loads the caller's role in the workspace named by :id.
+// Workspaces the caller is not a member of are reported as not found; global
+// admins act as workspace admins everywhere.
+func workspaceRole(c *fiber.Ctx, database *sqlx.DB) (int64, string, error) {
+	id, err := paramID(c, "id")
+	if err != nil {
+		return 0, "", err
+	}
+	caller := auth.Caller(c)
+	role, err := auth.MemberRole(database, id, caller.UserID)
+	if err == sql.ErrNoRows && caller.IsAdmin() {
+		var exists int
+		if err := database.Get(&exists, `SELECT COUNT(*) FROM workspaces WHERE id = ?`, id); err != nil {
+			return 0, "", err
+		}
+		if exists > 0 {
+			return id, auth.RoleAdmin, nil
+		}
+	}
+	if err == sql.ErrNoRows {
+		return 0, "", fiber.NewError(fiber.StatusNotFound, "workspace not found")
+	}
+	return id, role, err
+}
+
+func setupWorkspaceRoutes(app *fiber.App, database *sqlx.DB) {
+	app.Get("/workspaces", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		ws, err := auth.ListWorkspaces(database, auth.Caller(c).UserID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(ws)
+	})
+
+	app.Post("/workspaces", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "name is required"})
+		}
+		id, err := auth.CreateWorkspace(database, req.Name, auth.Caller(c).UserID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		record(c, database, audit.Event{
+			WorkspaceID: id, Action: audit.ActionWorkspaceCreate, ResourceType: "workspace",
+			ResourceID: fmt.Sprint(id), After: fiber.Map{"name": req.Name},
+		})
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"id": id})
+	})
+
+	app.Get("/workspaces/:id/members", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		id, _, err := workspaceRole(c, database)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		members, err := auth.ListMembers(database, id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(members)
+	})
+
+	// Adding a member and changing a member's role share a handler; only
+	// workspace admins may do either.
+	setMember := func(c *fiber.Ctx, userID int64, role string) error {
+		ok, err := auth.RoleExists(database, role)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown role " + role})
+		}
+		id, _, err := workspaceRole(c, database)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		before, err := auth.MemberRole(database, id, userID)
+		if err != nil && err != sql.ErrNoRows {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := auth.SetMemberRole(database, id, userID, role); err != nil {
+			return auth.JSONError(c, err)
+		}
+		e := audit.Event{
+			WorkspaceID: id, Action: audit.ActionMemberSet, ResourceType: "user",
+			ResourceID: fmt.Sprint(userID), After: fiber.Map{"role": role},
+		}
+		if before != "" {
+			e.Before = fiber.Map{"role": before}
+		}
+		record(c, database, e)
+		return c.JSON(fiber.Map{"workspace_id": id, "user_id": userID, "role": role})
+	}
+
+	app.Post("/workspaces/:id/members", auth.JWTMiddleware, requireWorkspaceAdmin(database), func(c *fiber.Ctx) error {
+		var req struct {
+			Username string `json:"username"`
+			Role     string `json:"role"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if req.Role == "" {
+			req.Role = auth.RoleViewer
+		}
+		var userID int64
+		if err := database.Get(&userID, `SELECT id FROM users WHERE username = ?`, req.Username); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(404).JSON(fiber.Map{"error": "user not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return setMember(c, userID, req.Role)
+	})
+
+	app.Put("/workspaces/:id/members/:userId", auth.JWTMiddleware, requireWorkspaceAdmin(database), func(c *fiber.Ctx) error {
+		userID, err := paramID(c, "userId")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return setMember(c, userID, req.Role)
+	})
+
+	app.Delete("/workspaces/:id/members/:userId", auth.JWTMiddleware, func(c *fiber.Ctx) error {
+		id, role, err := workspaceRole(c, database)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		userID, err := paramID(c, "userId")
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		// Members may leave a workspace on their own.
+		if role != auth.RoleAdmin && userID != auth.Caller(c).UserID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "workspace admin only"})
+		}
+		before, _ := auth.MemberRole(database, id, userID)
+		if err := auth.RemoveMember(database, id, userID); err != nil {
+			return auth.JSONError(c, err)
+		}
+		record(c, database, audit.Event{
+			WorkspaceID: id, Action: audit.ActionMemberRemove, ResourceType: "user",
+			ResourceID: fmt.Sprint(userID), Before: fiber.Map{"role": before},
+		})
+		return c.JSON(fiber.Map{"message": "removed"})
+	})
+}
+
+func requireWorkspaceAdmin(database *sqlx.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		_, role, err := workspaceRole(c, database)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		if role != auth.RoleAdmin {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "workspace admin only"})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	ActionAccountLocked = "auth.account_locked"
+	ActionIPLocked      = "auth.ip_locked"
+	ActionRegister      = "auth.register"
+	ActionLogin         = "auth.login"
+	ActionLoginFailed   = "auth.login_failed"
+	ActionLogout        = "auth.logout"
+	ActionTokenReuse    = "auth.refresh_reuse"
+	ActionTOTPEnabled   = "auth.2fa_enabled"
+	ActionTOTPDisabled  = "auth.2fa_disabled"
+	ActionAPIKeyCreate  = "auth.api_key_created"
+	ActionAPIKeyRevoke  = "auth.api_key_revoked"
+
+	ActionScanRun          = "scan.run"
+	ActionScanDelete       = "scan.delete"
+	ActionScanDeleteTarget = "scan.delete_target"
+	ActionTargetDenied     = "scan.target_denied"
+
+	ActionScheduleCreate = "schedule.create"
+	ActionScheduleUpdate = "schedule.update"
+	ActionScheduleDelete = "schedule.delete"
+	ActionScheduleStart  = "schedule.start"
+	ActionScheduleStop   = "schedule.stop"
+	ActionScheduleRunNow = "schedule.run_now"
+
+	ActionVerifyStart  = "target.verify_start"
+	ActionVerified     = "target.verified"
+	ActionVerifyFailed = "target.verify_failed"
+	ActionVerifyDelete = "target.verify_delete"
+
+	ActionAlertRuleCreate = "alert.rule_create"
+	ActionAlertRuleUpdate = "alert.rule_update"
+	ActionAlertRuleDelete = "alert.rule_delete"
+
+	ActionWebhookCreate    = "webhook.create"
+	ActionWebhookDelete    = "webhook.delete"
+	ActionWebhookRedeliver = "webhook.redeliver"
+
+	ActionChannelCreate = "channel.create"
+	ActionChannelUpdate = "channel.update"
+	ActionChannelDelete = "channel.delete"
+
+	ActionWorkspaceCreate = "workspace.create"
+	ActionMemberSet       = "workspace.member_set"
+	ActionMemberRemove    = "workspace.member_remove"
+
+	ActionUserRole       = "admin.user_role"
+	ActionRevokeSessions = "admin.revoke_sessions"
+	ActionReset2FA       = "admin.reset_2fa"
+	ActionCleanupRun     = "admin.cleanup_run"
+	ActionRetention      = "admin.retention"
+	ActionTargetRuleAdd  = "admin.target_rule_add"
+	ActionTargetRuleDel  = "admin.target_rule_delete"
+	ActionQuota          = "admin.quota"
+)
+
+// Event is one security relevant action. ActorID is 0 when the action was not
+// taken by an authenticated user, e.g. a lockout caused by failed logins.
+// Before and After are stored as JSON and describe the resource around a
+// change; either may be nil.
+type Event struct {
+	ActorID      int64
+	WorkspaceID  int64
+	Action       string
+	ResourceType string
+	ResourceID   string
+	Target       string
+	IP           string
+	Detail       string
+	Before       interface{}
+	After        interface{}
+}
+
+// Row is a stored event as returned by the audit API.
+type Row struct {
+	ID           int64     `db:"id" json:"id"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	ActorID      *int64    `db:"actor_id" json:"actor_id"`
+	Action       string    `db:"action" json:"action"`
+	Target       *string   `db:"target" json:"target,omitempty"`
+	IP           *string   `db:"ip" json:"ip,omitempty"`
+	Detail       *string   `db:"detail" json:"detail,omitempty"`
+	ResourceType *string   `db:"resource_type" json:"resource_type,omitempty"`
+	ResourceID   *string   `db:"resource_id" json:"resource_id,omitempty"`
+	Before       *string   `db:"before_value" json:"before,omitempty"`
+	After        *string   `db:"after_value" json:"after,omitempty"`
+	WorkspaceID  *int64    `db:"workspace_id" json:"workspace_id,omitempty"`
+}
+
+func nullable(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int64:
+		if x == 0 {
+			return nil
+		}
+	case string:
+		if x == "" {
+			return nil
+		}
+	}
+	return v
+}
+
+func encode(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+// Record stores e. Failing to audit must not fail the action being audited,
+// so errors are only logged.
+func Record(db *sqlx.DB, e Event) {
+	_, err := db.Exec(`INSERT INTO audit_events (actor_id, workspace_id, action, resource_type, resource_id,
+		target, ip, detail, before_value, after_value) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullable(e.ActorID), nullable(e.WorkspaceID), e.Action, nullable(e.ResourceType), nullable(e.ResourceID),
+		nullable(e.Target), nullable(e.IP), nullable(e.Detail), encode(e.Before), encode(e.After))
+	if err != nil {
+		fmt.Printf("[Audit] failed to record %s: %v\n", e.Action, err)
+	}
+}
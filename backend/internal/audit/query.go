@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Filter selects events for the audit API. Zero values match everything;
+// an Action ending in '*' matches by prefix, e.g. "schedule.*".
+type Filter struct {
+	ActorID      int64
+	WorkspaceID  int64
+	Action       string
+	ResourceType string
+	ResourceID   string
+	IP           string
+	Since        time.Time
+	Until        time.Time
+	Limit        int
+	Offset       int
+}
+
+func (f Filter) where() (string, []interface{}) {
+	conds := []string{"1 = 1"}
+	args := []interface{}{}
+	if f.ActorID != 0 {
+		conds = append(conds, "actor_id = ?")
+		args = append(args, f.ActorID)
+	}
+	if f.WorkspaceID != 0 {
+		conds = append(conds, "workspace_id = ?")
+		args = append(args, f.WorkspaceID)
+	}
+	if f.Action != "" {
+		if prefix, ok := strings.CutSuffix(f.Action, "*"); ok {
+			conds = append(conds, "substr(action, 1, ?) = ?")
+			args = append(args, len(prefix), prefix)
+		} else {
+			conds = append(conds, "action = ?")
+			args = append(args, f.Action)
+		}
+	}
+	if f.ResourceType != "" {
+		conds = append(conds, "resource_type = ?")
+		args = append(args, f.ResourceType)
+	}
+	if f.ResourceID != "" {
+		conds = append(conds, "resource_id = ?")
+		args = append(args, f.ResourceID)
+	}
+	if f.IP != "" {
+		conds = append(conds, "ip = ?")
+		args = append(args, f.IP)
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, f.Since.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if !f.Until.IsZero() {
+		conds = append(conds, "created_at < ?")
+		args = append(args, f.Until.UTC().Format("2006-01-02 15:04:05"))
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// List returns the events matching f, newest first, and the total number of
+// matches ignoring Limit and Offset. A Limit of 0 returns every match.
+func List(db *sqlx.DB, f Filter) ([]Row, int, error) {
+	where, args := f.where()
+
+	var total int
+	if err := db.Get(&total, "SELECT COUNT(*) FROM audit_events WHERE "+where, args...); err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT * FROM audit_events WHERE " + where + " ORDER BY id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, f.Limit, f.Offset)
+	}
+	rows := []Row{}
+	if err := db.Select(&rows, query, args...); err != nil {
+		return nil, 0, err
+	}
+	return rows, total, nil
+}
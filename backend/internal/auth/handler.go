@@ -1,23 +1,38 @@
 package auth
 
 import (
-	"log"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/jmoiron/sqlx"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtSecret = []byte("secret-key")
-
 type AuthHandler struct{
 	DB *sqlx.DB
+	Policy PasswordPolicy
+	Throttle *Throttle
 }
 
 func NewAuthHandler(db *sqlx.DB) *AuthHandler{
-	return &AuthHandler{DB: db}
+	return &AuthHandler{DB: db, Policy: PasswordPolicyFromEnv(), Throttle: NewThrottle(db)}
+}
+
+// recordAuth writes an audit event for an authentication action of userID.
+func (h *AuthHandler) recordAuth(c *fiber.Ctx, action string, userID int64, target string){
+	audit.Record(h.DB, audit.Event{
+		ActorID: userID, Action: action, ResourceType: "user", ResourceID: fmt.Sprint(userID),
+		Target: target, IP: c.IP(),
+	})
+}
+
+// tooManyAttempts answers a login attempt against a locked account or IP.
+func tooManyAttempts(c *fiber.Ctx, wait time.Duration) error{
+	c.Set(fiber.HeaderRetryAfter, fmt.Sprint(int(wait.Seconds())))
+	return fiber.NewError(fiber.StatusTooManyRequests, "Too many failed attempts, try again later")
 }
 
 func (h *AuthHandler) Register(c *fiber.Ctx) error{
@@ -29,20 +44,35 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error{
 	if err := c.BodyParser(&data); err!=nil{
 		return fiber.ErrBadRequest
 	}
+	data.Username = strings.TrimSpace(data.Username)
+	if data.Username == ""{
+		return fiber.NewError(fiber.StatusBadRequest, "Username is required")
+	}
+	if err := h.Policy.Check(data.Username, data.Password); err != nil{
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(data.Password), bcrypt.DefaultCost)
 	if err != nil{
 		return fiber.ErrInternalServerError
 	}
 
-	_, err = h.DB.Exec(`INSERT INTO users (username, password_hash) 
-						VALUES (?, ?)`, 
-						data.Username, string(hash))
+	res, err := h.DB.Exec(`INSERT INTO users (username, password_hash, role) 
+						VALUES (?, ?, ?)`, 
+						data.Username, string(hash), DefaultRole())
 	if err != nil{
-		log.Fatal(err)
-		return fiber.NewError(fiber.StatusConflict, "Username already taken")
+		if strings.Contains(err.Error(), "UNIQUE constraint failed"){
+			return fiber.NewError(fiber.StatusConflict, "Username already taken")
+		}
+		return fiber.ErrInternalServerError
 	}
 
+	userID, _ := res.LastInsertId()
+	if _, err := CreatePersonalWorkspace(h.DB, userID, data.Username); err != nil{
+		return fiber.ErrInternalServerError
+	}
+	h.recordAuth(c, audit.ActionRegister, userID, data.Username)
+
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "User registered successfully!"})
 }
 
@@ -55,24 +85,69 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error{
 		return fiber.ErrBadRequest
 	}
 
+	wait, err := h.Throttle.Locked(data.Username, c.IP())
+	if err != nil{
+		return fiber.ErrInternalServerError
+	}
+	if wait > 0{
+		return tooManyAttempts(c, wait)
+	}
+
 	var user User
-	err := h.DB.Get(&user, `SELECT * FROM users WHERE username = ?`, data.Username)
+	err = h.DB.Get(&user, `SELECT * FROM users WHERE username = ?`, data.Username)
 	if err != nil{
+		h.Throttle.Fail(data.Username, c.IP())
+		audit.Record(h.DB, audit.Event{Action: audit.ActionLoginFailed, Target: data.Username, IP: c.IP()})
 		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(data.Password)); err != nil{
+		h.Throttle.Fail(data.Username, c.IP())
+		h.recordAuth(c, audit.ActionLoginFailed, user.ID, user.Username)
 		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"exp": time.Now().Add(24 * time.Hour).Unix(),
-	})
-	tokenString, err := token.SignedString(jwtSecret)
+	// With 2FA the password alone is not a successful login; the failure
+	// count is reset by LoginMFA once the code checks out.
+	if user.TOTPEnabled{
+		return mfaChallenge(c, user)
+	}
+	h.Throttle.Succeed(data.Username)
+
+	tokens, err := StartSession(h.DB, user)
 	if err != nil{
 		return fiber.ErrInternalServerError
 	}
+	h.recordAuth(c, audit.ActionLogin, user.ID, user.Username)
+
+	return c.JSON(tokens)
+}
 
-	return c.JSON(fiber.Map{"token": tokenString})
+func (h *AuthHandler) Refresh(c *fiber.Ctx) error{
+	var data struct{
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.BodyParser(&data); err != nil || data.RefreshToken == ""{
+		return fiber.ErrBadRequest
+	}
+
+	tokens, err := RefreshSession(h.DB, data.RefreshToken, c.IP())
+	if err != nil{
+		return err
+	}
+	return c.JSON(tokens)
+}
+
+// Logout must run after JWTMiddleware. It revokes the session of the access
+// token, which also invalidates its refresh token.
+func (h *AuthHandler) Logout(c *fiber.Ctx) error{
+	sid := SessionID(c)
+	if sid == 0{
+		return fiber.NewError(fiber.StatusBadRequest, "not a session token")
+	}
+	if err := RevokeSession(h.DB, sid); err != nil{
+		return fiber.ErrInternalServerError
+	}
+	h.recordAuth(c, audit.ActionLogout, Caller(c).UserID, "")
+	return c.JSON(fiber.Map{"message": "logged out"})
 }
\ No newline at end of file
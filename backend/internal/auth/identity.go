@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+// Identity is the authenticated caller of a request, as set by JWTMiddleware.
+type Identity struct {
+	UserID      int64
+	Role        string
+	Permissions []string
+	// WorkspaceID and WorkspaceRole are set by WorkspaceMiddleware.
+	WorkspaceID   int64
+	WorkspaceRole string
+}
+
+func Caller(c *fiber.Ctx) Identity {
+	id, _ := c.Locals("user_id").(int64)
+	role, _ := c.Locals("role").(string)
+	perms, _ := c.Locals("permissions").([]string)
+	wsID, _ := c.Locals("workspace_id").(int64)
+	wsRole, _ := c.Locals("workspace_role").(string)
+	return Identity{UserID: id, Role: role, Permissions: perms, WorkspaceID: wsID, WorkspaceRole: wsRole}
+}
+
+// Can reports whether the caller's token grants perm.
+func (i Identity) Can(perm string) bool {
+	for _, p := range i.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether the caller acts as an administrator. An admin's API
+// key only does so when it was scoped with admin:access.
+func (i Identity) IsAdmin() bool {
+	return i.Role == RoleAdmin && i.Can(PermAdminAccess)
+}
+
+// CanAccess reports whether the caller may read or change a resource owned by
+// ownerID. Admins may act on any resource.
+func (i Identity) CanAccess(ownerID int64) bool {
+	return i.UserID == ownerID || i.IsAdmin()
+}
+
+// InWorkspace reports whether a resource of workspaceID belongs to the active
+// workspace. Admins may act on any resource.
+func (i Identity) InWorkspace(workspaceID int64) bool {
+	return i.WorkspaceID == workspaceID || i.IsAdmin()
+}
+
+// WorkspaceFilter returns the workspace id that list and bulk queries must be
+// scoped to. Admins get every workspace's rows only when they ask for it with
+// ?all=true; in that case WorkspaceFilter returns 0.
+func WorkspaceFilter(c *fiber.Ctx) int64 {
+	caller := Caller(c)
+	if caller.IsAdmin() && c.QueryBool("all") {
+		return 0
+	}
+	return caller.WorkspaceID
+}
+
+// PromoteAdmins gives the admin role to the comma separated usernames, so the
+// first administrators can be configured through the environment.
+func PromoteAdmins(db *sqlx.DB, usernames string) error {
+	for _, name := range strings.Split(usernames, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, err := db.Exec(`UPDATE users SET role = ? WHERE username = ?`, RoleAdmin, name); err != nil {
+			return fmt.Errorf("promote %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey is one entry of the key set. private is nil for keys that are
+// only kept to verify tokens issued before a rotation.
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+// KeySet holds the key new tokens are signed with and every key tokens are
+// still accepted from, indexed by kid.
+type KeySet struct {
+	active *signingKey
+	byKID  map[string]*signingKey
+}
+
+// keys is the process wide key set, replaced by LoadKeys.
+var keys = mustEphemeralKeys()
+
+// LoadKeys configures token signing from the environment.
+//
+// SENTRINET_JWT_KEYS is a comma separated list of kid:alg:path entries, where
+// alg is HS256, RS256 or EdDSA and path is a PEM key (or, for HS256, a file
+// holding the secret). The first entry signs new tokens; the others are only
+// used for verification, so a rotated-out key keeps its sessions valid until
+// they expire. A verify-only RS256 or EdDSA entry may point at a public key.
+//
+// SENTRINET_JWT_SECRET is a shorthand for a single HS256 key with kid
+// "default". Without either, an Ed25519 key is generated at startup and every
+// token is invalidated by a restart.
+func LoadKeys() error {
+	ks, err := keySetFromEnv()
+	if err != nil {
+		return err
+	}
+	if ks == nil {
+		fmt.Println("[Auth] no signing keys configured, using an ephemeral key; sessions will not survive a restart")
+		return nil
+	}
+	keys = ks
+	return nil
+}
+
+func keySetFromEnv() (*KeySet, error) {
+	if spec := os.Getenv("SENTRINET_JWT_KEYS"); spec != "" {
+		ks := &KeySet{byKID: map[string]*signingKey{}}
+		for i, entry := range strings.Split(spec, ",") {
+			parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("SENTRINET_JWT_KEYS entry %q: want kid:alg:path", entry)
+			}
+			k, err := loadKey(parts[0], parts[1], parts[2])
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %w", parts[0], err)
+			}
+			if _, dup := ks.byKID[k.kid]; dup {
+				return nil, fmt.Errorf("duplicate kid %s", k.kid)
+			}
+			if i == 0 {
+				if k.private == nil {
+					return nil, fmt.Errorf("key %s: the signing key needs a private key", k.kid)
+				}
+				ks.active = k
+			}
+			ks.byKID[k.kid] = k
+		}
+		return ks, nil
+	}
+
+	if secret := os.Getenv("SENTRINET_JWT_SECRET"); secret != "" {
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("SENTRINET_JWT_SECRET must be at least 32 bytes")
+		}
+		k := &signingKey{kid: "default", method: jwt.SigningMethodHS256, private: []byte(secret), public: []byte(secret)}
+		return &KeySet{active: k, byKID: map[string]*signingKey{k.kid: k}}, nil
+	}
+	return nil, nil
+}
+
+func loadKey(kid, alg, path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	k := &signingKey{kid: kid}
+
+	switch alg {
+	case "HS256":
+		secret := []byte(strings.TrimSpace(string(data)))
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("HS256 secret must be at least 32 bytes")
+		}
+		k.method, k.private, k.public = jwt.SigningMethodHS256, secret, secret
+	case "RS256":
+		k.method = jwt.SigningMethodRS256
+		if priv, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+			k.private, k.public = priv, &priv.PublicKey
+		} else if pub, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+			k.public = pub
+		} else {
+			return nil, fmt.Errorf("not an RSA PEM key")
+		}
+	case "EdDSA":
+		k.method = jwt.SigningMethodEdDSA
+		if priv, err := jwt.ParseEdPrivateKeyFromPEM(data); err == nil {
+			k.private, k.public = priv, priv.(ed25519.PrivateKey).Public()
+		} else if pub, err := jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+			k.public = pub
+		} else {
+			return nil, fmt.Errorf("not an Ed25519 PEM key")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	return k, nil
+}
+
+func mustEphemeralKeys() *KeySet {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	k := &signingKey{kid: "ephemeral", method: jwt.SigningMethodEdDSA, private: priv, public: pub}
+	return &KeySet{active: k, byKID: map[string]*signingKey{k.kid: k}}
+}
+
+// signToken signs claims with the active key and names it in the kid header.
+func signToken(claims jwt.MapClaims) (string, error) {
+	k := keys.active
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.kid
+	return token.SignedString(k.private)
+}
+
+// parseToken verifies a token against the key named by its kid. The token's
+// alg must be exactly the algorithm of that key, so an RS256 public key can
+// never be used as an HS256 secret.
+func parseToken(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		k, ok := keys.byKID[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		if t.Method.Alg() != k.method.Alg() {
+			return nil, fmt.Errorf("unexpected alg %s for kid %s", t.Method.Alg(), kid)
+		}
+		return k.public, nil
+	}, jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}), jwt.WithExpirationRequired())
+}
+
+// JWKS returns the public keys of the key set as a JSON Web Key Set. HS256
+// keys are shared secrets and are never published.
+func JWKS() map[string]interface{} {
+	list := []map[string]string{}
+	for _, k := range keys.byKID {
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			list = append(list, map[string]string{
+				"kty": "RSA", "use": "sig", "alg": "RS256", "kid": k.kid,
+				"n": base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			list = append(list, map[string]string{
+				"kty": "OKP", "crv": "Ed25519", "use": "sig", "alg": "EdDSA", "kid": k.kid,
+				"x": base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i]["kid"] < list[j]["kid"] })
+	return map[string]interface{}{"keys": list}
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mfaTokenTTL bounds the time between the password step of a login and the
+// second factor.
+const mfaTokenTTL = 5 * time.Minute
+
+// mfaChallenge is returned by Login instead of tokens when the user has 2FA
+// enabled. The mfa_token is exchanged at /login/2fa together with a code.
+func mfaChallenge(c *fiber.Ctx, user User) error {
+	token, err := signToken(jwt.MapClaims{
+		"purpose": "mfa",
+		"user_id": user.ID,
+		"exp":     time.Now().Add(mfaTokenTTL).Unix(),
+	})
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(fiber.Map{"mfa_required": true, "mfa_token": token})
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery
+// code.
+func (h *AuthHandler) checkSecondFactor(userID int64, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		return UseRecoveryCode(h.DB, userID, recoveryCode)
+	}
+	return VerifyTOTP(h.DB, userID, code)
+}
+
+func (h *AuthHandler) LoginMFA(c *fiber.Ctx) error {
+	var data struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	token, err := parseToken(data.MFAToken)
+	if err != nil || !token.Valid {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid mfa token")
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if purpose, _ := claims["purpose"].(string); purpose != "mfa" {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid mfa token")
+	}
+	userID := int64(claims["user_id"].(float64))
+
+	var user User
+	if err := h.DB.Get(&user, `SELECT * FROM users WHERE id = ?`, userID); err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	// Codes are guessed far more easily than passwords, so they share the
+	// account's failed-login budget.
+	wait, err := h.Throttle.Locked(user.Username, c.IP())
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if wait > 0 {
+		return tooManyAttempts(c, wait)
+	}
+	ok, err := h.checkSecondFactor(userID, data.Code, data.RecoveryCode)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if !ok {
+		h.Throttle.Fail(user.Username, c.IP())
+		h.recordAuth(c, audit.ActionLoginFailed, user.ID, user.Username)
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid code")
+	}
+	h.Throttle.Succeed(user.Username)
+	tokens, err := StartSession(h.DB, user)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	h.recordAuth(c, audit.ActionLogin, user.ID, user.Username)
+	return c.JSON(tokens)
+}
+
+// EnrollTOTP starts enrollment. 2FA is only enforced once the user proves the
+// authenticator works through ConfirmTOTP.
+func (h *AuthHandler) EnrollTOTP(c *fiber.Ctx) error {
+	caller := Caller(c)
+	var username string
+	if err := h.DB.Get(&username, `SELECT username FROM users WHERE id = ?`, caller.UserID); err != nil {
+		return fiber.ErrInternalServerError
+	}
+	secret, err := EnrollTOTP(h.DB, caller.UserID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	}
+	return c.JSON(fiber.Map{"secret": secret, "uri": ProvisioningURI(username, secret)})
+}
+
+func (h *AuthHandler) ConfirmTOTP(c *fiber.Ctx) error {
+	var data struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		return fiber.ErrBadRequest
+	}
+	caller := Caller(c)
+	ok, err := VerifyTOTP(h.DB, caller.UserID, data.Code)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid code")
+	}
+	codes, err := EnableTOTP(h.DB, caller.UserID)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	h.recordAuth(c, audit.ActionTOTPEnabled, caller.UserID, "")
+	return c.JSON(fiber.Map{"enabled": true, "recovery_codes": codes})
+}
+
+func (h *AuthHandler) DisableTOTP(c *fiber.Ctx) error {
+	var data struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		return fiber.ErrBadRequest
+	}
+	caller := Caller(c)
+	ok, err := h.checkSecondFactor(caller.UserID, data.Code, data.RecoveryCode)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid code")
+	}
+	if err := DisableTOTP(h.DB, caller.UserID); err != nil {
+		return fiber.ErrInternalServerError
+	}
+	h.recordAuth(c, audit.ActionTOTPDisabled, caller.UserID, "")
+	return c.JSON(fiber.Map{"enabled": false})
+}
+
+func (h *AuthHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	var data struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		return fiber.ErrBadRequest
+	}
+	caller := Caller(c)
+	ok, err := VerifyTOTP(h.DB, caller.UserID, data.Code)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid code")
+	}
+	codes, err := NewRecoveryCodes(h.DB, caller.UserID)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(fiber.Map{"recovery_codes": codes})
+}
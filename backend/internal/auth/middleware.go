@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -8,23 +9,61 @@ import (
 )
 
 func JWTMiddleware(c *fiber.Ctx) error{
+	// Already authenticated by APIKeyMiddleware.
+	if ViaAPIKey(c){
+		return c.Next()
+	}
+
 	authHeader := c.Get("Authorization")
 	if authHeader == ""{
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "missing token"})
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error){
-		return jwtSecret, nil
-	})
+	token, err := parseToken(tokenString)
 
 	if err != nil || !token.Valid{
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid token"})
 	}
 
 	claims := token.Claims.(jwt.MapClaims)
+	sid, ok := claims["sid"].(float64)
+	if !ok{
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid token"})
+	}
+	if sessionDB != nil{
+		if err := checkSession(sessionDB, int64(sid)); err != nil{
+			return JSONError(c, err)
+		}
+	}
+	c.Locals("session_id", int64(sid))
+
 	userID := int64(claims["user_id"].(float64))
 	c.Locals("user_id", userID)
 
+	role, _ := claims["role"].(string)
+	c.Locals("role", role)
+
+	perms := []string{}
+	if list, ok := claims["perms"].([]interface{}); ok{
+		for _, p := range list{
+			if s, ok := p.(string); ok{
+				perms = append(perms, s)
+			}
+		}
+	}
+	c.Locals("permissions", perms)
+
 	return c.Next()
+}
+
+// JSONError writes err as a JSON error body, using the status code of a
+// *fiber.Error and 500 for anything else.
+func JSONError(c *fiber.Ctx, err error) error{
+	code := fiber.StatusInternalServerError
+	var fe *fiber.Error
+	if errors.As(err, &fe){
+		code = fe.Code
+	}
+	return c.Status(code).JSON(fiber.Map{"error": err.Error()})
 }
\ No newline at end of file
@@ -7,4 +7,9 @@ type User struct {
 	Username string `db:"username" json:"username"`
 	PasswordHash string `db:"password_hash" json:"-"`
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	Role string `db:"role" json:"role"`
+	DefaultWorkspaceID *int64 `db:"default_workspace_id" json:"default_workspace_id"`
+	TOTPSecret *string `db:"totp_secret" json:"-"`
+	TOTPEnabled bool `db:"totp_enabled" json:"totp_enabled"`
+	TOTPLastStep int64 `db:"totp_last_step" json:"-"`
 }
\ No newline at end of file
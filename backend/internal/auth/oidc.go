@@ -0,0 +1,488 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
+)
+
+// oidcStateTTL bounds how long a user may take at the identity provider.
+const oidcStateTTL = 10 * time.Minute
+
+// OIDCConfig configures login through an OpenID Connect provider with the
+// authorization code flow. The provider's endpoints are discovered from
+// Issuer, so pointing Issuer at a local mock IdP is enough for tests.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// GroupsClaim names the ID token claim holding the user's groups.
+	GroupsClaim string
+	// RoleMap maps IdP groups to Sentrinet roles. When several groups match,
+	// the most privileged role wins.
+	RoleMap map[string]string
+	// DefaultRole is given to users in none of the mapped groups. Empty means
+	// such users are refused.
+	DefaultRole string
+	// PostLoginURL, if set, receives the tokens in the URL fragment instead
+	// of the callback answering with JSON.
+	PostLoginURL string
+}
+
+// OIDCConfigFromEnv reads SENTRINET_OIDC_*; it returns nil when no issuer is
+// configured, which leaves SSO disabled.
+func OIDCConfigFromEnv() *OIDCConfig {
+	issuer := os.Getenv("SENTRINET_OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+	cfg := &OIDCConfig{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     os.Getenv("SENTRINET_OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("SENTRINET_OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("SENTRINET_OIDC_REDIRECT_URL"),
+		Scopes:       strings.Fields("openid profile email"),
+		GroupsClaim:  "groups",
+		RoleMap:      map[string]string{},
+		DefaultRole:  RoleViewer,
+		PostLoginURL: os.Getenv("SENTRINET_OIDC_POST_LOGIN_URL"),
+	}
+	if s := os.Getenv("SENTRINET_OIDC_SCOPES"); s != "" {
+		cfg.Scopes = strings.Fields(s)
+	}
+	if c := os.Getenv("SENTRINET_OIDC_GROUPS_CLAIM"); c != "" {
+		cfg.GroupsClaim = c
+	}
+	if r, ok := os.LookupEnv("SENTRINET_OIDC_DEFAULT_ROLE"); ok {
+		cfg.DefaultRole = r
+	}
+	// SENTRINET_OIDC_ROLE_MAP is a comma separated list of group=role pairs.
+	for _, pair := range strings.Split(os.Getenv("SENTRINET_OIDC_ROLE_MAP"), ",") {
+		group, role, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok {
+			cfg.RoleMap[group] = role
+		}
+	}
+	return cfg
+}
+
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDC runs the login flow for one provider. Discovery and key fetching are
+// lazy so that the server starts while the IdP is unreachable.
+type OIDC struct {
+	cfg    OIDCConfig
+	db     *sqlx.DB
+	client *http.Client
+
+	mu       sync.Mutex
+	provider *oidcProvider
+	jwks     map[string]interface{}
+}
+
+func NewOIDC(db *sqlx.DB, cfg OIDCConfig) *OIDC {
+	return &OIDC{cfg: cfg, db: db, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (o *OIDC) getJSON(u string, out interface{}) error {
+	resp, err := o.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (o *OIDC) discover() (*oidcProvider, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.provider != nil {
+		return o.provider, nil
+	}
+	var p oidcProvider
+	if err := o.getJSON(o.cfg.Issuer+"/.well-known/openid-configuration", &p); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != o.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", p.Issuer, o.cfg.Issuer)
+	}
+	o.provider = &p
+	return &p, nil
+}
+
+// key returns the provider's verification key for kid, refetching the JWKS
+// once when the kid is unknown so provider key rotation is picked up.
+func (o *OIDC) key(p *oidcProvider, kid string) (interface{}, error) {
+	o.mu.Lock()
+	k, ok := o.jwks[kid]
+	o.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := o.getJSON(p.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if pub, err := parseJWK(jwk); err == nil {
+			keys[jwk["kid"]] = pub
+		}
+	}
+	o.mu.Lock()
+	o.jwks = keys
+	o.mu.Unlock()
+
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("oidc jwks: unknown kid %q", kid)
+}
+
+func parseJWK(jwk map[string]string) (interface{}, error) {
+	b := func(name string) []byte {
+		v, _ := base64.RawURLEncoding.DecodeString(jwk[name])
+		return v
+	}
+	switch jwk["kty"] {
+	case "RSA":
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(b("n")), E: int(new(big.Int).SetBytes(b("e")).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk["crv"] {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk["crv"])
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(b("x")), Y: new(big.Int).SetBytes(b("y"))}, nil
+	case "OKP":
+		if jwk["crv"] != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", jwk["crv"])
+		}
+		return ed25519.PublicKey(b("x")), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", jwk["kty"])
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Login redirects the browser to the provider. State, nonce and the PKCE
+// verifier are kept server side until the callback.
+func (o *OIDC) Login(c *fiber.Ctx) error {
+	p, err := o.discover()
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadGateway, err.Error())
+	}
+	state, err := randomString(24)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	nonce, err := randomString(24)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if _, err := o.db.Exec(`DELETE FROM oidc_states WHERE created_at < ?`, time.Now().Add(-oidcStateTTL)); err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if _, err := o.db.Exec(`INSERT INTO oidc_states (state, nonce, verifier, created_at) VALUES (?, ?, ?, ?)`,
+		hashToken(state), nonce, verifier, time.Now()); err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", o.cfg.ClientID)
+	q.Set("redirect_uri", o.cfg.RedirectURL)
+	q.Set("scope", strings.Join(o.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return c.Redirect(p.AuthorizationEndpoint+sep+q.Encode(), fiber.StatusFound)
+}
+
+// Callback completes the flow: it exchanges the code, verifies the ID token,
+// provisions or updates the user and starts a session.
+func (o *OIDC) Callback(c *fiber.Ctx) error {
+	if e := c.Query("error"); e != "" {
+		return fiber.NewError(fiber.StatusUnauthorized, "identity provider: "+e)
+	}
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		return fiber.ErrBadRequest
+	}
+
+	var st struct {
+		Nonce     string    `db:"nonce"`
+		Verifier  string    `db:"verifier"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+	err := o.db.Get(&st, `SELECT nonce, verifier, created_at FROM oidc_states WHERE state = ?`, hashToken(state))
+	if err == sql.ErrNoRows || (err == nil && time.Since(st.CreatedAt) > oidcStateTTL) {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid or expired state")
+	}
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	// A state is single use; the delete decides between concurrent callbacks.
+	res, err := o.db.Exec(`DELETE FROM oidc_states WHERE state = ?`, hashToken(state))
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid or expired state")
+	}
+
+	p, err := o.discover()
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadGateway, err.Error())
+	}
+	rawID, err := o.exchange(p, code, st.Verifier)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadGateway, err.Error())
+	}
+	claims, err := o.verifyIDToken(p, rawID, st.Nonce)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+	}
+
+	user, err := o.provision(claims)
+	if err != nil {
+		return err
+	}
+	tokens, err := StartSession(o.db, user)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	audit.Record(o.db, audit.Event{
+		ActorID: user.ID, Action: audit.ActionLogin, ResourceType: "user", ResourceID: fmt.Sprint(user.ID),
+		Target: user.Username, IP: c.IP(), Detail: "oidc",
+	})
+
+	if o.cfg.PostLoginURL != "" {
+		frag := url.Values{}
+		frag.Set("access_token", tokens.AccessToken)
+		frag.Set("refresh_token", tokens.RefreshToken)
+		frag.Set("expires_in", fmt.Sprint(tokens.ExpiresIn))
+		return c.Redirect(o.cfg.PostLoginURL+"#"+frag.Encode(), fiber.StatusFound)
+	}
+	return c.JSON(tokens)
+}
+
+func (o *OIDC) exchange(p *oidcProvider, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.cfg.RedirectURL)
+	form.Set("client_id", o.cfg.ClientID)
+	form.Set("client_secret", o.cfg.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	resp, err := o.client.PostForm(p.TokenEndpoint, form)
+	if err != nil {
+		return "", fmt.Errorf("oidc token exchange: %w", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("oidc token exchange: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return "", fmt.Errorf("oidc token exchange: %s %s", resp.Status, body.Error)
+	}
+	return body.IDToken, nil
+}
+
+func (o *OIDC) verifyIDToken(p *oidcProvider, raw, nonce string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return o.key(p, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "ES384", "EdDSA"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(o.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("invalid id token: nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("invalid id token: missing sub")
+	}
+	return claims, nil
+}
+
+// roleRank orders the built-in roles by privilege for group mapping.
+var roleRank = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+func (o *OIDC) mapRole(claims jwt.MapClaims) string {
+	role := ""
+	groups, _ := claims[o.cfg.GroupsClaim].([]interface{})
+	for _, g := range groups {
+		name, _ := g.(string)
+		if r, ok := o.cfg.RoleMap[name]; ok && roleRank[r] > roleRank[role] {
+			role = r
+		}
+	}
+	if role == "" {
+		role = o.cfg.DefaultRole
+	}
+	return role
+}
+
+// provision returns the local user linked to the ID token's subject, creating
+// it on first login. The role is re-derived from the groups on every login so
+// that changes at the IdP take effect; when it changes, or the groups no
+// longer grant any role, the user's earlier sessions are revoked.
+func (o *OIDC) provision(claims jwt.MapClaims) (User, error) {
+	sub, _ := claims["sub"].(string)
+	var linked struct {
+		ID   int64  `db:"id"`
+		Role string `db:"role"`
+	}
+	err := o.db.Get(&linked, `SELECT u.id, u.role FROM user_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.issuer = ? AND i.subject = ?`, o.cfg.Issuer, sub)
+	if err != nil && err != sql.ErrNoRows {
+		return User{}, fiber.ErrInternalServerError
+	}
+	known := err == nil
+
+	role := o.mapRole(claims)
+	if role == "" {
+		if known {
+			if _, err := RevokeUserSessions(o.db, linked.ID); err != nil {
+				return User{}, fiber.ErrInternalServerError
+			}
+		}
+		return User{}, fiber.NewError(fiber.StatusForbidden, "no Sentrinet role for your groups")
+	}
+	if ok, err := RoleExists(o.db, role); err != nil || !ok {
+		return User{}, fiber.NewError(fiber.StatusInternalServerError, "role mapping names unknown role "+role)
+	}
+
+	userID := linked.ID
+	if !known {
+		if userID, err = o.createUser(claims, sub, role); err != nil {
+			return User{}, fiber.ErrInternalServerError
+		}
+	}
+
+	if _, err := o.db.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, userID); err != nil {
+		return User{}, fiber.ErrInternalServerError
+	}
+	if known && linked.Role != role {
+		if _, err := RevokeUserSessions(o.db, userID); err != nil {
+			return User{}, fiber.ErrInternalServerError
+		}
+	}
+	var user User
+	if err := o.db.Get(&user, `SELECT * FROM users WHERE id = ?`, userID); err != nil {
+		return User{}, fiber.ErrInternalServerError
+	}
+	return user, nil
+}
+
+func (o *OIDC) createUser(claims jwt.MapClaims, sub, role string) (int64, error) {
+	base, _ := claims["preferred_username"].(string)
+	if base == "" {
+		base, _ = claims["email"].(string)
+	}
+	if base == "" {
+		base = "oidc-" + sub
+	}
+
+	// Local accounts keep their names; an SSO user colliding with one gets
+	// a suffix. The empty password hash never matches, so SSO accounts
+	// cannot log in with a password.
+	username := base
+	for attempt := 0; ; attempt++ {
+		var count int
+		if err := o.db.Get(&count, `SELECT COUNT(*) FROM users WHERE username = ? COLLATE NOCASE`, username); err != nil {
+			return 0, err
+		}
+		if count == 0 {
+			break
+		}
+		if attempt == 10 {
+			return 0, fmt.Errorf("no free username for %s", base)
+		}
+		suffix := make([]byte, 2)
+		if _, err := rand.Read(suffix); err != nil {
+			return 0, err
+		}
+		username = base + "-" + hex.EncodeToString(suffix)
+	}
+
+	res, err := o.db.Exec(`INSERT INTO users (username, password_hash, role) VALUES (?, '', ?)`, username, role)
+	if err != nil {
+		return 0, err
+	}
+	userID, _ := res.LastInsertId()
+	if _, err := o.db.Exec(`INSERT INTO user_identities (issuer, subject, user_id) VALUES (?, ?, ?)`,
+		o.cfg.Issuer, sub, userID); err != nil {
+		return 0, err
+	}
+	if _, err := CreatePersonalWorkspace(o.db, userID, username); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/db/dbtest"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
+)
+
+// mockIdP is a minimal OpenID provider. The test plays the browser: it reads
+// the authorization request from Login's redirect and registers a code for
+// it with authorize, as the provider's login page would.
+type mockIdP struct {
+	t   *testing.T
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]grant
+	// verifiers records the code_verifier sent with each code.
+	verifiers map[string]string
+}
+
+type grant struct {
+	challenge string
+	nonce     string
+	groups    []string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{t: t, key: key, codes: map[string]grant{}, verifiers: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.srv.URL,
+			"authorization_endpoint": idp.srv.URL + "/authorize",
+			"token_endpoint":         idp.srv.URL + "/token",
+			"jwks_uri":               idp.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		enc := base64.RawURLEncoding
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "idp", "alg": "RS256",
+			"n": enc.EncodeToString(key.N.Bytes()),
+			"e": enc.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.srv = httptest.NewServer(mux)
+	t.Cleanup(idp.srv.Close)
+	return idp
+}
+
+func (idp *mockIdP) authorize(code string, g grant) {
+	idp.mu.Lock()
+	idp.codes[code] = g
+	idp.mu.Unlock()
+}
+
+// token redeems a code once, and only with the verifier matching its PKCE
+// challenge.
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	code, verifier := r.FormValue("code"), r.FormValue("code_verifier")
+	idp.mu.Lock()
+	g, ok := idp.codes[code]
+	delete(idp.codes, code)
+	idp.verifiers[code] = verifier
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(verifier))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	groups := []interface{}{}
+	for _, name := range g.groups {
+		groups = append(groups, name)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": idp.srv.URL, "aud": "sentrinet", "sub": "user-1", "preferred_username": "carol",
+		"nonce": g.nonce, "groups": groups,
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = "idp"
+	raw, err := token.SignedString(idp.key)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": raw, "token_type": "Bearer"})
+}
+
+type oidcTest struct {
+	t   *testing.T
+	idp *mockIdP
+	db  *sqlx.DB
+	app *fiber.App
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	idp := newMockIdP(t)
+	database := dbtest.New(t)
+	if err := SeedRoles(database); err != nil {
+		t.Fatal(err)
+	}
+	sso := NewOIDC(database, OIDCConfig{
+		Issuer:      idp.srv.URL,
+		ClientID:    "sentrinet",
+		RedirectURL: "http://sentrinet.test/oidc/callback",
+		Scopes:      []string{"openid"},
+		GroupsClaim: "groups",
+		RoleMap:     map[string]string{"sec-admins": RoleAdmin, "devs": RoleOperator},
+		DefaultRole: "",
+	})
+	app := fiber.New()
+	app.Get("/oidc/login", sso.Login)
+	app.Get("/oidc/callback", sso.Callback)
+	return &oidcTest{t: t, idp: idp, db: database, app: app}
+}
+
+func (o *oidcTest) get(target string) *http.Response {
+	resp, err := o.app.Test(httptest.NewRequest(http.MethodGet, target, nil), -1)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	return resp
+}
+
+// login starts a login and returns the authorization request Login sent the
+// browser to.
+func (o *oidcTest) login() url.Values {
+	resp := o.get("/oidc/login")
+	if resp.StatusCode != http.StatusFound {
+		o.t.Fatalf("login: status %d", resp.StatusCode)
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	q := loc.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		o.t.Fatalf("login: no PKCE challenge in %s", loc)
+	}
+	return q
+}
+
+func (o *oidcTest) callback(code, state string) *http.Response {
+	return o.get("/oidc/callback?" + url.Values{"code": {code}, "state": {state}}.Encode())
+}
+
+func (o *oidcTest) role() string {
+	var role string
+	err := o.db.Get(&role, `SELECT u.role FROM users u JOIN user_identities i ON i.user_id = u.id
+		WHERE i.issuer = ? AND i.subject = ?`, o.idp.srv.URL, "user-1")
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	return role
+}
+
+func (o *oidcTest) activeSessions() int {
+	var count int
+	if err := o.db.Get(&count, `SELECT COUNT(*) FROM sessions WHERE revoked_at IS NULL`); err != nil {
+		o.t.Fatal(err)
+	}
+	return count
+}
+
+func TestOIDCLogin(t *testing.T) {
+	o := newOIDCTest(t)
+
+	q := o.login()
+	o.idp.authorize("code-1", grant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), groups: []string{"devs", "sec-admins"}})
+	resp := o.callback("code-1", q.Get("state"))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback: status %d", resp.StatusCode)
+	}
+	var tokens Tokens
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil || tokens.AccessToken == "" {
+		t.Fatalf("callback: no tokens (%v)", err)
+	}
+	if o.idp.verifiers["code-1"] == "" {
+		t.Fatal("code_verifier was not sent to the token endpoint")
+	}
+	if got := o.role(); got != RoleAdmin {
+		t.Fatalf("role after first login = %q, want %q", got, RoleAdmin)
+	}
+
+	t.Run("state is single use", func(t *testing.T) {
+		o.idp.authorize("code-2", grant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), groups: []string{"sec-admins"}})
+		if resp := o.callback("code-2", q.Get("state")); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("reused state: status %d, want 401", resp.StatusCode)
+		}
+		if _, ok := o.idp.verifiers["code-2"]; ok {
+			t.Fatal("reused state reached the token endpoint")
+		}
+	})
+
+	t.Run("role follows groups on every login", func(t *testing.T) {
+		q := o.login()
+		o.idp.authorize("code-3", grant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), groups: []string{"devs"}})
+		if resp := o.callback("code-3", q.Get("state")); resp.StatusCode != http.StatusOK {
+			t.Fatalf("callback: status %d", resp.StatusCode)
+		}
+		if got := o.role(); got != RoleOperator {
+			t.Fatalf("role after regrouping = %q, want %q", got, RoleOperator)
+		}
+		var count int
+		o.db.Get(&count, `SELECT COUNT(*) FROM user_identities`)
+		if count != 1 {
+			t.Fatalf("%d identities, want the first login's user reused", count)
+		}
+		if active := o.activeSessions(); active != 1 {
+			t.Fatalf("%d active sessions after the role changed, want only the new one", active)
+		}
+
+		q = o.login()
+		o.idp.authorize("code-4", grant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), groups: []string{"guests"}})
+		if resp := o.callback("code-4", q.Get("state")); resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("unmapped groups: status %d, want 403", resp.StatusCode)
+		}
+		if active := o.activeSessions(); active != 0 {
+			t.Fatalf("%d active sessions after the groups lost their role, want none", active)
+		}
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		q := o.login()
+		o.idp.authorize("code-5", grant{challenge: q.Get("code_challenge"), nonce: "other", groups: []string{"sec-admins"}})
+		if resp := o.callback("code-5", q.Get("state")); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("nonce mismatch: status %d, want 401", resp.StatusCode)
+		}
+	})
+
+	t.Run("verifier must match challenge", func(t *testing.T) {
+		q := o.login()
+		o.idp.authorize("code-6", grant{challenge: "not-the-challenge", nonce: q.Get("nonce"), groups: []string{"sec-admins"}})
+		if resp := o.callback("code-6", q.Get("state")); resp.StatusCode != http.StatusBadGateway {
+			t.Fatalf("wrong verifier: status %d, want 502", resp.StatusCode)
+		}
+	})
+}
+
+func TestOIDCUsernameCollision(t *testing.T) {
+	o := newOIDCTest(t)
+	// Usernames are unique regardless of case, so "Carol" takes "carol".
+	if _, err := o.db.Exec(`INSERT INTO users (username, password_hash) VALUES ('Carol', 'x')`); err != nil {
+		t.Fatal(err)
+	}
+
+	q := o.login()
+	o.idp.authorize("code-1", grant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), groups: []string{"devs"}})
+	if resp := o.callback("code-1", q.Get("state")); resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback: status %d", resp.StatusCode)
+	}
+	var username string
+	if err := o.db.Get(&username, `SELECT u.username FROM users u JOIN user_identities i ON i.user_id = u.id`); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(username, "carol-") {
+		t.Fatalf("SSO username = %q, want a suffixed carol", username)
+	}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// bcrypt ignores everything after 72 bytes, so longer passwords would give a
+// false sense of strength.
+const maxPasswordBytes = 72
+
+// PasswordPolicy is configured with SENTRINET_PASSWORD_MIN_LENGTH and
+// SENTRINET_PASSWORD_MIN_CLASSES, the number of character classes (lower,
+// upper, digit, other) a password must mix.
+type PasswordPolicy struct {
+	MinLength  int
+	MinClasses int
+}
+
+func PasswordPolicyFromEnv() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:  envInt("SENTRINET_PASSWORD_MIN_LENGTH", 10),
+		MinClasses: envInt("SENTRINET_PASSWORD_MIN_CLASSES", 2),
+	}
+}
+
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
+
+// Check returns a user facing reason why password is not acceptable for
+// username, or nil.
+func (p PasswordPolicy) Check(username, password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordBytes)
+	}
+	if username != "" && strings.EqualFold(password, username) {
+		return fmt.Errorf("password must not be the username")
+	}
+
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, other} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < p.MinClasses {
+		return fmt.Errorf("password must mix at least %d of lowercase, uppercase, digits and symbols", p.MinClasses)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+)
+
+const (
+	PermScansRead         = "scans:read"
+	PermScansRun          = "scans:run"
+	PermScansDelete       = "scans:delete"
+	PermSchedulesRead     = "schedules:read"
+	PermSchedulesWrite    = "schedules:write"
+	PermNotificationsRead = "notifications:read"
+	PermAlertsWrite       = "alerts:write"
+	PermUsersManage       = "users:manage"
+	PermCleanupManage     = "cleanup:manage"
+	PermAdminAccess       = "admin:access"
+	PermAuditRead         = "audit:read"
+	PermTargetsManage     = "targets:manage"
+	PermQuotasManage      = "quotas:manage"
+)
+
+// defaultRoles seeds the roles and role_permissions tables. Permissions added
+// to a built-in role here are granted on the next start; permissions granted
+// through the database are left alone.
+var defaultRoles = []struct {
+	name        string
+	description string
+	permissions []string
+}{
+	{RoleViewer, "Read scan results, schedules and notifications", []string{
+		PermScansRead, PermSchedulesRead, PermNotificationsRead,
+	}},
+	{RoleOperator, "Run and schedule scans and manage alert rules", []string{
+		PermScansRead, PermSchedulesRead, PermNotificationsRead,
+		PermScansRun, PermScansDelete, PermSchedulesWrite, PermAlertsWrite,
+	}},
+	{RoleAdmin, "Manage users, cleanup, retention, scan targets, quotas and every resource", []string{
+		PermScansRead, PermSchedulesRead, PermNotificationsRead,
+		PermScansRun, PermScansDelete, PermSchedulesWrite, PermAlertsWrite,
+		PermUsersManage, PermCleanupManage, PermAdminAccess, PermAuditRead,
+		PermTargetsManage, PermQuotasManage,
+	}},
+}
+
+type Role struct {
+	Name        string   `db:"name" json:"name"`
+	Description string   `db:"description" json:"description"`
+	Permissions []string `db:"-" json:"permissions"`
+}
+
+// SeedRoles creates the built-in roles and moves users from the role used
+// before roles existed to the operator role, which keeps their access.
+func SeedRoles(db *sqlx.DB) error {
+	for _, r := range defaultRoles {
+		if _, err := db.Exec(`INSERT OR IGNORE INTO roles (name, description) VALUES (?, ?)`, r.name, r.description); err != nil {
+			return fmt.Errorf("seed role %s: %w", r.name, err)
+		}
+		for _, p := range r.permissions {
+			if _, err := db.Exec(`INSERT OR IGNORE INTO role_permissions (role, permission) VALUES (?, ?)`, r.name, p); err != nil {
+				return fmt.Errorf("seed permission %s/%s: %w", r.name, p, err)
+			}
+		}
+	}
+	_, err := db.Exec(`UPDATE users SET role = ? WHERE role = 'user' OR role NOT IN (SELECT name FROM roles)`, RoleOperator)
+	return err
+}
+
+// DefaultRole is given to newly registered users. It can be changed with
+// SENTRINET_DEFAULT_ROLE, e.g. to viewer for read-only self sign-up.
+func DefaultRole() string {
+	if r := os.Getenv("SENTRINET_DEFAULT_ROLE"); r != "" {
+		return r
+	}
+	return RoleOperator
+}
+
+func RolePermissions(db *sqlx.DB, role string) ([]string, error) {
+	perms := []string{}
+	err := db.Select(&perms, `SELECT permission FROM role_permissions WHERE role = ? ORDER BY permission`, role)
+	return perms, err
+}
+
+func ListRoles(db *sqlx.DB) ([]Role, error) {
+	roles := []Role{}
+	if err := db.Select(&roles, `SELECT name, description FROM roles ORDER BY name`); err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		perms, err := RolePermissions(db, roles[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		roles[i].Permissions = perms
+	}
+	return roles, nil
+}
+
+func RoleExists(db *sqlx.DB, role string) (bool, error) {
+	var count int
+	err := db.Get(&count, `SELECT COUNT(*) FROM roles WHERE name = ?`, role)
+	return count > 0, err
+}
+
+// RequirePermission must run after JWTMiddleware. It rejects callers whose
+// token does not carry perm.
+func RequirePermission(perm string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !Caller(c).Can(perm) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "missing permission " + perm})
+		}
+		return c.Next()
+	}
+}
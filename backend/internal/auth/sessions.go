@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// sessionDB is consulted by JWTMiddleware to reject tokens of revoked
+// sessions. It is set by UseSessions.
+var sessionDB *sqlx.DB
+
+// UseSessions enables the session revocation check in JWTMiddleware.
+func UseSessions(db *sqlx.DB) {
+	sessionDB = db
+}
+
+// Tokens is the response body of /login and /refresh. Token duplicates
+// AccessToken for clients written before refresh tokens existed.
+type Tokens struct {
+	Token        string `json:"token"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+func newRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// StartSession creates a server-side session for user and returns its first
+// token pair.
+func StartSession(db *sqlx.DB, user User) (Tokens, error) {
+	res, err := db.Exec(`INSERT INTO sessions (user_id, expires_at) VALUES (?, ?)`,
+		user.ID, time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		return Tokens{}, err
+	}
+	sid, _ := res.LastInsertId()
+	return issueTokens(db, sid, user)
+}
+
+// issueTokens mints an access token for session sid and stores a fresh
+// refresh token for it.
+func issueTokens(db *sqlx.DB, sid int64, user User) (Tokens, error) {
+	perms, err := RolePermissions(db, user.Role)
+	if err != nil {
+		return Tokens{}, err
+	}
+	access, err := signToken(jwt.MapClaims{
+		"user_id": user.ID,
+		"role":    user.Role,
+		"perms":   perms,
+		"sid":     sid,
+		"exp":     time.Now().Add(accessTokenTTL).Unix(),
+	})
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	refresh, err := newRefreshToken()
+	if err != nil {
+		return Tokens{}, err
+	}
+	expires := time.Now().Add(refreshTokenTTL)
+	if _, err := db.Exec(`INSERT INTO refresh_tokens (session_id, token_hash, expires_at) VALUES (?, ?, ?)`,
+		sid, hashToken(refresh), expires); err != nil {
+		return Tokens{}, err
+	}
+	if _, err := db.Exec(`UPDATE sessions SET expires_at = ?, last_used_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		expires, sid); err != nil {
+		return Tokens{}, err
+	}
+
+	return Tokens{
+		Token:        access,
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// RefreshSession exchanges a refresh token for a new token pair. Each refresh
+// token is single use; presenting one a second time means it was stolen, so
+// the whole session is revoked.
+func RefreshSession(db *sqlx.DB, refresh, ip string) (Tokens, error) {
+	var rt struct {
+		ID        int64      `db:"id"`
+		SessionID int64      `db:"session_id"`
+		ExpiresAt time.Time  `db:"expires_at"`
+		UsedAt    *time.Time `db:"used_at"`
+	}
+	err := db.Get(&rt, `SELECT id, session_id, expires_at, used_at FROM refresh_tokens WHERE token_hash = ?`,
+		hashToken(refresh))
+	if err == sql.ErrNoRows {
+		return Tokens{}, fiber.NewError(fiber.StatusUnauthorized, "invalid refresh token")
+	}
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	if rt.UsedAt != nil {
+		return Tokens{}, refreshReused(db, rt.SessionID, ip)
+	}
+	if rt.ExpiresAt.Before(time.Now()) {
+		return Tokens{}, fiber.NewError(fiber.StatusUnauthorized, "refresh token expired")
+	}
+	if err := checkSession(db, rt.SessionID); err != nil {
+		return Tokens{}, err
+	}
+
+	// Marking the token used only if it is still unused makes concurrent
+	// refreshes with the same token race safely: one wins, the other is
+	// treated as reuse.
+	res, err := db.Exec(`UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = ? AND used_at IS NULL`, rt.ID)
+	if err != nil {
+		return Tokens{}, err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return Tokens{}, refreshReused(db, rt.SessionID, ip)
+	}
+
+	var user User
+	if err := db.Get(&user, `SELECT u.* FROM users u JOIN sessions s ON s.user_id = u.id WHERE s.id = ?`, rt.SessionID); err != nil {
+		return Tokens{}, err
+	}
+	return issueTokens(db, rt.SessionID, user)
+}
+
+// refreshReused revokes a session whose refresh token was presented twice,
+// which means it was stolen, and records the incident.
+func refreshReused(db *sqlx.DB, sid int64, ip string) error {
+	if err := RevokeSession(db, sid); err != nil {
+		return err
+	}
+	var userID int64
+	_ = db.Get(&userID, `SELECT user_id FROM sessions WHERE id = ?`, sid)
+	audit.Record(db, audit.Event{
+		ActorID: userID, Action: audit.ActionTokenReuse, ResourceType: "session", ResourceID: fmt.Sprint(sid), IP: ip,
+	})
+	return fiber.NewError(fiber.StatusUnauthorized, "refresh token reused, session revoked")
+}
+
+// checkSession returns an unauthorized error if session sid is revoked,
+// expired or unknown.
+func checkSession(db *sqlx.DB, sid int64) error {
+	var s struct {
+		ExpiresAt time.Time  `db:"expires_at"`
+		RevokedAt *time.Time `db:"revoked_at"`
+	}
+	err := db.Get(&s, `SELECT expires_at, revoked_at FROM sessions WHERE id = ?`, sid)
+	if err == sql.ErrNoRows || (err == nil && (s.RevokedAt != nil || s.ExpiresAt.Before(time.Now()))) {
+		return fiber.NewError(fiber.StatusUnauthorized, "session revoked")
+	}
+	return err
+}
+
+func RevokeSession(db *sqlx.DB, sid int64) error {
+	_, err := db.Exec(`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL`, sid)
+	return err
+}
+
+// RevokeUserSessions revokes every active session of userID and returns how
+// many were revoked.
+func RevokeUserSessions(db *sqlx.DB, userID int64) (int64, error) {
+	res, err := db.Exec(`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("revoke sessions of user %d: %w", userID, err)
+	}
+	return res.RowsAffected()
+}
+
+// SessionID returns the session of the request's access token, or 0 for
+// requests authenticated otherwise.
+func SessionID(c *fiber.Ctx) int64 {
+	sid, _ := c.Locals("session_id").(int64)
+	return sid
+}
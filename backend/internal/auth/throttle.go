@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
+	"github.com/jmoiron/sqlx"
+)
+
+// Throttle counts failed logins per account and per client IP. A key that
+// fails Limit times within Window is locked for Lockout. Counters live in the
+// database so that they survive restarts.
+type Throttle struct {
+	db *sqlx.DB
+	mu sync.Mutex
+
+	AccountLimit int
+	IPLimit      int
+	Window       time.Duration
+	Lockout      time.Duration
+}
+
+func NewThrottle(db *sqlx.DB) *Throttle {
+	return &Throttle{
+		db:           db,
+		AccountLimit: envInt("SENTRINET_LOGIN_MAX_FAILURES", 5),
+		IPLimit:      envInt("SENTRINET_LOGIN_MAX_IP_FAILURES", 20),
+		Window:       time.Duration(envInt("SENTRINET_LOGIN_WINDOW_SECONDS", 900)) * time.Second,
+		Lockout:      time.Duration(envInt("SENTRINET_LOGIN_LOCKOUT_SECONDS", 900)) * time.Second,
+	}
+}
+
+func accountKey(username string) string { return "user:" + strings.ToLower(username) }
+func ipKey(ip string) string            { return "ip:" + ip }
+
+// Locked returns how long the account or IP remains locked, or 0.
+func (t *Throttle) Locked(username, ip string) (time.Duration, error) {
+	now := time.Now().Unix()
+	var until int64
+	err := t.db.Get(&until, `SELECT COALESCE(MAX(locked_until), 0) FROM login_throttle WHERE key IN (?, ?)`,
+		accountKey(username), ipKey(ip))
+	if err != nil || until <= now {
+		return 0, err
+	}
+	return time.Duration(until-now) * time.Second, nil
+}
+
+// Fail records a failed attempt and locks the account or IP once its limit is
+// reached. Every lockout is written to the audit log.
+func (t *Throttle) Fail(username, ip string) {
+	if t.bump(accountKey(username), t.AccountLimit) {
+		audit.Record(t.db, audit.Event{
+			Action: audit.ActionAccountLocked, Target: username, IP: ip,
+			Detail: fmt.Sprintf("%d failed logins, locked for %s", t.AccountLimit, t.Lockout),
+		})
+	}
+	if t.bump(ipKey(ip), t.IPLimit) {
+		audit.Record(t.db, audit.Event{
+			Action: audit.ActionIPLocked, Target: ip, IP: ip,
+			Detail: fmt.Sprintf("%d failed logins, locked for %s", t.IPLimit, t.Lockout),
+		})
+	}
+}
+
+// Succeed clears the account's failures. The IP counter is left alone so that
+// one valid account cannot be used to reset guessing against others.
+func (t *Throttle) Succeed(username string) {
+	if _, err := t.db.Exec(`DELETE FROM login_throttle WHERE key = ?`, accountKey(username)); err != nil {
+		fmt.Println("[Auth] throttle reset: ", err)
+	}
+}
+
+// bump increments key's failures and reports whether this attempt locked it.
+// A limit of 0 disables the counter.
+func (t *Throttle) bump(key string, limit int) bool {
+	if limit <= 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now().Unix()
+	var row struct {
+		Failures    int   `db:"failures"`
+		WindowStart int64 `db:"window_start"`
+	}
+	err := t.db.Get(&row, `SELECT failures, window_start FROM login_throttle WHERE key = ?`, key)
+	if err == sql.ErrNoRows || (err == nil && row.WindowStart < now-int64(t.Window.Seconds())) {
+		row.Failures, row.WindowStart = 0, now
+	} else if err != nil {
+		fmt.Println("[Auth] throttle read: ", err)
+		return false
+	}
+	row.Failures++
+
+	lockedUntil := int64(0)
+	locked := row.Failures >= limit
+	if locked {
+		lockedUntil = now + int64(t.Lockout.Seconds())
+		row.Failures, row.WindowStart = 0, now
+	}
+	_, err = t.db.Exec(`INSERT INTO login_throttle (key, failures, window_start, locked_until) VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET failures = excluded.failures, window_start = excluded.window_start,
+		locked_until = MAX(login_throttle.locked_until, excluded.locked_until)`,
+		key, row.Failures, row.WindowStart, lockedUntil)
+	if err != nil {
+		fmt.Println("[Auth] throttle write: ", err)
+	}
+	return locked
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TOTP parameters follow RFC 6238 with the defaults every authenticator app
+// supports: SHA-1, six digits and a 30 second step.
+const (
+	totpStep   = 30
+	totpDigits = 6
+	// totpSkew is the number of steps accepted on either side of now to allow
+	// for clock drift.
+	totpSkew = 1
+
+	totpIssuer        = "Sentrinet"
+	recoveryCodeCount = 10
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(buf), nil
+}
+
+// ProvisioningURI is the otpauth:// URI authenticator apps import, usually by
+// rendering it as a QR code.
+func ProvisioningURI(username, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpStep))
+	label := url.PathEscape(totpIssuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, bin%1000000)
+}
+
+// matchTOTP returns the time step code is valid for, or 0 if it matches none
+// of the steps within the allowed skew.
+func matchTOTP(secret, code string, now time.Time) int64 {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0
+	}
+	current := now.Unix() / totpStep
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return 0
+}
+
+// EnrollTOTP stores a new, not yet confirmed, secret for userID. Any previous
+// secret is replaced, which is why enrollment is refused while 2FA is on.
+func EnrollTOTP(db *sqlx.DB, userID int64) (string, error) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	res, err := db.Exec(`UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ? AND totp_enabled = 0`,
+		secret, userID)
+	if err != nil {
+		return "", err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return "", fmt.Errorf("two-factor authentication is already enabled")
+	}
+	return secret, nil
+}
+
+// VerifyTOTP checks code against userID's secret. A code is accepted once:
+// steps at or before the last accepted one are rejected to stop replays.
+func VerifyTOTP(db *sqlx.DB, userID int64, code string) (bool, error) {
+	var u struct {
+		Secret   *string `db:"totp_secret"`
+		LastStep int64   `db:"totp_last_step"`
+	}
+	if err := db.Get(&u, `SELECT totp_secret, totp_last_step FROM users WHERE id = ?`, userID); err != nil {
+		return false, err
+	}
+	if u.Secret == nil {
+		return false, nil
+	}
+	step := matchTOTP(*u.Secret, strings.TrimSpace(code), time.Now())
+	if step == 0 || step <= u.LastStep {
+		return false, nil
+	}
+	res, err := db.Exec(`UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	count, _ := res.RowsAffected()
+	return count == 1, nil
+}
+
+// EnableTOTP turns 2FA on after the first code was verified and returns a
+// fresh set of recovery codes.
+func EnableTOTP(db *sqlx.DB, userID int64) ([]string, error) {
+	if _, err := db.Exec(`UPDATE users SET totp_enabled = 1 WHERE id = ?`, userID); err != nil {
+		return nil, err
+	}
+	return NewRecoveryCodes(db, userID)
+}
+
+// DisableTOTP removes the secret and recovery codes of userID. It backs both
+// a user turning 2FA off and an admin resetting it.
+func DisableTOTP(db *sqlx.DB, userID int64) error {
+	res, err := db.Exec(`UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0 WHERE id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return fmt.Errorf("user %d not found", userID)
+	}
+	_, err = db.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	return err
+}
+
+// NewRecoveryCodes replaces userID's recovery codes. Only hashes are stored;
+// the plaintext codes are returned once.
+func NewRecoveryCodes(db *sqlx.DB, userID int64) ([]string, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 8)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		h := hex.EncodeToString(buf)
+		code := h[:8] + "-" + h[8:]
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hashToken(code)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, tx.Commit()
+}
+
+// UseRecoveryCode consumes one of userID's recovery codes.
+func UseRecoveryCode(db *sqlx.DB, userID int64, code string) (bool, error) {
+	res, err := db.Exec(`UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		userID, hashToken(strings.ToLower(strings.TrimSpace(code))))
+	if err != nil {
+		return false, err
+	}
+	count, _ := res.RowsAffected()
+	return count == 1, nil
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+// WorkspaceHeader selects the active workspace of a request. Requests without
+// it act in the caller's default workspace.
+const WorkspaceHeader = "X-Workspace-ID"
+
+type Workspace struct {
+	ID        int64     `db:"id" json:"id"`
+	Name      string    `db:"name" json:"name"`
+	CreatedBy int64     `db:"created_by" json:"created_by"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	// Role is the caller's role in the workspace when listing memberships.
+	Role string `db:"role" json:"role,omitempty"`
+}
+
+type Member struct {
+	UserID   int64     `db:"user_id" json:"user_id"`
+	Username string    `db:"username" json:"username"`
+	Role     string    `db:"role" json:"role"`
+	JoinedAt time.Time `db:"created_at" json:"joined_at"`
+}
+
+// CreateWorkspace creates a workspace and makes ownerID its admin.
+func CreateWorkspace(db *sqlx.DB, name string, ownerID int64) (int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO workspaces (name, created_by) VALUES (?, ?)`, name, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	if _, err := tx.Exec(`INSERT INTO workspace_members (workspace_id, user_id, role) VALUES (?, ?, ?)`,
+		id, ownerID, RoleAdmin); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// CreatePersonalWorkspace creates the workspace a user starts in and makes it
+// their default.
+func CreatePersonalWorkspace(db *sqlx.DB, userID int64, username string) (int64, error) {
+	id, err := CreateWorkspace(db, username, userID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = db.Exec(`UPDATE users SET default_workspace_id = ? WHERE id = ?`, id, userID)
+	return id, err
+}
+
+// EnsureWorkspaces gives every user without one a personal workspace and moves
+// rows created before workspaces existed into their creator's workspace.
+func EnsureWorkspaces(db *sqlx.DB) error {
+	users := []User{}
+	if err := db.Select(&users, `SELECT * FROM users WHERE default_workspace_id IS NULL`); err != nil {
+		return err
+	}
+	for _, u := range users {
+		if _, err := CreatePersonalWorkspace(db, u.ID, u.Username); err != nil {
+			return fmt.Errorf("workspace for %s: %w", u.Username, err)
+		}
+	}
+
+	for _, table := range []string{"scans", "jobs", "notifications", "probe_results"} {
+		_, err := db.Exec(fmt.Sprintf(`UPDATE %s SET workspace_id =
+			(SELECT default_workspace_id FROM users WHERE users.id = %s.user_id)
+			WHERE workspace_id IS NULL`, table, table))
+		if err != nil {
+			return fmt.Errorf("backfill %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// MemberRole returns userID's role in workspaceID, or sql.ErrNoRows if they
+// are not a member.
+func MemberRole(db *sqlx.DB, workspaceID, userID int64) (string, error) {
+	var role string
+	err := db.Get(&role, `SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?`,
+		workspaceID, userID)
+	return role, err
+}
+
+func ListWorkspaces(db *sqlx.DB, userID int64) ([]Workspace, error) {
+	ws := []Workspace{}
+	err := db.Select(&ws, `SELECT w.id, w.name, w.created_by, w.created_at, m.role
+		FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE m.user_id = ? ORDER BY w.name`, userID)
+	return ws, err
+}
+
+func ListMembers(db *sqlx.DB, workspaceID int64) ([]Member, error) {
+	members := []Member{}
+	err := db.Select(&members, `SELECT m.user_id, u.username, m.role, m.created_at
+		FROM workspace_members m JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id = ? ORDER BY u.username`, workspaceID)
+	return members, err
+}
+
+func workspaceAdmins(db *sqlx.DB, workspaceID int64) (int, error) {
+	var count int
+	err := db.Get(&count, `SELECT COUNT(*) FROM workspace_members WHERE workspace_id = ? AND role = ?`,
+		workspaceID, RoleAdmin)
+	return count, err
+}
+
+// SetMemberRole adds userID to the workspace or changes their role. It refuses
+// to demote the last admin of the workspace. Changing a member's role revokes
+// their sessions, whose tokens carry the old role.
+func SetMemberRole(db *sqlx.DB, workspaceID, userID int64, role string) error {
+	current, err := MemberRole(db, workspaceID, userID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if current == RoleAdmin && role != RoleAdmin {
+		count, err := workspaceAdmins(db, workspaceID)
+		if err != nil {
+			return err
+		}
+		if count <= 1 {
+			return fiber.NewError(fiber.StatusConflict, "a workspace needs at least one admin")
+		}
+	}
+	_, err = db.Exec(`INSERT INTO workspace_members (workspace_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT(workspace_id, user_id) DO UPDATE SET role = excluded.role`, workspaceID, userID, role)
+	if err != nil || current == "" || current == role {
+		return err
+	}
+	_, err = RevokeUserSessions(db, userID)
+	return err
+}
+
+// RemoveMember removes userID from the workspace and revokes their sessions,
+// whose tokens may still name it. It refuses to remove the last admin.
+func RemoveMember(db *sqlx.DB, workspaceID, userID int64) error {
+	current, err := MemberRole(db, workspaceID, userID)
+	if err == sql.ErrNoRows {
+		return fiber.NewError(fiber.StatusNotFound, "member not found")
+	}
+	if err != nil {
+		return err
+	}
+	if current == RoleAdmin {
+		count, err := workspaceAdmins(db, workspaceID)
+		if err != nil {
+			return err
+		}
+		if count <= 1 {
+			return fiber.NewError(fiber.StatusConflict, "a workspace needs at least one admin")
+		}
+	}
+	if _, err := db.Exec(`DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?`,
+		workspaceID, userID); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`UPDATE users SET default_workspace_id = NULL WHERE id = ? AND default_workspace_id = ?`,
+		userID, workspaceID); err != nil {
+		return err
+	}
+	_, err = RevokeUserSessions(db, userID)
+	return err
+}
+
+// WorkspaceMiddleware must run after JWTMiddleware and before
+// RequirePermission. It resolves the active workspace, checks that the caller
+// is a member and narrows the token permissions to those of the caller's role
+// in the workspace. Global admins may act in any workspace.
+func WorkspaceMiddleware(db *sqlx.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		caller := Caller(c)
+
+		var wsID int64
+		if h := c.Get(WorkspaceHeader); h != "" {
+			id, err := strconv.ParseInt(h, 10, 64)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid " + WorkspaceHeader})
+			}
+			wsID = id
+		} else {
+			var def sql.NullInt64
+			if err := db.Get(&def, `SELECT default_workspace_id FROM users WHERE id = ?`, caller.UserID); err != nil {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unknown user"})
+			}
+			if !def.Valid {
+				return c.Status(400).JSON(fiber.Map{"error": "no default workspace, set " + WorkspaceHeader})
+			}
+			wsID = def.Int64
+		}
+
+		role, err := MemberRole(db, wsID, caller.UserID)
+		if err == sql.ErrNoRows && caller.IsAdmin() {
+			role, err = RoleAdmin, nil
+		}
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "not a member of this workspace"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		if !caller.IsAdmin() {
+			allowed, err := RolePermissions(db, role)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			c.Locals("permissions", intersect(caller.Permissions, allowed))
+		}
+		c.Locals("workspace_id", wsID)
+		c.Locals("workspace_role", role)
+		return c.Next()
+	}
+}
+
+func intersect(a, b []string) []string {
+	set := map[string]bool{}
+	for _, s := range b {
+		set[s] = true
+	}
+	out := []string{}
+	for _, s := range a {
+		if set[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
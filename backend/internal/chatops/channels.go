@@ -0,0 +1,193 @@
+// Package chatops posts notifications to chat channels through Slack and
+// Microsoft Teams incoming webhooks. Each channel of a workspace routes the
+// notifications it wants by type, alert rule and severity, and is rate
+// limited so that a noisy scan cannot flood it.
+package chatops
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/egress"
+	"github.com/KuberTheGreat/Sentrinet/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// Channel kinds.
+const (
+	KindSlack = "slack"
+	KindTeams = "teams"
+)
+
+// Channel is an incoming webhook of a chat channel. Empty Types and RuleIDs
+// match every notification; RateLimit is the most messages posted per hour,
+// 0 meaning unlimited.
+type Channel struct {
+	ID          int64     `db:"id" json:"id"`
+	WorkspaceID int64     `db:"workspace_id" json:"workspace_id"`
+	CreatedBy   *int64    `db:"created_by" json:"created_by,omitempty"`
+	Name        string    `db:"name" json:"name"`
+	Kind        string    `db:"kind" json:"kind"`
+	URL         string    `db:"url" json:"-"`
+	Enabled     bool      `db:"enabled" json:"enabled"`
+	TypeList    string    `db:"types" json:"-"`
+	Types       []string  `db:"-" json:"types"`
+	RuleList    string    `db:"rule_ids" json:"-"`
+	RuleIDs     []int64   `db:"-" json:"rule_ids"`
+	MinSeverity string    `db:"min_severity" json:"min_severity"`
+	RateLimit   int       `db:"rate_limit" json:"rate_limit_per_hour"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	// URLHint shows where the channel posts without revealing the webhook
+	// token in the URL path.
+	URLHint string `db:"-" json:"url_hint"`
+}
+
+func (ch *Channel) decode() {
+	ch.Types = []string{}
+	if ch.TypeList != "" {
+		ch.Types = strings.Split(ch.TypeList, ",")
+	}
+	ch.RuleIDs = []int64{}
+	for _, s := range strings.Split(ch.RuleList, ",") {
+		if id, err := strconv.ParseInt(s, 10, 64); err == nil {
+			ch.RuleIDs = append(ch.RuleIDs, id)
+		}
+	}
+	if u, err := url.Parse(ch.URL); err == nil {
+		ch.URLHint = u.Scheme + "://" + u.Host + "/…"
+	}
+}
+
+func (ch *Channel) encode() {
+	for i, t := range ch.Types {
+		ch.Types[i] = strings.TrimSpace(t)
+	}
+	ch.TypeList = strings.Join(ch.Types, ",")
+	ids := make([]string, len(ch.RuleIDs))
+	for i, id := range ch.RuleIDs {
+		ids[i] = strconv.FormatInt(id, 10)
+	}
+	ch.RuleList = strings.Join(ids, ",")
+}
+
+// Validate checks a channel before it is stored.
+func (ch *Channel) Validate() error {
+	ch.Name = strings.TrimSpace(ch.Name)
+	if ch.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if ch.Kind != KindSlack && ch.Kind != KindTeams {
+		return fmt.Errorf("kind must be %q or %q", KindSlack, KindTeams)
+	}
+	if err := egress.CheckURL(ch.URL); err != nil {
+		return err
+	}
+	if ch.MinSeverity == "" {
+		ch.MinSeverity = models.SeverityInfo
+	}
+	if !models.ValidSeverity(ch.MinSeverity) {
+		return fmt.Errorf("min_severity must be one of %s", strings.Join(models.Severities, ", "))
+	}
+	if ch.RateLimit < 0 {
+		return fmt.Errorf("rate_limit_per_hour must not be negative")
+	}
+	return nil
+}
+
+// matches reports whether the channel's routing lets n through.
+func (ch Channel) matches(n models.Notification) bool {
+	if models.SeverityRank(n.Severity) < models.SeverityRank(ch.MinSeverity) {
+		return false
+	}
+	if len(ch.Types) > 0 {
+		found := false
+		for _, t := range ch.Types {
+			found = found || t == n.Type
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(ch.RuleIDs) > 0 {
+		if n.RuleID == nil {
+			return false
+		}
+		found := false
+		for _, id := range ch.RuleIDs {
+			found = found || id == *n.RuleID
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func List(db *sqlx.DB, workspaceID int64) ([]Channel, error) {
+	channels := []Channel{}
+	if err := db.Select(&channels, `SELECT * FROM notification_channels WHERE workspace_id = ? ORDER BY name`, workspaceID); err != nil {
+		return nil, err
+	}
+	for i := range channels {
+		channels[i].decode()
+	}
+	return channels, nil
+}
+
+// Get returns a channel of workspaceID or sql.ErrNoRows.
+func Get(db *sqlx.DB, workspaceID, id int64) (Channel, error) {
+	var ch Channel
+	if err := db.Get(&ch, `SELECT * FROM notification_channels WHERE id = ? AND workspace_id = ?`, id, workspaceID); err != nil {
+		return ch, err
+	}
+	ch.decode()
+	return ch, nil
+}
+
+func Create(db *sqlx.DB, ch Channel) (Channel, error) {
+	if err := ch.Validate(); err != nil {
+		return ch, err
+	}
+	ch.encode()
+	res, err := db.NamedExec(`INSERT INTO notification_channels (workspace_id, created_by, name, kind, url, enabled,
+		types, rule_ids, min_severity, rate_limit) VALUES (:workspace_id, :created_by, :name, :kind, :url, :enabled,
+		:types, :rule_ids, :min_severity, :rate_limit)`, ch)
+	if err != nil {
+		return ch, err
+	}
+	id, _ := res.LastInsertId()
+	return Get(db, ch.WorkspaceID, id)
+}
+
+// Update replaces the editable fields of a channel.
+func Update(db *sqlx.DB, ch Channel) (Channel, error) {
+	if err := ch.Validate(); err != nil {
+		return ch, err
+	}
+	ch.encode()
+	res, err := db.NamedExec(`UPDATE notification_channels SET name = :name, kind = :kind, url = :url,
+		enabled = :enabled, types = :types, rule_ids = :rule_ids, min_severity = :min_severity,
+		rate_limit = :rate_limit WHERE id = :id AND workspace_id = :workspace_id`, ch)
+	if err != nil {
+		return ch, err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return ch, sql.ErrNoRows
+	}
+	return Get(db, ch.WorkspaceID, ch.ID)
+}
+
+func Delete(db *sqlx.DB, workspaceID, id int64) error {
+	if _, err := Get(db, workspaceID, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM channel_messages WHERE channel_id = ?`, id); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM notification_channels WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,217 @@
+package chatops
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// details is what a message shows besides the notification text. Fields the
+// notification does not relate to are left empty.
+type details struct {
+	Workspace string
+	Target    string
+	Port      int
+	Service   string
+	JobID     int64
+	RunID     int64
+	Link      string
+}
+
+// publicURL is the dashboard address links point to, SENTRINET_PUBLIC_URL.
+func publicURL() string {
+	return strings.TrimSuffix(os.Getenv("SENTRINET_PUBLIC_URL"), "/")
+}
+
+// enrich looks up the scan, run and probe a notification refers to.
+func enrich(db *sqlx.DB, n models.Notification) details {
+	var d details
+	db.Get(&d.Workspace, `SELECT name FROM workspaces WHERE id = ?`, n.WorkspaceID)
+
+	if n.RunID != nil {
+		d.RunID = *n.RunID
+	}
+	if n.ScanID != 0 {
+		var scan struct {
+			Target string        `db:"target"`
+			Port   int           `db:"port"`
+			RunID  sql.NullInt64 `db:"run_id"`
+		}
+		if err := db.Get(&scan, `SELECT target, port, run_id FROM scans WHERE id = ?`, n.ScanID); err == nil {
+			d.Target, d.Port = scan.Target, scan.Port
+			if d.RunID == 0 && scan.RunID.Valid {
+				d.RunID = scan.RunID.Int64
+			}
+		}
+	}
+	if d.RunID != 0 {
+		db.Get(&d.JobID, `SELECT job_id FROM job_runs WHERE id = ?`, d.RunID)
+		if d.Target == "" {
+			db.Get(&d.Target, `SELECT target FROM jobs WHERE id = ?`, d.JobID)
+		}
+	}
+	if d.Target != "" && d.Port != 0 {
+		// The HTTP Server header names the service and its version; other
+		// probes only know the protocol.
+		db.Get(&d.Service, `SELECT COALESCE(http_server, kind) FROM probe_results
+			WHERE workspace_id = ? AND target = ? AND port = ? ORDER BY id DESC LIMIT 1`,
+			n.WorkspaceID, d.Target, d.Port)
+	}
+
+	if base := publicURL(); base != "" {
+		d.Link = base
+		if d.RunID != 0 && d.JobID != 0 {
+			d.Link = fmt.Sprintf("%s/schedules/%d/runs/%d/stages", base, d.JobID, d.RunID)
+		}
+	}
+	return d
+}
+
+// facts are the label/value pairs shown under the message.
+func (d details) facts() [][2]string {
+	facts := [][2]string{}
+	if d.Target != "" {
+		facts = append(facts, [2]string{"Target", d.Target})
+	}
+	if d.Port != 0 {
+		facts = append(facts, [2]string{"Port", strconv.Itoa(d.Port)})
+	}
+	if d.Service != "" {
+		facts = append(facts, [2]string{"Service", d.Service})
+	}
+	if d.RunID != 0 {
+		facts = append(facts, [2]string{"Run", fmt.Sprintf("%d (schedule %d)", d.RunID, d.JobID)})
+	}
+	if d.Workspace != "" {
+		facts = append(facts, [2]string{"Workspace", d.Workspace})
+	}
+	return facts
+}
+
+func (d details) linkTitle() string {
+	if d.RunID != 0 {
+		return "View run"
+	}
+	return "Open Sentrinet"
+}
+
+func title(n models.Notification) string {
+	return fmt.Sprintf("%s · %s", strings.ToUpper(n.Severity), strings.ReplaceAll(n.Type, "_", " "))
+}
+
+func timestamp(n models.Notification) string {
+	t := n.CreatedAt
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// slackEscape escapes the characters Slack's mrkdwn treats as markup.
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackPayload renders n as a Block Kit message.
+func slackPayload(n models.Notification, d details) map[string]interface{} {
+	blocks := []interface{}{
+		map[string]interface{}{
+			"type": "header",
+			"text": map[string]interface{}{"type": "plain_text", "text": title(n)},
+		},
+		map[string]interface{}{
+			"type": "section",
+			"text": map[string]interface{}{"type": "mrkdwn", "text": slackEscape.Replace(n.Message)},
+		},
+	}
+	if facts := d.facts(); len(facts) > 0 {
+		fields := []interface{}{}
+		for _, f := range facts {
+			fields = append(fields, map[string]interface{}{
+				"type": "mrkdwn", "text": fmt.Sprintf("*%s*\n%s", f[0], slackEscape.Replace(f[1])),
+			})
+		}
+		blocks = append(blocks, map[string]interface{}{"type": "section", "fields": fields})
+	}
+	if d.Link != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "actions",
+			"elements": []interface{}{map[string]interface{}{
+				"type": "button", "url": d.Link,
+				"text": map[string]interface{}{"type": "plain_text", "text": d.linkTitle()},
+			}},
+		})
+	}
+	blocks = append(blocks, map[string]interface{}{
+		"type": "context",
+		"elements": []interface{}{map[string]interface{}{
+			"type": "mrkdwn", "text": "Sentrinet · " + timestamp(n),
+		}},
+	})
+	return map[string]interface{}{
+		"text":   n.Message,
+		"blocks": blocks,
+	}
+}
+
+// teamsColor maps a severity to an Adaptive Card text color.
+func teamsColor(severity string) string {
+	switch severity {
+	case models.SeverityCritical, models.SeverityHigh:
+		return "Attention"
+	case models.SeverityMedium:
+		return "Warning"
+	}
+	return "Default"
+}
+
+// teamsPayload renders n as an Adaptive Card message, which Teams incoming
+// webhooks and Workflows both accept.
+func teamsPayload(n models.Notification, d details) map[string]interface{} {
+	body := []interface{}{
+		map[string]interface{}{
+			"type": "TextBlock", "text": title(n), "weight": "Bolder", "size": "Medium",
+			"color": teamsColor(n.Severity),
+		},
+		map[string]interface{}{"type": "TextBlock", "text": n.Message, "wrap": true},
+	}
+	if facts := d.facts(); len(facts) > 0 {
+		list := []interface{}{}
+		for _, f := range facts {
+			list = append(list, map[string]interface{}{"title": f[0], "value": f[1]})
+		}
+		body = append(body, map[string]interface{}{"type": "FactSet", "facts": list})
+	}
+	body = append(body, map[string]interface{}{
+		"type": "TextBlock", "text": "Sentrinet · " + timestamp(n), "isSubtle": true, "size": "Small",
+	})
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+	if d.Link != "" {
+		card["actions"] = []interface{}{map[string]interface{}{
+			"type": "Action.OpenUrl", "title": d.linkTitle(), "url": d.Link,
+		}}
+	}
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{map[string]interface{}{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     card,
+		}},
+	}
+}
+
+func payload(kind string, n models.Notification, d details) map[string]interface{} {
+	if kind == KindTeams {
+		return teamsPayload(n, d)
+	}
+	return slackPayload(n, d)
+}
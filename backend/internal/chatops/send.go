@@ -0,0 +1,193 @@
+package chatops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/egress"
+	"github.com/KuberTheGreat/Sentrinet/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// Message states.
+const (
+	StatusSent        = "sent"
+	StatusFailed      = "failed"
+	StatusRateLimited = "rate_limited"
+)
+
+const postTimeout = 10 * time.Second
+
+var client = &http.Client{
+	Timeout:   postTimeout,
+	Transport: egress.Transport(),
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Message is the log entry of one notification routed to a channel.
+type Message struct {
+	ID             int64     `db:"id" json:"id"`
+	ChannelID      int64     `db:"channel_id" json:"channel_id"`
+	NotificationID *int64    `db:"notification_id" json:"notification_id,omitempty"`
+	Status         string    `db:"status" json:"status"`
+	StatusCode     *int      `db:"status_code" json:"status_code,omitempty"`
+	Error          *string   `db:"error" json:"error,omitempty"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+// budget serializes the rate limit check and the reservation of a message so
+// that concurrent notifications cannot overshoot a channel's limit.
+var budget sync.Mutex
+
+// reserve logs a message to ch and returns its id, or 0 if the channel has
+// used up its hourly limit; the rejected message is logged as rate limited.
+func reserve(db *sqlx.DB, ch Channel, notificationID interface{}) (int64, error) {
+	budget.Lock()
+	defer budget.Unlock()
+
+	if ch.RateLimit > 0 {
+		var sent int
+		since := time.Now().UTC().Add(-time.Hour).Format("2006-01-02 15:04:05")
+		if err := db.Get(&sent, `SELECT COUNT(*) FROM channel_messages WHERE channel_id = ? AND status != ?
+			AND created_at > ?`, ch.ID, StatusRateLimited, since); err != nil {
+			return 0, err
+		}
+		if sent >= ch.RateLimit {
+			_, err := db.Exec(`INSERT INTO channel_messages (channel_id, notification_id, status, error) VALUES (?, ?, ?, ?)`,
+				ch.ID, notificationID, StatusRateLimited, fmt.Sprintf("limit of %d messages per hour reached", ch.RateLimit))
+			return 0, err
+		}
+	}
+	res, err := db.Exec(`INSERT INTO channel_messages (channel_id, notification_id, status) VALUES (?, ?, ?)`,
+		ch.ID, notificationID, StatusSent)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// post sends n to ch and returns the receiver's status code, or 0 if none
+// was received.
+func post(ch Channel, n models.Notification, d details) (int, error) {
+	body, err := json.Marshal(payload(ch.Kind, n, d))
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), postTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ch.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// The body is drained but never kept: the URL is user supplied, so what
+	// answers it must not be able to read back through the message log.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("chat service answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// deliver posts n to ch within the channel's rate limit and logs the outcome.
+func deliver(db *sqlx.DB, ch Channel, n models.Notification, d details) error {
+	var notificationID interface{}
+	if n.ID != 0 {
+		notificationID = n.ID
+	}
+	id, err := reserve(db, ch, notificationID)
+	if err != nil || id == 0 {
+		return err
+	}
+	code, sendErr := post(ch, n, d)
+	var codeArg interface{}
+	if code != 0 {
+		codeArg = code
+	}
+	if sendErr != nil {
+		_, err = db.Exec(`UPDATE channel_messages SET status = ?, status_code = ?, error = ? WHERE id = ?`,
+			StatusFailed, codeArg, sendErr.Error(), id)
+		if err != nil {
+			return err
+		}
+		return sendErr
+	}
+	_, err = db.Exec(`UPDATE channel_messages SET status_code = ? WHERE id = ?`, codeArg, id)
+	return err
+}
+
+// Route posts n to every enabled channel of its workspace whose routing
+// matches. Posting happens in the background so that notifying never waits
+// on a chat service.
+func Route(db *sqlx.DB, n models.Notification) {
+	if n.WorkspaceID == 0 {
+		return
+	}
+	channels := []Channel{}
+	if err := db.Select(&channels, `SELECT * FROM notification_channels WHERE workspace_id = ? AND enabled = 1`,
+		n.WorkspaceID); err != nil {
+		fmt.Printf("[Chatops] channels of workspace %d: %v\n", n.WorkspaceID, err)
+		return
+	}
+	matched := []Channel{}
+	for _, ch := range channels {
+		ch.decode()
+		if ch.matches(n) {
+			matched = append(matched, ch)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+	go func() {
+		d := enrich(db, n)
+		for _, ch := range matched {
+			if err := deliver(db, ch, n, d); err != nil {
+				fmt.Printf("[Chatops] notification %d to channel %d: %v\n", n.ID, ch.ID, err)
+			}
+		}
+	}()
+}
+
+// Test posts a sample message to ch, ignoring its routing and rate limit.
+func Test(db *sqlx.DB, ch Channel) error {
+	n := models.Notification{
+		WorkspaceID: ch.WorkspaceID, Type: "test", Severity: models.SeverityInfo,
+		Message: fmt.Sprintf("Test message for channel %q from Sentrinet.", ch.Name), CreatedAt: time.Now().UTC(),
+	}
+	d := enrich(db, n)
+	code, sendErr := post(ch, n, d)
+	status, errArg := StatusSent, interface{}(nil)
+	if sendErr != nil {
+		status, errArg = StatusFailed, sendErr.Error()
+	}
+	var codeArg interface{}
+	if code != 0 {
+		codeArg = code
+	}
+	if _, err := db.Exec(`INSERT INTO channel_messages (channel_id, status, status_code, error) VALUES (?, ?, ?, ?)`,
+		ch.ID, status, codeArg, errArg); err != nil {
+		return err
+	}
+	return sendErr
+}
+
+// Messages lists the most recent messages of a channel.
+func Messages(db *sqlx.DB, channelID int64, limit int) ([]Message, error) {
+	list := []Message{}
+	err := db.Select(&list, `SELECT * FROM channel_messages WHERE channel_id = ? ORDER BY id DESC LIMIT ?`, channelID, limit)
+	return list, err
+}
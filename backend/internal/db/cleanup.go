@@ -15,7 +15,7 @@ func StartCleanupScheduler(database *sqlx.DB){
 		defer ticker.Stop()
 
 		for{
-			if err := removeClosedPortsOlderThan(database, time.Hour); err!= nil{
+			if err := RunCleanup(database); err!= nil{
 				log.Println("[Cleanup Error]: ", err)
 			}
 			<-ticker.C
@@ -23,6 +23,11 @@ func StartCleanupScheduler(database *sqlx.DB){
 	}()
 }
 
+// RunCleanup deletes closed-port rows older than the configured retention.
+func RunCleanup(database *sqlx.DB) error{
+	return removeClosedPortsOlderThan(database, ClosedRetention(database))
+}
+
 func removeClosedPortsOlderThan(db *sqlx.DB, olderThan time.Duration) error{
 	start := time.Now()
 	cutoff := time.Now().Add(-olderThan)
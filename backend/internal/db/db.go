@@ -8,7 +8,7 @@ import (
 )
 
 func InitDB() *sqlx.DB{
-	db, err := sqlx.Open("sqlite", "./sentrinet.db")
+	db, err := sqlx.Open("sqlite", "file:./sentrinet.db?_pragma=busy_timeout(5000)")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -21,7 +21,9 @@ func InitDB() *sqlx.DB{
 		is_open BOOLEAN,
 		duration_ms INTEGER,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		user_id INTEGER REFERENCES users(id)
+		user_id INTEGER REFERENCES users(id),
+		run_id INTEGER REFERENCES job_runs(id),
+		workspace_id INTEGER REFERENCES workspaces(id)
 	);
 
 	CREATE TABLE IF NOT EXISTS jobs(
@@ -32,7 +34,60 @@ func InitDB() *sqlx.DB{
 		interval_seconds INTEGER NOT NULL,
 		active INTEGER NOT NULL DEFAULT 1,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		user_id INTEGER REFERENCES users(id)
+		user_id INTEGER REFERENCES users(id),
+		max_run_seconds INTEGER NOT NULL DEFAULT 0,
+		max_failures INTEGER NOT NULL DEFAULT 0,
+		consecutive_failures INTEGER NOT NULL DEFAULT 0,
+		jitter_seconds INTEGER NOT NULL DEFAULT 0,
+		schedule_mode TEXT NOT NULL DEFAULT 'fixed',
+		pipeline TEXT,
+		workspace_id INTEGER REFERENCES workspaces(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS job_runs(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_id INTEGER REFERENCES jobs(id),
+		trigger_type TEXT NOT NULL,
+		priority INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL,
+		error TEXT,
+		queued_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		started_at DATETIME,
+		finished_at DATETIME,
+		retry_of INTEGER REFERENCES job_runs(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS stage_runs(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		run_id INTEGER REFERENCES job_runs(id),
+		stage_index INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		error TEXT,
+		output TEXT,
+		started_at DATETIME,
+		finished_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS probe_results(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		run_id INTEGER REFERENCES job_runs(id),
+		stage_run_id INTEGER REFERENCES stage_runs(id),
+		target TEXT NOT NULL,
+		port INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		tls_version TEXT,
+		tls_subject TEXT,
+		tls_issuer TEXT,
+		tls_not_after DATETIME,
+		http_status INTEGER,
+		http_server TEXT,
+		http_title TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		user_id INTEGER REFERENCES users(id),
+		workspace_id INTEGER REFERENCES workspaces(id)
 	);
 
 	CREATE TABLE IF NOT EXISTS notifications(
@@ -42,16 +97,324 @@ func InitDB() *sqlx.DB{
 		type TEXT,
 		message TEXT,
 		read BOOLEAN DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		workspace_id INTEGER REFERENCES workspaces(id),
+		severity TEXT NOT NULL DEFAULT 'info',
+		rule_id INTEGER REFERENCES alert_rules(id) ON DELETE SET NULL,
+		run_id INTEGER REFERENCES job_runs(id),
+		dedup_key TEXT
 	);
 
 	CREATE TABLE IF NOT EXISTS users(
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		username TEXT,
 		password_hash TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		role TEXT NOT NULL DEFAULT 'operator' REFERENCES roles(name),
+		default_workspace_id INTEGER REFERENCES workspaces(id),
+		totp_secret TEXT,
+		totp_enabled INTEGER NOT NULL DEFAULT 0,
+		totp_last_step INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS user_identities(
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (issuer, subject)
+	);
+
+	CREATE TABLE IF NOT EXISTS oidc_states(
+		state TEXT PRIMARY KEY,
+		nonce TEXT NOT NULL,
+		verifier TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS recovery_codes(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		code_hash TEXT NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS workspaces(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		created_by INTEGER REFERENCES users(id),
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 
+	CREATE TABLE IF NOT EXISTS workspace_members(
+		workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		role TEXT NOT NULL REFERENCES roles(name),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (workspace_id, user_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS roles(
+		name TEXT PRIMARY KEY,
+		description TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS role_permissions(
+		role TEXT NOT NULL REFERENCES roles(name),
+		permission TEXT NOT NULL,
+		PRIMARY KEY (role, permission)
+	);
+
+	CREATE TABLE IF NOT EXISTS api_keys(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		permissions TEXT NOT NULL,
+		expires_at DATETIME,
+		last_used_at DATETIME,
+		revoked_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS sessions(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME NOT NULL,
+		last_used_at DATETIME,
+		revoked_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS refresh_tokens(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id INTEGER NOT NULL REFERENCES sessions(id),
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS login_throttle(
+		key TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL DEFAULT 0,
+		window_start INTEGER NOT NULL,
+		locked_until INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_events(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		actor_id INTEGER REFERENCES users(id),
+		action TEXT NOT NULL,
+		target TEXT,
+		ip TEXT,
+		detail TEXT,
+		resource_type TEXT,
+		resource_id TEXT,
+		before_value TEXT,
+		after_value TEXT,
+		workspace_id INTEGER REFERENCES workspaces(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_audit_events_created ON audit_events(created_at);
+
+	CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+	BEGIN
+		SELECT RAISE(ABORT, 'audit_events is append-only');
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+	BEGIN
+		SELECT RAISE(ABORT, 'audit_events is append-only');
+	END;
+
+	CREATE TABLE IF NOT EXISTS target_rules(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL CHECK (kind IN ('allow', 'deny')),
+		pattern TEXT NOT NULL,
+		comment TEXT NOT NULL DEFAULT '',
+		created_by INTEGER REFERENCES users(id),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(kind, pattern)
+	);
+
+	CREATE TABLE IF NOT EXISTS target_verifications(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+		target TEXT NOT NULL,
+		method TEXT NOT NULL CHECK (method IN ('dns', 'http')),
+		token TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		last_error TEXT,
+		created_by INTEGER REFERENCES users(id),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		checked_at DATETIME,
+		verified_at DATETIME,
+		UNIQUE(workspace_id, target)
+	);
+
+	CREATE TABLE IF NOT EXISTS quotas(
+		scope TEXT NOT NULL CHECK (scope IN ('user', 'workspace')),
+		subject_id INTEGER NOT NULL,
+		ports_per_day INTEGER,
+		concurrent_scans INTEGER,
+		active_schedules INTEGER,
+		min_interval_seconds INTEGER,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (scope, subject_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS usage_daily(
+		scope TEXT NOT NULL,
+		subject_id INTEGER NOT NULL,
+		day TEXT NOT NULL,
+		ports INTEGER NOT NULL DEFAULT 0,
+		scans INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (scope, subject_id, day)
+	);
+
+	CREATE TABLE IF NOT EXISTS alert_rules(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		name TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		severity TEXT NOT NULL DEFAULT 'medium',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		ports TEXT NOT NULL DEFAULT '',
+		host_tag TEXT NOT NULL DEFAULT '',
+		within_days INTEGER NOT NULL DEFAULT 0,
+		pattern TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS host_tags(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+		host TEXT NOT NULL,
+		tag TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(workspace_id, host, tag)
+	);
+
+	CREATE TABLE IF NOT EXISTS webhooks(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		scope TEXT NOT NULL CHECK (scope IN ('user', 'workspace')),
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		event_types TEXT NOT NULL DEFAULT '',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+		notification_id INTEGER REFERENCES notifications(id),
+		event_type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		last_error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		delivered_at DATETIME
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+
+	CREATE TABLE IF NOT EXISTS webhook_attempts(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		delivery_id INTEGER NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+		attempt INTEGER NOT NULL,
+		status_code INTEGER,
+		error TEXT,
+		duration_ms INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS email_preferences(
+		user_id INTEGER PRIMARY KEY REFERENCES users(id),
+		email TEXT NOT NULL DEFAULT '',
+		immediate INTEGER NOT NULL DEFAULT 0,
+		min_severity TEXT NOT NULL DEFAULT 'high',
+		digest TEXT NOT NULL DEFAULT 'off' CHECK (digest IN ('off', 'daily', 'weekly')),
+		digest_hour INTEGER NOT NULL DEFAULT 8,
+		digest_weekday INTEGER NOT NULL DEFAULT 1,
+		last_digest_at DATETIME,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS notification_channels(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+		created_by INTEGER REFERENCES users(id),
+		name TEXT NOT NULL,
+		kind TEXT NOT NULL CHECK (kind IN ('slack', 'teams')),
+		url TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		types TEXT NOT NULL DEFAULT '',
+		rule_ids TEXT NOT NULL DEFAULT '',
+		min_severity TEXT NOT NULL DEFAULT 'info',
+		rate_limit INTEGER NOT NULL DEFAULT 20,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS channel_messages(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		channel_id INTEGER NOT NULL REFERENCES notification_channels(id) ON DELETE CASCADE,
+		notification_id INTEGER REFERENCES notifications(id),
+		status TEXT NOT NULL,
+		status_code INTEGER,
+		error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_channel_messages_recent ON channel_messages(channel_id, created_at);
+
+	CREATE TABLE IF NOT EXISTS notification_settings(
+		user_id INTEGER PRIMARY KEY REFERENCES users(id),
+		renotify_seconds INTEGER NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS notification_type_prefs(
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		type TEXT NOT NULL,
+		in_app INTEGER NOT NULL DEFAULT 1,
+		email INTEGER NOT NULL DEFAULT 1,
+		webhook INTEGER NOT NULL DEFAULT 1,
+		chat INTEGER NOT NULL DEFAULT 1,
+		PRIMARY KEY (user_id, type)
+	);
+
+	CREATE TABLE IF NOT EXISTS notification_groups(
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		dedup_key TEXT NOT NULL,
+		workspace_id INTEGER REFERENCES workspaces(id),
+		type TEXT NOT NULL,
+		last_message TEXT NOT NULL,
+		occurrences INTEGER NOT NULL DEFAULT 1,
+		suppressed INTEGER NOT NULL DEFAULT 0,
+		first_seen_at DATETIME NOT NULL,
+		last_seen_at DATETIME NOT NULL,
+		last_notified_at DATETIME,
+		snoozed_until DATETIME,
+		acknowledged_at DATETIME,
+		PRIMARY KEY (user_id, dedup_key)
+	);
+
+	CREATE TABLE IF NOT EXISTS settings(
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
 	CREATE TABLE IF NOT EXISTS cleanup_logs(
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		deleted_count INTEGER,
@@ -61,5 +424,8 @@ func InitDB() *sqlx.DB{
 	`
 
 	db.MustExec(schema)
+	if err := migrate(db); err != nil {
+		log.Fatal(err)
+	}
 	return db
 }
\ No newline at end of file
@@ -0,0 +1,20 @@
+// Package dbtest gives tests a database of their own.
+package dbtest
+
+import (
+	"testing"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/db"
+	"github.com/jmoiron/sqlx"
+)
+
+// New opens an empty database in a temporary directory and closes it when t
+// ends. InitDB opens the database in the working directory, so New changes
+// into the temporary one and its tests cannot run in parallel.
+func New(t testing.TB) *sqlx.DB {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	database := db.InitDB()
+	t.Cleanup(func() { database.Close() })
+	return database
+}
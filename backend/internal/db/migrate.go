@@ -0,0 +1,80 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// columns lists columns added to existing tables after their first release.
+// CREATE TABLE IF NOT EXISTS leaves older databases untouched, so these are
+// applied with ALTER TABLE when missing.
+var columns = []struct {
+	table string
+	name  string
+	def   string
+}{
+	{"jobs", "max_run_seconds", "INTEGER NOT NULL DEFAULT 0"},
+	{"jobs", "max_failures", "INTEGER NOT NULL DEFAULT 0"},
+	{"jobs", "consecutive_failures", "INTEGER NOT NULL DEFAULT 0"},
+	{"jobs", "jitter_seconds", "INTEGER NOT NULL DEFAULT 0"},
+	{"jobs", "schedule_mode", "TEXT NOT NULL DEFAULT 'fixed'"},
+	{"job_runs", "retry_of", "INTEGER REFERENCES job_runs(id)"},
+	{"jobs", "pipeline", "TEXT"},
+	{"scans", "run_id", "INTEGER REFERENCES job_runs(id)"},
+	{"jobs", "user_id", "INTEGER REFERENCES users(id)"},
+	{"users", "role", "TEXT NOT NULL DEFAULT 'operator'"},
+	{"users", "default_workspace_id", "INTEGER REFERENCES workspaces(id)"},
+	{"users", "totp_secret", "TEXT"},
+	{"users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"},
+	{"audit_events", "resource_type", "TEXT"},
+	{"audit_events", "resource_id", "TEXT"},
+	{"audit_events", "before_value", "TEXT"},
+	{"audit_events", "after_value", "TEXT"},
+	{"audit_events", "workspace_id", "INTEGER REFERENCES workspaces(id)"},
+	{"scans", "workspace_id", "INTEGER REFERENCES workspaces(id)"},
+	{"jobs", "workspace_id", "INTEGER REFERENCES workspaces(id)"},
+	{"notifications", "workspace_id", "INTEGER REFERENCES workspaces(id)"},
+	{"probe_results", "workspace_id", "INTEGER REFERENCES workspaces(id)"},
+	{"notifications", "severity", "TEXT NOT NULL DEFAULT 'info'"},
+	{"notifications", "rule_id", "INTEGER REFERENCES alert_rules(id) ON DELETE SET NULL"},
+	{"notifications", "run_id", "INTEGER REFERENCES job_runs(id)"},
+	{"notifications", "dedup_key", "TEXT"},
+	{"notification_type_prefs", "chat", "INTEGER NOT NULL DEFAULT 1"},
+}
+
+func migrate(db *sqlx.DB) error {
+	for _, col := range columns {
+		var count int
+		err := db.Get(&count, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, col.table, col.name)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.name, col.def)); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", col.table, col.name, err)
+		}
+	}
+	return uniqueUsernames(db)
+}
+
+// uniqueUsernames enforces case-insensitively unique usernames. Databases
+// from before the index may hold duplicates; all but the oldest account of
+// each name are renamed to name-<id> so the index can be created.
+func uniqueUsernames(db *sqlx.DB) error {
+	res, err := db.Exec(`UPDATE users SET username = username || '-' || id
+		WHERE id NOT IN (SELECT MIN(id) FROM users GROUP BY username COLLATE NOCASE)`)
+	if err != nil {
+		return fmt.Errorf("dedupe usernames: %w", err)
+	}
+	if count, _ := res.RowsAffected(); count > 0 {
+		fmt.Printf("[DB] renamed %d duplicate usernames to name-<id>\n", count)
+	}
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users(username COLLATE NOCASE)`); err != nil {
+		return fmt.Errorf("unique usernames: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SettingClosedRetentionHours is how long closed-port scan rows are kept
+// before the cleanup scheduler deletes them.
+const SettingClosedRetentionHours = "retention.closed_hours"
+
+const defaultClosedRetention = time.Hour
+
+func GetSetting(db *sqlx.DB, key string) (string, bool, error) {
+	var value string
+	err := db.Get(&value, `SELECT value FROM settings WHERE key = ?`, key)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return value, err == nil, err
+}
+
+func SetSetting(db *sqlx.DB, key, value string) error {
+	_, err := db.Exec(`INSERT INTO settings (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP`, key, value)
+	return err
+}
+
+// ClosedRetention returns the configured retention for closed-port rows,
+// falling back to one hour when unset or invalid.
+func ClosedRetention(db *sqlx.DB) time.Duration {
+	value, ok, err := GetSetting(db, SettingClosedRetentionHours)
+	if err != nil || !ok {
+		return defaultClosedRetention
+	}
+	hours, err := strconv.Atoi(value)
+	if err != nil || hours <= 0 {
+		return defaultClosedRetention
+	}
+	return time.Duration(hours) * time.Hour
+}
@@ -0,0 +1,117 @@
+// Package egress guards the requests Sentrinet makes to URLs its users
+// supply, such as webhooks and chat channels. Such a URL must not reach the
+// server's own network, so loopback, private, link-local (which includes
+// cloud metadata endpoints) and other non-public addresses are refused, once
+// when the URL is saved and again on every connection, so a name re-pointed
+// after it was checked is caught too.
+package egress
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// resolveTimeout bounds the lookup done when a URL is checked.
+const resolveTimeout = 5 * time.Second
+
+// reserved are non-public ranges the net.IP predicates do not cover: "this
+// network", carrier-grade NAT, benchmarking, reserved space and NAT64, which
+// can embed any IPv4 address.
+var reserved = func() []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, cidr := range []string{"0.0.0.0/8", "100.64.0.0/10", "198.18.0.0/15", "240.0.0.0/4", "64:ff9b::/96"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// allowPrivate reports whether SENTRINET_EGRESS_ALLOW_PRIVATE turns the guard
+// off, for deployments whose receivers live on the internal network.
+func allowPrivate() bool {
+	v, _ := strconv.ParseBool(os.Getenv("SENTRINET_EGRESS_ALLOW_PRIVATE"))
+	return v
+}
+
+// Blocked reports whether ip is not a public unicast address.
+func Blocked(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() ||
+		ip.IsMulticast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, n := range reserved {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func refused(ip net.IP) error {
+	return fmt.Errorf("destination %s is not a public address", ip)
+}
+
+// CheckURL validates a user supplied http or https URL and refuses it if its
+// host is, or resolves to, a non-public address.
+func CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if allowPrivate() {
+		return nil
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if Blocked(ip) {
+			return refused(ip)
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("cannot resolve %s", host)
+	}
+	for _, addr := range addrs {
+		if Blocked(addr.IP) {
+			return fmt.Errorf("%s resolves to %s, which is not a public address", host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// control runs after the address of a connection is resolved and before it
+// is dialled.
+func control(network, address string, _ syscall.RawConn) error {
+	if allowPrivate() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || Blocked(ip) {
+		return refused(ip)
+	}
+	return nil
+}
+
+// Transport returns an HTTP transport that refuses to connect to non-public
+// addresses. It ignores proxy settings, since a proxy would make the request
+// on Sentrinet's behalf where the check cannot see it.
+func Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: control}).DialContext
+	return t
+}
@@ -0,0 +1,172 @@
+package email
+
+import (
+	"fmt"
+	"time"
+)
+
+// PortChange is a port that opened or closed during a digest period.
+type PortChange struct {
+	Workspace string    `db:"workspace" json:"workspace"`
+	Target    string    `db:"target" json:"target"`
+	Port      int       `db:"port" json:"port"`
+	SeenAt    time.Time `db:"seen_at" json:"seen_at"`
+}
+
+// FailedRun is a scheduled run that failed during a digest period.
+type FailedRun struct {
+	Workspace  string    `db:"workspace" json:"workspace"`
+	JobID      int64     `db:"job_id" json:"job_id"`
+	RunID      int64     `db:"run_id" json:"run_id"`
+	Target     string    `db:"target" json:"target"`
+	Error      string    `db:"error" json:"error"`
+	FinishedAt time.Time `db:"finished_at" json:"finished_at"`
+	Link       string    `db:"-" json:"link,omitempty"`
+}
+
+// Digest summarizes what happened in a user's workspaces between Since and
+// Until.
+type Digest struct {
+	Period      string       `json:"period"`
+	Since       time.Time    `json:"since"`
+	Until       time.Time    `json:"until"`
+	NewPorts    []PortChange `json:"new_ports"`
+	ClosedPorts []PortChange `json:"closed_ports"`
+	FailedRuns  []FailedRun  `json:"failed_runs"`
+}
+
+// Empty reports whether nothing happened in the period.
+func (d Digest) Empty() bool {
+	return len(d.NewPorts) == 0 && len(d.ClosedPorts) == 0 && len(d.FailedRuns) == 0
+}
+
+// sqlTime formats t like CURRENT_TIMESTAMP so it compares correctly with the
+// timestamps SQLite filled in.
+func sqlTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// BuildDigest collects the port changes and failed runs of every workspace
+// userID is a member of.
+func (m *Mailer) BuildDigest(userID int64, period string, since, until time.Time) (Digest, error) {
+	d := Digest{Period: period, Since: since, Until: until,
+		NewPorts: []PortChange{}, ClosedPorts: []PortChange{}, FailedRuns: []FailedRun{}}
+	from, to := sqlTime(since), sqlTime(until)
+
+	// A port is new when no open row for it predates the period. Rows are
+	// picked by id rather than aggregated so that created_at keeps its type.
+	if err := m.DB.Select(&d.NewPorts, `SELECT w.name AS workspace, s.target, s.port, s.created_at AS seen_at
+		FROM scans s JOIN workspaces w ON w.id = s.workspace_id
+		WHERE s.id IN (SELECT MIN(q.id) FROM scans q
+			JOIN workspace_members m ON m.workspace_id = q.workspace_id AND m.user_id = ?
+			WHERE q.is_open = 1 AND q.created_at > ? AND q.created_at <= ?
+			AND NOT EXISTS (SELECT 1 FROM scans p WHERE p.workspace_id = q.workspace_id AND p.target = q.target
+				AND p.port = q.port AND p.is_open = 1 AND p.created_at <= ?)
+			GROUP BY q.workspace_id, q.target, q.port)
+		ORDER BY w.name, s.target, s.port`, userID, from, to, from); err != nil {
+		return d, err
+	}
+
+	// A port closed when a closed row follows an open one.
+	if err := m.DB.Select(&d.ClosedPorts, `SELECT w.name AS workspace, s.target, s.port, s.created_at AS seen_at
+		FROM scans s JOIN workspaces w ON w.id = s.workspace_id
+		WHERE s.id IN (SELECT MIN(q.id) FROM scans q
+			JOIN workspace_members m ON m.workspace_id = q.workspace_id AND m.user_id = ?
+			WHERE q.is_open = 0 AND q.created_at > ? AND q.created_at <= ?
+			AND (SELECT p.is_open FROM scans p WHERE p.workspace_id = q.workspace_id AND p.target = q.target
+				AND p.port = q.port AND p.id < q.id ORDER BY p.id DESC LIMIT 1) = 1
+			GROUP BY q.workspace_id, q.target, q.port)
+		ORDER BY w.name, s.target, s.port`, userID, from, to); err != nil {
+		return d, err
+	}
+
+	// These are scheduler.RunFailed and RunTimedOut; the scheduler imports
+	// this package indirectly, so the statuses are spelled out here.
+	if err := m.DB.Select(&d.FailedRuns, `SELECT w.name AS workspace, j.id AS job_id, r.id AS run_id, j.target,
+		COALESCE(r.error, '') AS error, r.finished_at
+		FROM job_runs r JOIN jobs j ON j.id = r.job_id
+		JOIN workspace_members m ON m.workspace_id = j.workspace_id AND m.user_id = ?
+		JOIN workspaces w ON w.id = j.workspace_id
+		WHERE r.status IN ('failed', 'timed_out') AND r.finished_at > ? AND r.finished_at <= ?
+		ORDER BY r.finished_at`, userID, from, to); err != nil {
+		return d, err
+	}
+	if m.Config.BaseURL != "" {
+		for i, r := range d.FailedRuns {
+			d.FailedRuns[i].Link = fmt.Sprintf("%s/schedules/%d/runs/%d/stages", m.Config.BaseURL, r.JobID, r.RunID)
+		}
+	}
+	return d, nil
+}
+
+// lastSlot returns the most recent time at or before now that p's digest was
+// scheduled for, and the length of its period.
+func lastSlot(p Preferences, now time.Time) (time.Time, time.Duration) {
+	now = now.UTC()
+	slot := time.Date(now.Year(), now.Month(), now.Day(), p.DigestHour, 0, 0, 0, time.UTC)
+	if slot.After(now) {
+		slot = slot.AddDate(0, 0, -1)
+	}
+	if p.Digest == DigestWeekly {
+		for int(slot.Weekday()) != p.DigestWeekday {
+			slot = slot.AddDate(0, 0, -1)
+		}
+		return slot, 7 * 24 * time.Hour
+	}
+	return slot, 24 * time.Hour
+}
+
+// SendDueDigests emails every digest whose slot has passed since it was last
+// sent. Empty digests are skipped but still count as sent.
+func (m *Mailer) SendDueDigests(now time.Time) error {
+	prefs := []Preferences{}
+	if err := m.DB.Select(&prefs, `SELECT * FROM email_preferences WHERE digest != ? AND email != ''`, DigestOff); err != nil {
+		return err
+	}
+	for _, p := range prefs {
+		slot, period := lastSlot(p, now)
+		if p.LastDigestAt != nil && !p.LastDigestAt.Before(slot) {
+			continue
+		}
+		since := slot.Add(-period)
+		if p.LastDigestAt != nil && p.LastDigestAt.After(since) {
+			since = *p.LastDigestAt
+		}
+		if err := m.sendDigest(p, since, slot); err != nil {
+			fmt.Printf("[Email] digest for user %d: %v\n", p.UserID, err)
+			continue
+		}
+		if _, err := m.DB.Exec(`UPDATE email_preferences SET last_digest_at = ? WHERE user_id = ?`, slot, p.UserID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Mailer) sendDigest(p Preferences, since, until time.Time) error {
+	d, err := m.BuildDigest(p.UserID, p.Digest, since, until)
+	if err != nil || d.Empty() {
+		return err
+	}
+	return m.send(p.Email, d.subject(), "digest", d)
+}
+
+func (d Digest) subject() string {
+	return fmt.Sprintf("Sentrinet %s digest: %d new open, %d closed, %d failed runs",
+		d.Period, len(d.NewPorts), len(d.ClosedPorts), len(d.FailedRuns))
+}
+
+// SendDigestNow emails the digest of the period that ends now, regardless of
+// the schedule and even when it is empty. It does not move the schedule.
+func (m *Mailer) SendDigestNow(p Preferences, now time.Time) (Digest, error) {
+	period := DigestDaily
+	length := 24 * time.Hour
+	if p.Digest == DigestWeekly {
+		period, length = DigestWeekly, 7*24*time.Hour
+	}
+	d, err := m.BuildDigest(p.UserID, period, now.Add(-length), now)
+	if err != nil {
+		return d, err
+	}
+	return d, m.send(p.Email, d.subject(), "digest", d)
+}
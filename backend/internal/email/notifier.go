@@ -0,0 +1,111 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// digestInterval is how often the digest loop looks for users whose digest
+// is due.
+const digestInterval = 5 * time.Minute
+
+// Mailer renders and sends emails.
+type Mailer struct {
+	DB     *sqlx.DB
+	Config *Config
+	Sender Sender
+}
+
+// mailer is the mailer started by Start; nil while email is disabled.
+var mailer *Mailer
+
+// Start enables email with cfg and runs the digest loop until ctx is
+// cancelled. A nil cfg leaves email disabled.
+func Start(ctx context.Context, db *sqlx.DB, cfg *Config) {
+	if cfg == nil {
+		return
+	}
+	m := &Mailer{DB: db, Config: cfg, Sender: SMTPSender{Config: cfg}}
+	mailer = m
+	fmt.Printf("[Email] sending through %s:%d\n", cfg.Host, cfg.Port)
+	go func() {
+		ticker := time.NewTicker(digestInterval)
+		defer ticker.Stop()
+		for {
+			if err := m.SendDueDigests(time.Now().UTC()); err != nil {
+				fmt.Printf("[Email] digests: %v\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Enabled reports whether an SMTP server is configured.
+func Enabled() bool {
+	return mailer != nil
+}
+
+// Default returns the running mailer, or nil while email is disabled.
+func Default() *Mailer {
+	return mailer
+}
+
+func formatDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+func (m *Mailer) send(to, subject, name string, data interface{}) error {
+	text, html, err := render(name, data)
+	if err != nil {
+		return err
+	}
+	msg, err := buildMessage(m.Config.From, to, subject, text, html)
+	if err != nil {
+		return err
+	}
+	return m.Sender.Send(m.Config.From, []string{to}, msg)
+}
+
+type alertData struct {
+	Notification models.Notification
+	Link         string
+}
+
+// SendNotification emails n to addr.
+func (m *Mailer) SendNotification(addr string, n models.Notification) error {
+	subject := fmt.Sprintf("[Sentrinet %s] %s", n.Severity, n.Message)
+	if r := []rune(subject); len(r) > 140 {
+		subject = string(r[:137]) + "..."
+	}
+	return m.send(addr, subject, "alert", alertData{Notification: n, Link: m.Config.BaseURL})
+}
+
+// Immediate emails n to its user if their preferences ask for it. The email
+// is sent in the background so that notifying never waits on the SMTP server.
+func Immediate(db *sqlx.DB, n models.Notification) {
+	m := mailer
+	if m == nil {
+		return
+	}
+	p, err := GetPreferences(db, int64(n.UserID))
+	if err != nil {
+		fmt.Printf("[Email] preferences of user %d: %v\n", n.UserID, err)
+		return
+	}
+	if !p.wantsImmediate(n) {
+		return
+	}
+	go func() {
+		if err := m.SendNotification(p.Email, n); err != nil {
+			fmt.Printf("[Email] notification %d to user %d: %v\n", n.ID, n.UserID, err)
+		}
+	}()
+}
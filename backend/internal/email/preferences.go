@@ -0,0 +1,104 @@
+package email
+
+import (
+	"database/sql"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// Digest frequencies.
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// Preferences are a user's email opt-ins. Without a row nothing is sent.
+type Preferences struct {
+	UserID int64  `db:"user_id" json:"-"`
+	Email  string `db:"email" json:"email"`
+	// Immediate sends one email per notification at or above MinSeverity.
+	Immediate   bool   `db:"immediate" json:"immediate"`
+	MinSeverity string `db:"min_severity" json:"min_severity"`
+	Digest      string `db:"digest" json:"digest"`
+	// DigestHour is the UTC hour digests go out; weekly digests go out on
+	// DigestWeekday, 0 being Sunday.
+	DigestHour    int        `db:"digest_hour" json:"digest_hour"`
+	DigestWeekday int        `db:"digest_weekday" json:"digest_weekday"`
+	LastDigestAt  *time.Time `db:"last_digest_at" json:"last_digest_at,omitempty"`
+	UpdatedAt     *time.Time `db:"updated_at" json:"updated_at,omitempty"`
+}
+
+func defaultPreferences(userID int64) Preferences {
+	return Preferences{
+		UserID: userID, MinSeverity: models.SeverityHigh, Digest: DigestOff,
+		DigestHour: 8, DigestWeekday: int(time.Monday),
+	}
+}
+
+// GetPreferences returns the preferences of userID, or the disabled defaults.
+func GetPreferences(db *sqlx.DB, userID int64) (Preferences, error) {
+	var p Preferences
+	err := db.Get(&p, `SELECT * FROM email_preferences WHERE user_id = ?`, userID)
+	if err == sql.ErrNoRows {
+		return defaultPreferences(userID), nil
+	}
+	return p, err
+}
+
+// Validate checks preferences before they are stored.
+func (p *Preferences) Validate() error {
+	if p.MinSeverity == "" {
+		p.MinSeverity = models.SeverityHigh
+	}
+	if !models.ValidSeverity(p.MinSeverity) {
+		return fmt.Errorf("min_severity must be a notification severity")
+	}
+	if p.Digest == "" {
+		p.Digest = DigestOff
+	}
+	if p.Digest != DigestOff && p.Digest != DigestDaily && p.Digest != DigestWeekly {
+		return fmt.Errorf("digest must be %q, %q or %q", DigestOff, DigestDaily, DigestWeekly)
+	}
+	if p.DigestHour < 0 || p.DigestHour > 23 {
+		return fmt.Errorf("digest_hour must be between 0 and 23")
+	}
+	if p.DigestWeekday < 0 || p.DigestWeekday > 6 {
+		return fmt.Errorf("digest_weekday must be between 0 (Sunday) and 6")
+	}
+	if p.Email != "" {
+		addr, err := mail.ParseAddress(p.Email)
+		if err != nil {
+			return fmt.Errorf("email is not a valid address")
+		}
+		p.Email = addr.Address
+	}
+	if p.Email == "" && (p.Immediate || p.Digest != DigestOff) {
+		return fmt.Errorf("email is required to enable email notifications")
+	}
+	return nil
+}
+
+// SetPreferences stores the preferences of p.UserID.
+func SetPreferences(db *sqlx.DB, p Preferences) (Preferences, error) {
+	if err := p.Validate(); err != nil {
+		return p, err
+	}
+	if _, err := db.NamedExec(`INSERT INTO email_preferences (user_id, email, immediate, min_severity, digest,
+		digest_hour, digest_weekday) VALUES (:user_id, :email, :immediate, :min_severity, :digest, :digest_hour,
+		:digest_weekday) ON CONFLICT(user_id) DO UPDATE SET email = excluded.email, immediate = excluded.immediate,
+		min_severity = excluded.min_severity, digest = excluded.digest, digest_hour = excluded.digest_hour,
+		digest_weekday = excluded.digest_weekday, updated_at = CURRENT_TIMESTAMP`, p); err != nil {
+		return p, err
+	}
+	return GetPreferences(db, p.UserID)
+}
+
+// wantsImmediate reports whether n is severe enough for an immediate email.
+func (p Preferences) wantsImmediate(n models.Notification) bool {
+	return p.Immediate && p.Email != "" && models.SeverityRank(n.Severity) >= models.SeverityRank(p.MinSeverity)
+}
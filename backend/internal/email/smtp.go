@@ -0,0 +1,194 @@
+// Package email sends notifications by SMTP: an immediate email for every
+// notification at or above a user's severity threshold, and a daily or weekly
+// digest of new open ports, closed ports and failed scheduled runs. Users opt
+// in through their email preferences; nothing is sent without them.
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TLS modes of the SMTP connection.
+const (
+	// TLSStartTLS upgrades the connection with STARTTLS and refuses to send
+	// if the server does not offer it.
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects with TLS from the start, usually on port 465.
+	TLSImplicit = "tls"
+	// TLSNone never encrypts, for local sinks such as MailHog or Mailpit.
+	TLSNone = "none"
+)
+
+const smtpTimeout = 30 * time.Second
+
+// Config is the SMTP server mail is sent through.
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	TLS      string
+	// BaseURL is the address of the dashboard, used for links in emails.
+	BaseURL string
+}
+
+// ConfigFromEnv reads SENTRINET_SMTP_HOST, _PORT, _USERNAME, _PASSWORD, _FROM
+// and _TLS, and SENTRINET_PUBLIC_URL for links. It returns nil when no host is
+// configured, which leaves email disabled.
+func ConfigFromEnv() *Config {
+	host := os.Getenv("SENTRINET_SMTP_HOST")
+	if host == "" {
+		return nil
+	}
+	cfg := &Config{
+		Host:     host,
+		Port:     587,
+		Username: os.Getenv("SENTRINET_SMTP_USERNAME"),
+		Password: os.Getenv("SENTRINET_SMTP_PASSWORD"),
+		From:     os.Getenv("SENTRINET_SMTP_FROM"),
+		TLS:      TLSStartTLS,
+		BaseURL:  strings.TrimSuffix(os.Getenv("SENTRINET_PUBLIC_URL"), "/"),
+	}
+	if p, err := strconv.Atoi(os.Getenv("SENTRINET_SMTP_PORT")); err == nil && p > 0 {
+		cfg.Port = p
+	}
+	if t := os.Getenv("SENTRINET_SMTP_TLS"); t != "" {
+		cfg.TLS = t
+	}
+	if cfg.From == "" {
+		cfg.From = "sentrinet@" + host
+	}
+	return cfg
+}
+
+// Sender delivers a complete RFC 5322 message.
+type Sender interface {
+	Send(from string, to []string, msg []byte) error
+}
+
+// SMTPSender sends through the server described by Config.
+type SMTPSender struct {
+	Config *Config
+}
+
+func (s SMTPSender) Send(from string, to []string, msg []byte) error {
+	cfg := s.Config
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+
+	var conn net.Conn
+	var err error
+	switch cfg.TLS {
+	case TLSImplicit:
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: cfg.Host})
+	case TLSStartTLS, TLSNone:
+		conn, err = dialer.Dial("tcp", addr)
+	default:
+		return fmt.Errorf("unknown SMTP TLS mode %q, want %q, %q or %q", cfg.TLS, TLSStartTLS, TLSImplicit, TLSNone)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	// Without STARTTLS the message would go out in plain text, which is
+	// also what an attacker stripping the extension from the greeting wants.
+	if cfg.TLS == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not offer STARTTLS; set SENTRINET_SMTP_TLS=none to send unencrypted", addr)
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildMessage assembles a multipart/alternative message with a plain-text
+// and an HTML body.
+func buildMessage(from, to, subject, text, html string) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(pw)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 12)
+	rand.Read(id)
+	domain := from[strings.LastIndex(from, "@")+1:]
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,219 @@
+package email
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/models"
+)
+
+// received is one message accepted by the sink.
+type received struct {
+	from string
+	to   []string
+	data string
+}
+
+// sink is a minimal SMTP server on a local listener. It never offers
+// STARTTLS, like a server whose greeting was stripped by an attacker.
+type sink struct {
+	ln       net.Listener
+	messages chan received
+}
+
+func newSink(t *testing.T) *sink {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sink{ln: ln, messages: make(chan received, 4)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *sink) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 sink ESMTP")
+	var msg received
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case verb == "EHLO" || verb == "HELO":
+			reply("250-sink")
+			reply("250 8BITMIME")
+		case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+			msg.from = path(line)
+			reply("250 ok")
+		case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+			msg.to = append(msg.to, path(line))
+			reply("250 ok")
+		case verb == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msg.data = data.String()
+			s.messages <- msg
+			msg = received{}
+			reply("250 queued")
+		case verb == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// path returns the address between the angle brackets of a MAIL or RCPT
+// command, ignoring any parameters after it.
+func path(line string) string {
+	start, end := strings.IndexByte(line, '<'), strings.IndexByte(line, '>')
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+func (s *sink) config(tlsMode string) *Config {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return &Config{
+		Host: "127.0.0.1", Port: addr.Port, From: "sentrinet@example.test", TLS: tlsMode,
+		BaseURL: "https://sentrinet.example.test",
+	}
+}
+
+func TestSendNotification(t *testing.T) {
+	s := newSink(t)
+	cfg := s.config(TLSNone)
+	m := &Mailer{Config: cfg, Sender: SMTPSender{Config: cfg}}
+
+	n := models.Notification{
+		ID: 7, UserID: 1, WorkspaceID: 3, Type: "alert", Severity: models.SeverityCritical,
+		Message: "web port: port 8099 open on 10.0.0.5 – ünïcode", CreatedAt: time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC),
+	}
+	if err := m.SendNotification("bob@example.test", n); err != nil {
+		t.Fatal(err)
+	}
+
+	var got received
+	select {
+	case got = <-s.messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the sink received nothing")
+	}
+	if got.from != cfg.From || len(got.to) != 1 || got.to[0] != "bob@example.test" {
+		t.Fatalf("envelope from %q to %v", got.from, got.to)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[Sentrinet critical] " + n.Message; subject != want {
+		t.Errorf("Subject = %q, want %q", subject, want)
+	}
+	for header, want := range map[string]string{"From": cfg.From, "To": "bob@example.test", "MIME-Version": "1.0"} {
+		if v := msg.Header.Get(header); v != want {
+			t.Errorf("%s = %q, want %q", header, v, want)
+		}
+	}
+	if _, err := msg.Header.Date(); err != nil {
+		t.Errorf("Date: %v", err)
+	}
+	if id := msg.Header.Get("Message-ID"); !strings.HasSuffix(id, "@example.test>") {
+		t.Errorf("Message-ID = %q", id)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q (%v)", msg.Header.Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	parts := map[string]string{}
+	order := []string{}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The reader decodes quoted-printable parts itself.
+		body, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[ct] = string(body)
+		order = append(order, ct)
+	}
+	if strings.Join(order, ",") != "text/plain,text/html" {
+		t.Fatalf("parts %v, want plain text before HTML", order)
+	}
+	for _, want := range []string{"CRITICAL: " + n.Message, "Type:      alert", "Raised at: 2026-01-02 03:04 UTC", cfg.BaseURL} {
+		if !strings.Contains(parts["text/plain"], want) {
+			t.Errorf("text part lacks %q:\n%s", want, parts["text/plain"])
+		}
+	}
+	for _, want := range []string{"<strong", n.Message, `href="` + cfg.BaseURL + `"`} {
+		if !strings.Contains(parts["text/html"], want) {
+			t.Errorf("HTML part lacks %q:\n%s", want, parts["text/html"])
+		}
+	}
+}
+
+func TestStartTLSRequired(t *testing.T) {
+	s := newSink(t)
+	cfg := s.config(TLSStartTLS)
+	err := SMTPSender{Config: cfg}.Send(cfg.From, []string{"bob@example.test"}, []byte("Subject: secret\r\n\r\nsecret\r\n"))
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("send without STARTTLS: %v", err)
+	}
+	select {
+	case <-s.messages:
+		t.Fatal("the message was sent in plain text")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	cfg.TLS = "STARTTLS "
+	if err := (SMTPSender{Config: cfg}).Send(cfg.From, []string{"bob@example.test"}, nil); err == nil {
+		t.Fatal("unknown TLS mode was accepted")
+	}
+}
@@ -0,0 +1,129 @@
+package email
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+var funcs = map[string]interface{}{
+	"upper": strings.ToUpper,
+	"date":  formatDate,
+}
+
+const alertText = `{{upper .Notification.Severity}}: {{.Notification.Message}}
+
+Type:      {{.Notification.Type}}
+Workspace: {{.Notification.WorkspaceID}}
+Raised at: {{date .Notification.CreatedAt}}
+{{- if .Link}}
+
+Open Sentrinet: {{.Link}}
+{{- end}}
+
+You receive this email because immediate notifications are enabled in your
+Sentrinet email preferences.
+`
+
+const alertHTML = `<!DOCTYPE html>
+<html><body style="font-family: sans-serif; color: #1f2933;">
+<p><strong style="color: {{color .Notification.Severity}};">{{upper .Notification.Severity}}</strong>: {{.Notification.Message}}</p>
+<table cellpadding="4" style="border-collapse: collapse;">
+<tr><td>Type</td><td>{{.Notification.Type}}</td></tr>
+<tr><td>Workspace</td><td>{{.Notification.WorkspaceID}}</td></tr>
+<tr><td>Raised at</td><td>{{date .Notification.CreatedAt}}</td></tr>
+</table>
+{{if .Link}}<p><a href="{{.Link}}">Open Sentrinet</a></p>{{end}}
+<p style="color: #7b8794; font-size: 12px;">You receive this email because immediate notifications are enabled in your Sentrinet email preferences.</p>
+</body></html>
+`
+
+const digestText = `Sentrinet {{.Period}} digest, {{date .Since}} to {{date .Until}}
+{{if .NewPorts}}
+New open ports ({{len .NewPorts}}):
+{{- range .NewPorts}}
+  {{.Target}}:{{.Port}}  [{{.Workspace}}]  first seen {{date .SeenAt}}
+{{- end}}
+{{end}}
+{{- if .ClosedPorts}}
+Ports that closed ({{len .ClosedPorts}}):
+{{- range .ClosedPorts}}
+  {{.Target}}:{{.Port}}  [{{.Workspace}}]  closed {{date .SeenAt}}
+{{- end}}
+{{end}}
+{{- if .FailedRuns}}
+Failed scheduled runs ({{len .FailedRuns}}):
+{{- range .FailedRuns}}
+  schedule {{.JobID}} ({{.Target}}) run {{.RunID}}  [{{.Workspace}}]  {{date .FinishedAt}}: {{.Error}}
+{{- if .Link}}
+    {{.Link}}
+{{- end}}
+{{- end}}
+{{end}}
+You receive this digest because it is enabled in your Sentrinet email
+preferences.
+`
+
+const digestHTML = `<!DOCTYPE html>
+<html><body style="font-family: sans-serif; color: #1f2933;">
+<h2>Sentrinet {{.Period}} digest</h2>
+<p>{{date .Since}} to {{date .Until}}</p>
+{{if .NewPorts}}
+<h3>New open ports ({{len .NewPorts}})</h3>
+<table cellpadding="4" border="1" style="border-collapse: collapse;">
+<tr><th>Target</th><th>Port</th><th>Workspace</th><th>First seen</th></tr>
+{{range .NewPorts}}<tr><td>{{.Target}}</td><td>{{.Port}}</td><td>{{.Workspace}}</td><td>{{date .SeenAt}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .ClosedPorts}}
+<h3>Ports that closed ({{len .ClosedPorts}})</h3>
+<table cellpadding="4" border="1" style="border-collapse: collapse;">
+<tr><th>Target</th><th>Port</th><th>Workspace</th><th>Closed</th></tr>
+{{range .ClosedPorts}}<tr><td>{{.Target}}</td><td>{{.Port}}</td><td>{{.Workspace}}</td><td>{{date .SeenAt}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .FailedRuns}}
+<h3>Failed scheduled runs ({{len .FailedRuns}})</h3>
+<table cellpadding="4" border="1" style="border-collapse: collapse;">
+<tr><th>Schedule</th><th>Run</th><th>Workspace</th><th>Finished</th><th>Error</th></tr>
+{{range .FailedRuns}}<tr><td>{{.JobID}} ({{.Target}})</td><td>{{if .Link}}<a href="{{.Link}}">{{.RunID}}</a>{{else}}{{.RunID}}{{end}}</td><td>{{.Workspace}}</td><td>{{date .FinishedAt}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{end}}
+<p style="color: #7b8794; font-size: 12px;">You receive this digest because it is enabled in your Sentrinet email preferences.</p>
+</body></html>
+`
+
+var (
+	textTemplates = texttemplate.Must(texttemplate.New("alert").Funcs(funcs).Parse(alertText))
+	htmlTemplates = htmltemplate.Must(htmltemplate.New("alert").Funcs(funcs).Funcs(htmltemplate.FuncMap{
+		"color": severityColor,
+	}).Parse(alertHTML))
+)
+
+func init() {
+	texttemplate.Must(textTemplates.New("digest").Parse(digestText))
+	htmltemplate.Must(htmlTemplates.New("digest").Parse(digestHTML))
+}
+
+func severityColor(severity string) string {
+	switch severity {
+	case "critical", "high":
+		return "#c62828"
+	case "medium":
+		return "#ef6c00"
+	}
+	return "#1f2933"
+}
+
+// render executes the text and HTML templates called name.
+func render(name string, data interface{}) (string, string, error) {
+	var text, html bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&text, name, data); err != nil {
+		return "", "", err
+	}
+	if err := htmlTemplates.ExecuteTemplate(&html, name, data); err != nil {
+		return "", "", err
+	}
+	return text.String(), html.String(), nil
+}
@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"strconv"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jmoiron/sqlx"
-	"strconv"
 )
 
 type JobRow struct {
@@ -15,6 +17,14 @@ type JobRow struct {
 	Active          int    `db:"active" json:"active"`
 	CreatedAt       string `db:"created_at" json:"created_at"`
 	UserID          int64  `db:"user_id" json:"user_id"`
+
+	MaxRunSeconds       int     `db:"max_run_seconds" json:"max_run_seconds"`
+	MaxFailures         int     `db:"max_failures" json:"max_failures"`
+	ConsecutiveFailures int     `db:"consecutive_failures" json:"consecutive_failures"`
+	JitterSeconds       int     `db:"jitter_seconds" json:"jitter_seconds"`
+	ScheduleMode        string  `db:"schedule_mode" json:"schedule_mode"`
+	Pipeline            *string `db:"pipeline" json:"pipeline,omitempty"`
+	WorkspaceID         int64   `db:"workspace_id" json:"workspace_id"`
 }
 
 func GetJobsHandler(db *sqlx.DB) fiber.Handler {
@@ -32,8 +42,15 @@ func GetJobsHandler(db *sqlx.DB) fiber.Handler {
 		}
 
 		var jobs []JobRow
-		query := `SELECT * FROM jobs ORDER BY created_at DESC LIMIT ? OFFSET ?`
-		if err := db.Select(&jobs, query, limit, offset); err != nil {
+		query := `SELECT * FROM jobs`
+		args := []interface{}{}
+		if ws := auth.WorkspaceFilter(c); ws != 0 {
+			query += ` WHERE workspace_id = ?`
+			args = append(args, ws)
+		}
+		query += ` ORDER BY created_at DESC LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+		if err := db.Select(&jobs, query, args...); err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
 
@@ -1,41 +1,289 @@
 package handlers
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
+	"github.com/KuberTheGreat/Sentrinet/internal/chatops"
+	"github.com/KuberTheGreat/Sentrinet/internal/email"
 	"github.com/KuberTheGreat/Sentrinet/internal/models"
+	"github.com/KuberTheGreat/Sentrinet/internal/prefs"
+	"github.com/KuberTheGreat/Sentrinet/internal/realtime"
+	"github.com/KuberTheGreat/Sentrinet/internal/webhooks"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jmoiron/sqlx"
 )
 
-func CreateNotification(db *sqlx.DB, userID int, scanID int, notifType, msg string) error{
-	_, err := db.Exec(
-		`INSERT INTO notifications (user_id, scan_id, type, message)
-		VALUES (?, ?, ?, ?)`,
-		userID, scanID, notifType, msg)
+// live pushes new notifications to their user's open connections; see
+// UseRealtime.
+var live *realtime.Manager
+
+// UseRealtime makes Notify push every stored notification to its user over
+// m.
+func UseRealtime(m *realtime.Manager){
+	live = m
+}
 
+func CreateNotification(db *sqlx.DB, userID int, workspaceID int64, scanID int, notifType, msg string) error{
+	_, err := Notify(db, models.Notification{
+		UserID: userID, WorkspaceID: workspaceID, ScanID: scanID, Type: notifType, Message: msg,
+	})
 	return err
 }
 
+// Notify delivers n as the user's preferences allow and returns its ID, or 0
+// when it was suppressed or is not kept in the app. Severity defaults to info.
+// A delivered notification is queued for every webhook subscribed to it,
+// emailed to its user if they asked for immediate emails and, unless the user
+// turned chat off for its type, posted to the chat channels of its workspace
+// that route it. A stored notification is also pushed to its user's realtime
+// connections.
+func Notify(db *sqlx.DB, n models.Notification) (int64, error){
+	if n.Severity == ""{
+		n.Severity = models.SeverityInfo
+	}
+	d, err := prefs.Admit(db, n)
+	if err != nil{
+		// Preferences must not cost a notification; deliver everywhere.
+		fmt.Printf("[Notify] preferences of user %d: %v\n", n.UserID, err)
+		d = prefs.Decision{Store: true, Email: true, Webhook: true, Chat: true}
+	}
+	if d.Suppressed{
+		return 0, nil
+	}
+
+	var id int64
+	if d.Store{
+		res, err := db.Exec(
+			`INSERT INTO notifications (user_id, workspace_id, scan_id, type, message, severity, rule_id, run_id, dedup_key)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			n.UserID, n.WorkspaceID, n.ScanID, n.Type, n.Message, n.Severity, n.RuleID, n.RunID, n.DedupKey)
+		if err != nil{
+			return 0, err
+		}
+		if id, err = res.LastInsertId(); err != nil{
+			return 0, err
+		}
+		if err := db.Get(&n, "SELECT * FROM notifications WHERE id = ?", id); err != nil{
+			return id, err
+		}
+		if live != nil{
+			live.SendToUser(int64(n.UserID), "notification", n)
+		}
+	} else{
+		n.CreatedAt = time.Now().UTC()
+	}
+
+	if d.Webhook{
+		webhooks.Enqueue(db, n)
+	}
+	if d.Email{
+		email.Immediate(db, n)
+	}
+	if d.Chat{
+		chatops.Route(db, n)
+	}
+	return id, nil
+}
+
+// notificationFilter scopes a query to the caller's notifications in the
+// active workspace, narrowed by ?type= and ?severity= (comma separated) and
+// ?read=true|false.
+func notificationFilter(c *fiber.Ctx) (string, []interface{}, error) {
+	where := "user_id = ?"
+	args := []interface{}{auth.Caller(c).UserID}
+	if ws := auth.WorkspaceFilter(c); ws != 0 {
+		where += " AND workspace_id = ?"
+		args = append(args, ws)
+	}
+	if types := splitQuery(c.Query("type")); len(types) > 0 {
+		q, a, err := sqlx.In(" AND type IN (?)", types)
+		if err != nil {
+			return "", nil, err
+		}
+		where += q
+		args = append(args, a...)
+	}
+	if severities := splitQuery(c.Query("severity")); len(severities) > 0 {
+		for _, s := range severities {
+			if !models.ValidSeverity(s) {
+				return "", nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unknown severity %q", s))
+			}
+		}
+		q, a, err := sqlx.In(" AND severity IN (?)", severities)
+		if err != nil {
+			return "", nil, err
+		}
+		where += q
+		args = append(args, a...)
+	}
+	if read := c.Query("read"); read != "" {
+		b, err := strconv.ParseBool(read)
+		if err != nil {
+			return "", nil, fiber.NewError(fiber.StatusBadRequest, "read must be true or false")
+		}
+		where += " AND read = ?"
+		args = append(args, b)
+	}
+	return where, args, nil
+}
+
+func splitQuery(v string) []string {
+	list := []string{}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// GetUserNotifications lists the caller's notifications newest first. Pages
+// are cut by ID: pass the next_cursor of one page as ?cursor= to get the next.
 func GetUserNotifications(db *sqlx.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		userID := c.Params("userId")
+		limit := c.QueryInt("limit", 50)
+		if limit <= 0 || limit > 200 {
+			limit = 50
+		}
+		where, args, err := notificationFilter(c)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		if cursor := c.Query("cursor"); cursor != "" {
+			id, err := strconv.ParseInt(cursor, 10, 64)
+			if err != nil || id <= 0 {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid cursor"})
+			}
+			where += " AND id < ?"
+			args = append(args, id)
+		}
+
+		// One row more than asked tells whether another page follows.
 		notifs := []models.Notification{}
+		err = db.Select(&notifs, "SELECT * FROM notifications WHERE "+where+" ORDER BY id DESC LIMIT ?", append(args, limit+1)...)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		var next interface{}
+		if len(notifs) > limit {
+			notifs = notifs[:limit]
+			next = strconv.Itoa(notifs[limit-1].ID)
+		}
 
-		err := db.Select(&notifs, "SELECT * FROM notifications WHERE user_id = ? ORDER BY created_at DESC", userID)
+		return c.JSON(fiber.Map{
+			"limit": limit,
+			"next_cursor": next,
+			"data": notifs,
+		})
+	}
+}
+
+// UnreadNotificationCount counts the caller's unread notifications, in total
+// and by severity. It takes the same filters as GetUserNotifications.
+func UnreadNotificationCount(db *sqlx.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		where, args, err := notificationFilter(c)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		var rows []struct {
+			Severity string `db:"severity"`
+			Count    int    `db:"count"`
+		}
+		err = db.Select(&rows, "SELECT severity, COUNT(*) AS count FROM notifications WHERE "+where+" AND read = 0 GROUP BY severity", args...)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
+		total := 0
+		bySeverity := map[string]int{}
+		for _, s := range models.Severities {
+			bySeverity[s] = 0
+		}
+		for _, r := range rows {
+			total += r.Count
+			bySeverity[r.Severity] += r.Count
+		}
+		return c.JSON(fiber.Map{"unread": total, "by_severity": bySeverity})
+	}
+}
 
-		return c.JSON(notifs)
+// MarkAllNotificationsRead marks every notification matching the filters as
+// read and returns how many changed.
+func MarkAllNotificationsRead(db *sqlx.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		where, args, err := notificationFilter(c)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		res, err := db.Exec("UPDATE notifications SET read = 1 WHERE "+where+" AND read = 0", args...)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		count, _ := res.RowsAffected()
+		return c.JSON(fiber.Map{"updated": count})
 	}
 }
 
+// DeleteNotifications deletes the caller's notifications listed in ids, or,
+// with "read": true, every read notification matching the filters. IDs that
+// are not the caller's are ignored.
+func DeleteNotifications(db *sqlx.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			IDs  []int64 `json:"ids"`
+			Read bool    `json:"read"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if len(req.IDs) == 0 && !req.Read {
+			return c.Status(400).JSON(fiber.Map{"error": "ids or read is required"})
+		}
+		if len(req.IDs) > 500 {
+			return c.Status(400).JSON(fiber.Map{"error": "at most 500 ids per request"})
+		}
+
+		where, args, err := notificationFilter(c)
+		if err != nil {
+			return auth.JSONError(c, err)
+		}
+		if req.Read {
+			where += " AND read = 1"
+		}
+		if len(req.IDs) > 0 {
+			q, a, err := sqlx.In(" AND id IN (?)", req.IDs)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			where += q
+			args = append(args, a...)
+		}
+		res, err := db.Exec("DELETE FROM notifications WHERE "+where, args...)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		count, _ := res.RowsAffected()
+		return c.JSON(fiber.Map{"deleted": count})
+	}
+}
+
+// MarkNotificationRead marks one of the caller's notifications read. Like the
+// rest of this API it has no admin override: other users' notifications are
+// not found.
 func MarkNotificationRead(db *sqlx.DB) fiber.Handler{
 	return func(c *fiber.Ctx) error {
-		id := c.Params("id")
-		_, err := db.Exec("UPDATE notifications SET read = 1 WHERE id = ?", id)
+		res, err := db.Exec("UPDATE notifications SET read = 1 WHERE id = ? AND user_id = ?",
+			c.Params("id"), auth.Caller(c).UserID)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
+		if count, _ := res.RowsAffected(); count == 0 {
+			return c.Status(404).JSON(fiber.Map{"error": "notification not found"})
+		}
 
 		return c.SendStatus(fiber.StatusOK)
 	}
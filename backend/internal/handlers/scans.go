@@ -3,6 +3,7 @@ package handlers
 import (
 	"strconv"
 
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jmoiron/sqlx"
 )
@@ -30,11 +31,20 @@ func GetScansHandler(db *sqlx.DB) fiber.Handler{
 			DurationMs int64  `db:"duration_ms" json:"duration_ms"`
 			CreatedAt  string `db:"created_at" json:"created_at"`
 			UserId int64 `db:"user_id" json:"user_id"`
+			RunID *int64 `db:"run_id" json:"run_id,omitempty"`
+			WorkspaceID int64 `db:"workspace_id" json:"workspace_id"`
 		}
 
-		query := `SELECT * FROM scans ORDER BY created_at DESC LIMIT ? OFFSET ?;`
+		query := `SELECT * FROM scans`
+		args := []interface{}{}
+		if ws := auth.WorkspaceFilter(c); ws != 0{
+			query += ` WHERE workspace_id = ?`
+			args = append(args, ws)
+		}
+		query += ` ORDER BY created_at DESC LIMIT ? OFFSET ?;`
+		args = append(args, limit, offset)
 
-		if err := db.Select(&scans, query, limit, offset); err != nil{
+		if err := db.Select(&scans, query, args...); err != nil{
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
 
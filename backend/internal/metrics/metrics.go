@@ -34,6 +34,22 @@ var (
 		Name: "sentrinet_cleanup_deleted_ports_total",
 		Help: "Total number of closed ports deleted by cleanup jobs",
 	})
+
+	SchedulerQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sentrinet_scheduler_queue_depth",
+		Help: "Number of scheduled runs waiting for a free worker",
+	})
+
+	SchedulerRunningJobs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sentrinet_scheduler_running_jobs",
+		Help: "Number of scheduled runs currently executing",
+	})
+
+	SchedulerQueueWaitSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "sentrinet_scheduler_queue_wait_seconds",
+		Help: "Histogram of time scheduled runs spend waiting in the queue",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 16),
+	})
 )
 
 func Register(reg prometheus.Registerer){
@@ -43,5 +59,8 @@ func Register(reg prometheus.Registerer){
 		reg.MustRegister(ClosedPorts)
 		reg.MustRegister(ScanDurationMs)
 		reg.MustRegister(CleanupDeleted)
+		reg.MustRegister(SchedulerQueueDepth)
+		reg.MustRegister(SchedulerRunningJobs)
+		reg.MustRegister(SchedulerQueueWaitSeconds)
 	})
 }
\ No newline at end of file
@@ -2,6 +2,33 @@ package models
 
 import "time"
 
+// Severities of notifications and alert rules, from least to most urgent.
+const (
+    SeverityInfo     = "info"
+    SeverityLow      = "low"
+    SeverityMedium   = "medium"
+    SeverityHigh     = "high"
+    SeverityCritical = "critical"
+)
+
+var Severities = []string{SeverityInfo, SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical}
+
+// SeverityRank returns the position of severity in Severities, or -1 if it is
+// not a severity.
+func SeverityRank(severity string) int {
+    for i, s := range Severities {
+        if s == severity {
+            return i
+        }
+    }
+    return -1
+}
+
+// ValidSeverity reports whether severity is one of Severities.
+func ValidSeverity(severity string) bool {
+    return SeverityRank(severity) >= 0
+}
+
 type Notification struct {
     ID        int       `db:"id" json:"id"`
     UserID    int       `db:"user_id" json:"user_id"`
@@ -10,4 +37,11 @@ type Notification struct {
     Message   string    `db:"message" json:"message"`
     Read      bool      `db:"read" json:"read"`
     CreatedAt time.Time `db:"created_at" json:"created_at"`
+    WorkspaceID int64   `db:"workspace_id" json:"workspace_id"`
+    Severity  string    `db:"severity" json:"severity"`
+    RuleID    *int64    `db:"rule_id" json:"rule_id,omitempty"`
+    RunID     *int64    `db:"run_id" json:"run_id,omitempty"`
+    // DedupKey groups repeats of the same finding, e.g. one rule firing on
+    // one host and port. Without one the notification is never deduplicated.
+    DedupKey  *string   `db:"dedup_key" json:"dedup_key,omitempty"`
 }
@@ -10,4 +10,6 @@ type ScanResult struct {
 	Duration int64 `db:"duration_ms" json:"duration_ms"`
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
 	UserId int64 `db:"user_id" json:"user_id"`
+	RunID *int64 `db:"run_id" json:"run_id,omitempty"`
+	WorkspaceID int64 `db:"workspace_id" json:"workspace_id"`
 }
\ No newline at end of file
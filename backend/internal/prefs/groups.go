@@ -0,0 +1,225 @@
+package prefs
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// Reasons a notification is suppressed.
+const (
+	ReasonMuted        = "muted"
+	ReasonDuplicate    = "duplicate"
+	ReasonSnoozed      = "snoozed"
+	ReasonAcknowledged = "acknowledged"
+)
+
+// Group is the state of one dedup key of a user: how often it fired, when it
+// was last notified and whether the user snoozed or acknowledged it.
+type Group struct {
+	UserID         int64      `db:"user_id" json:"-"`
+	Key            string     `db:"dedup_key" json:"key"`
+	WorkspaceID    *int64     `db:"workspace_id" json:"workspace_id,omitempty"`
+	Type           string     `db:"type" json:"type"`
+	LastMessage    string     `db:"last_message" json:"last_message"`
+	Occurrences    int        `db:"occurrences" json:"occurrences"`
+	Suppressed     int        `db:"suppressed" json:"suppressed"`
+	FirstSeenAt    time.Time  `db:"first_seen_at" json:"first_seen_at"`
+	LastSeenAt     time.Time  `db:"last_seen_at" json:"last_seen_at"`
+	LastNotifiedAt *time.Time `db:"last_notified_at" json:"last_notified_at,omitempty"`
+	SnoozedUntil   *time.Time `db:"snoozed_until" json:"snoozed_until,omitempty"`
+	AcknowledgedAt *time.Time `db:"acknowledged_at" json:"acknowledged_at,omitempty"`
+}
+
+// Decision says where a notification goes. Suppressed notifications go
+// nowhere and Reason tells why.
+type Decision struct {
+	Store      bool
+	Email      bool
+	Webhook    bool
+	Chat       bool
+	Suppressed bool
+	Reason     string
+}
+
+// admitting serializes Admit. Its transaction reads a group before writing
+// it, and SQLite cannot upgrade two such transactions at once.
+var admitting sync.Mutex
+
+func suppress(reason string) Decision {
+	return Decision{Suppressed: true, Reason: reason}
+}
+
+// Admit decides what happens to n before it is stored. A type the user muted
+// on every channel is dropped. A notification with a dedup key is dropped
+// while its group is snoozed or acknowledged, or was notified less than the
+// re-notify interval ago. A group that stayed quiet for longer than the
+// interval is taken to have cleared, which also ends its acknowledgement, so
+// a finding that comes back is notified again.
+func Admit(db *sqlx.DB, n models.Notification) (Decision, error) {
+	userID := int64(n.UserID)
+	t, err := typePrefs(db, userID, n.Type)
+	if err != nil {
+		return Decision{}, err
+	}
+	if t.muted() {
+		return suppress(ReasonMuted), nil
+	}
+	d := Decision{Store: t.InApp, Email: t.Email, Webhook: t.Webhook, Chat: t.Chat}
+	if n.DedupKey == nil || *n.DedupKey == "" {
+		return d, nil
+	}
+
+	admitting.Lock()
+	defer admitting.Unlock()
+	tx, err := db.Beginx()
+	if err != nil {
+		return Decision{}, err
+	}
+	defer tx.Rollback()
+
+	interval, err := renotify(tx, userID)
+	if err != nil {
+		return Decision{}, err
+	}
+	now := time.Now().UTC()
+	var g Group
+	err = tx.Get(&g, `SELECT * FROM notification_groups WHERE user_id = ? AND dedup_key = ?`, userID, *n.DedupKey)
+	if err == sql.ErrNoRows {
+		_, err = tx.Exec(`INSERT INTO notification_groups (user_id, dedup_key, workspace_id, type, last_message,
+			first_seen_at, last_seen_at, last_notified_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID, *n.DedupKey, nullable(n.WorkspaceID), n.Type, n.Message, now, now, now)
+		if err != nil {
+			return Decision{}, err
+		}
+		return d, tx.Commit()
+	}
+	if err != nil {
+		return Decision{}, err
+	}
+
+	acknowledged := g.AcknowledgedAt != nil && now.Sub(g.LastSeenAt) <= interval
+	switch {
+	case g.SnoozedUntil != nil && now.Before(*g.SnoozedUntil):
+		d = suppress(ReasonSnoozed)
+	case acknowledged:
+		d = suppress(ReasonAcknowledged)
+	case g.LastNotifiedAt != nil && now.Sub(*g.LastNotifiedAt) < interval:
+		d = suppress(ReasonDuplicate)
+	}
+
+	var notifiedAt interface{} = g.LastNotifiedAt
+	var ackAt interface{} = g.AcknowledgedAt
+	suppressed := g.Suppressed
+	if d.Suppressed {
+		suppressed++
+	} else {
+		notifiedAt = now
+	}
+	if !acknowledged {
+		ackAt = nil
+	}
+	if _, err := tx.Exec(`UPDATE notification_groups SET type = ?, last_message = ?, occurrences = occurrences + 1,
+		suppressed = ?, last_seen_at = ?, last_notified_at = ?, acknowledged_at = ? WHERE user_id = ? AND dedup_key = ?`,
+		n.Type, n.Message, suppressed, now, notifiedAt, ackAt, userID, *n.DedupKey); err != nil {
+		return Decision{}, err
+	}
+	return d, tx.Commit()
+}
+
+func nullable(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// Group states for ListGroups.
+const (
+	StateSnoozed      = "snoozed"
+	StateAcknowledged = "acknowledged"
+)
+
+// ListGroups returns the groups of userID, most recently seen first,
+// optionally only those snoozed or acknowledged right now.
+func ListGroups(db *sqlx.DB, userID int64, state string, limit int) ([]Group, error) {
+	query := `SELECT * FROM notification_groups WHERE user_id = ?`
+	args := []interface{}{userID}
+	switch state {
+	case "":
+	case StateSnoozed:
+		query += ` AND snoozed_until IS NOT NULL`
+	case StateAcknowledged:
+		query += ` AND acknowledged_at IS NOT NULL`
+	default:
+		return nil, fmt.Errorf("state must be %q or %q", StateSnoozed, StateAcknowledged)
+	}
+	groups := []Group{}
+	if err := db.Select(&groups, query+` ORDER BY last_seen_at DESC`, args...); err != nil {
+		return nil, err
+	}
+	// Expired snoozes are filtered here; the timestamps are written by Go
+	// and compare reliably only as times.
+	now := time.Now()
+	list := groups[:0]
+	for _, g := range groups {
+		if state == StateSnoozed && !g.SnoozedUntil.After(now) {
+			continue
+		}
+		list = append(list, g)
+		if len(list) == limit {
+			break
+		}
+	}
+	return list, nil
+}
+
+// KeyOf returns the dedup key of a notification of userID, or sql.ErrNoRows
+// if it has none.
+func KeyOf(db *sqlx.DB, userID, notificationID int64) (string, error) {
+	var key sql.NullString
+	if err := db.Get(&key, `SELECT dedup_key FROM notifications WHERE id = ? AND user_id = ?`,
+		notificationID, userID); err != nil {
+		return "", err
+	}
+	if !key.Valid || key.String == "" {
+		return "", sql.ErrNoRows
+	}
+	return key.String, nil
+}
+
+func updateGroup(db *sqlx.DB, userID int64, key, set string, args ...interface{}) (Group, error) {
+	res, err := db.Exec(`UPDATE notification_groups SET `+set+` WHERE user_id = ? AND dedup_key = ?`,
+		append(args, userID, key)...)
+	if err != nil {
+		return Group{}, err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return Group{}, sql.ErrNoRows
+	}
+	var g Group
+	err = db.Get(&g, `SELECT * FROM notification_groups WHERE user_id = ? AND dedup_key = ?`, userID, key)
+	return g, err
+}
+
+// Snooze silences a group until until; a zero until ends the snooze.
+func Snooze(db *sqlx.DB, userID int64, key string, until time.Time) (Group, error) {
+	var v interface{}
+	if !until.IsZero() {
+		v = until.UTC()
+	}
+	return updateGroup(db, userID, key, `snoozed_until = ?`, v)
+}
+
+// Acknowledge silences a group until it clears, or lifts the acknowledgement.
+func Acknowledge(db *sqlx.DB, userID int64, key string, ack bool) (Group, error) {
+	var v interface{}
+	if ack {
+		v = time.Now().UTC()
+	}
+	return updateGroup(db, userID, key, `acknowledged_at = ?`, v)
+}
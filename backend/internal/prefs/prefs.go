@@ -0,0 +1,120 @@
+// Package prefs applies a user's notification preferences before anything is
+// stored: which channels each notification type goes to, deduplication of
+// repeated findings with a re-notify interval, and snoozed or acknowledged
+// notification groups.
+package prefs
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TypePrefs are the channels one notification type is delivered to. Types
+// without a row go everywhere.
+type TypePrefs struct {
+	Type    string `db:"type" json:"type"`
+	InApp   bool   `db:"in_app" json:"in_app"`
+	Email   bool   `db:"email" json:"email"`
+	Webhook bool   `db:"webhook" json:"webhook"`
+	// Chat lets the type through to the chat channels of the workspace, which
+	// then apply their own routing.
+	Chat bool `db:"chat" json:"chat"`
+}
+
+func (t TypePrefs) muted() bool {
+	return !t.InApp && !t.Email && !t.Webhook && !t.Chat
+}
+
+// Preferences are everything a user configured.
+type Preferences struct {
+	// RenotifySeconds is how long a repeated finding stays quiet after it was
+	// last notified; 0 turns deduplication off.
+	RenotifySeconds int         `json:"renotify_seconds"`
+	Types           []TypePrefs `json:"types"`
+}
+
+// DefaultRenotify is the re-notify interval of users who did not set one,
+// SENTRINET_RENOTIFY_SECONDS or one day.
+func DefaultRenotify() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("SENTRINET_RENOTIFY_SECONDS")); err == nil && v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return 24 * time.Hour
+}
+
+func renotify(db sqlx.Queryer, userID int64) (time.Duration, error) {
+	var seconds int
+	err := sqlx.Get(db, &seconds, `SELECT renotify_seconds FROM notification_settings WHERE user_id = ?`, userID)
+	if err == sql.ErrNoRows {
+		return DefaultRenotify(), nil
+	}
+	return time.Duration(seconds) * time.Second, err
+}
+
+func Get(db *sqlx.DB, userID int64) (Preferences, error) {
+	interval, err := renotify(db, userID)
+	if err != nil {
+		return Preferences{}, err
+	}
+	p := Preferences{RenotifySeconds: int(interval / time.Second), Types: []TypePrefs{}}
+	err = db.Select(&p.Types, `SELECT type, in_app, email, webhook, chat FROM notification_type_prefs
+		WHERE user_id = ? ORDER BY type`, userID)
+	return p, err
+}
+
+// Set replaces the preferences of userID. Types left out go back to every
+// channel.
+func Set(db *sqlx.DB, userID int64, p Preferences) (Preferences, error) {
+	if p.RenotifySeconds < 0 {
+		return p, fmt.Errorf("renotify_seconds must not be negative")
+	}
+	seen := map[string]bool{}
+	for _, t := range p.Types {
+		if t.Type == "" {
+			return p, fmt.Errorf("type is required")
+		}
+		if seen[t.Type] {
+			return p, fmt.Errorf("type %q is listed twice", t.Type)
+		}
+		seen[t.Type] = true
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO notification_settings (user_id, renotify_seconds) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET renotify_seconds = excluded.renotify_seconds, updated_at = CURRENT_TIMESTAMP`,
+		userID, p.RenotifySeconds); err != nil {
+		return p, err
+	}
+	if _, err := tx.Exec(`DELETE FROM notification_type_prefs WHERE user_id = ?`, userID); err != nil {
+		return p, err
+	}
+	for _, t := range p.Types {
+		if _, err := tx.Exec(`INSERT INTO notification_type_prefs (user_id, type, in_app, email, webhook, chat)
+			VALUES (?, ?, ?, ?, ?, ?)`, userID, t.Type, t.InApp, t.Email, t.Webhook, t.Chat); err != nil {
+			return p, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return p, err
+	}
+	return Get(db, userID)
+}
+
+func typePrefs(db sqlx.Queryer, userID int64, notifType string) (TypePrefs, error) {
+	t := TypePrefs{Type: notifType, InApp: true, Email: true, Webhook: true, Chat: true}
+	err := sqlx.Get(db, &t, `SELECT type, in_app, email, webhook, chat FROM notification_type_prefs
+		WHERE user_id = ? AND type = ?`, userID, notifType)
+	if err == sql.ErrNoRows {
+		return t, nil
+	}
+	return t, err
+}
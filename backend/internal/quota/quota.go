@@ -0,0 +1,351 @@
+// Package quota bounds how much scanning a user and a workspace may do: ports
+// scanned per day, concurrent scans, active schedules and the minimum
+// schedule interval. A request must fit both the user's and the workspace's
+// limits.
+package quota
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	ScopeUser      = "user"
+	ScopeWorkspace = "workspace"
+)
+
+// Limits are the quotas of one user or workspace. Zero means unlimited.
+type Limits struct {
+	PortsPerDay        int64 `json:"ports_per_day"`
+	ConcurrentScans    int   `json:"concurrent_scans"`
+	ActiveSchedules    int   `json:"active_schedules"`
+	MinIntervalSeconds int   `json:"min_interval_seconds"`
+}
+
+// Override is an admin set quota row. Nil fields fall back to the default.
+type Override struct {
+	PortsPerDay        *int64 `db:"ports_per_day" json:"ports_per_day"`
+	ConcurrentScans    *int   `db:"concurrent_scans" json:"concurrent_scans"`
+	ActiveSchedules    *int   `db:"active_schedules" json:"active_schedules"`
+	MinIntervalSeconds *int   `db:"min_interval_seconds" json:"min_interval_seconds"`
+}
+
+// Usage is the current consumption of one user or workspace.
+type Usage struct {
+	PortsToday      int64 `db:"ports" json:"ports_today"`
+	ScansToday      int64 `db:"scans" json:"scans_today"`
+	ConcurrentScans int   `db:"-" json:"concurrent_scans"`
+	ActiveSchedules int   `db:"-" json:"active_schedules"`
+}
+
+func envInt(name string, def int64) int64 {
+	if v, err := strconv.ParseInt(os.Getenv(name), 10, 64); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
+
+// Defaults returns the limits of scope without an override. Users default to
+// SENTRINET_QUOTA_PORTS_PER_DAY, _CONCURRENT_SCANS, _ACTIVE_SCHEDULES and
+// _MIN_INTERVAL_SECONDS; workspaces read the same names with the prefix
+// SENTRINET_WORKSPACE_QUOTA_ and are unlimited by default.
+func Defaults(scope string) Limits {
+	if scope == ScopeWorkspace {
+		return Limits{
+			PortsPerDay:        envInt("SENTRINET_WORKSPACE_QUOTA_PORTS_PER_DAY", 0),
+			ConcurrentScans:    int(envInt("SENTRINET_WORKSPACE_QUOTA_CONCURRENT_SCANS", 0)),
+			ActiveSchedules:    int(envInt("SENTRINET_WORKSPACE_QUOTA_ACTIVE_SCHEDULES", 0)),
+			MinIntervalSeconds: int(envInt("SENTRINET_WORKSPACE_QUOTA_MIN_INTERVAL_SECONDS", 0)),
+		}
+	}
+	return Limits{
+		PortsPerDay:        envInt("SENTRINET_QUOTA_PORTS_PER_DAY", 1000000),
+		ConcurrentScans:    int(envInt("SENTRINET_QUOTA_CONCURRENT_SCANS", 2)),
+		ActiveSchedules:    int(envInt("SENTRINET_QUOTA_ACTIVE_SCHEDULES", 10)),
+		MinIntervalSeconds: int(envInt("SENTRINET_QUOTA_MIN_INTERVAL_SECONDS", 300)),
+	}
+}
+
+// Get returns the effective limits of a user or workspace.
+func Get(db *sqlx.DB, scope string, id int64) (Limits, error) {
+	l := Defaults(scope)
+	var o Override
+	err := db.Get(&o, `SELECT ports_per_day, concurrent_scans, active_schedules, min_interval_seconds
+		FROM quotas WHERE scope = ? AND subject_id = ?`, scope, id)
+	if err == sql.ErrNoRows {
+		return l, nil
+	}
+	if err != nil {
+		return l, err
+	}
+	if o.PortsPerDay != nil {
+		l.PortsPerDay = *o.PortsPerDay
+	}
+	if o.ConcurrentScans != nil {
+		l.ConcurrentScans = *o.ConcurrentScans
+	}
+	if o.ActiveSchedules != nil {
+		l.ActiveSchedules = *o.ActiveSchedules
+	}
+	if o.MinIntervalSeconds != nil {
+		l.MinIntervalSeconds = *o.MinIntervalSeconds
+	}
+	return l, nil
+}
+
+// Set replaces the override of a user or workspace. An override without any
+// field removes it.
+func Set(db *sqlx.DB, scope string, id int64, o Override) error {
+	for _, v := range []*int{o.ConcurrentScans, o.ActiveSchedules, o.MinIntervalSeconds} {
+		if v != nil && *v < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "quotas must not be negative")
+		}
+	}
+	if o.PortsPerDay != nil && *o.PortsPerDay < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "quotas must not be negative")
+	}
+	if o.PortsPerDay == nil && o.ConcurrentScans == nil && o.ActiveSchedules == nil && o.MinIntervalSeconds == nil {
+		_, err := db.Exec(`DELETE FROM quotas WHERE scope = ? AND subject_id = ?`, scope, id)
+		return err
+	}
+	_, err := db.Exec(`INSERT INTO quotas (scope, subject_id, ports_per_day, concurrent_scans, active_schedules,
+		min_interval_seconds) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(scope, subject_id) DO UPDATE SET ports_per_day = excluded.ports_per_day,
+		concurrent_scans = excluded.concurrent_scans, active_schedules = excluded.active_schedules,
+		min_interval_seconds = excluded.min_interval_seconds, updated_at = CURRENT_TIMESTAMP`,
+		scope, id, o.PortsPerDay, o.ConcurrentScans, o.ActiveSchedules, o.MinIntervalSeconds)
+	return err
+}
+
+func today() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+// ExceededError is a refusal because a quota is used up. It unwraps to a 429
+// *fiber.Error so the API reports it as such.
+type ExceededError struct {
+	err *fiber.Error
+}
+
+func (e *ExceededError) Error() string { return e.err.Error() }
+func (e *ExceededError) Unwrap() error { return e.err }
+
+// IsExceeded reports whether err is a quota refusal rather than a failure.
+func IsExceeded(err error) bool {
+	var e *ExceededError
+	return errors.As(err, &e)
+}
+
+func exceeded(format string, args ...interface{}) error {
+	return &ExceededError{fiber.NewError(fiber.StatusTooManyRequests, "quota exceeded: "+fmt.Sprintf(format, args...))}
+}
+
+// subject is a user or workspace a quota applies to.
+type subject struct {
+	scope string
+	id    int64
+}
+
+func subjects(userID, workspaceID int64) []subject {
+	return []subject{{ScopeUser, userID}, {ScopeWorkspace, workspaceID}}
+}
+
+// limitsOf loads the limits of every subject, keyed by scope.
+func limitsOf(db *sqlx.DB, subs []subject) (map[string]Limits, error) {
+	limits := map[string]Limits{}
+	for _, s := range subs {
+		l, err := Get(db, s.scope, s.id)
+		if err != nil {
+			return nil, err
+		}
+		limits[s.scope] = l
+	}
+	return limits, nil
+}
+
+// portsLeft returns an error if scanning ports would take any subject over
+// its daily limit.
+func portsLeft(q sqlx.Queryer, subs []subject, limits map[string]Limits, day string, ports int64) error {
+	for _, s := range subs {
+		l := limits[s.scope]
+		if l.PortsPerDay == 0 {
+			continue
+		}
+		var used int64
+		if err := sqlx.Get(q, &used, `SELECT COALESCE(SUM(ports), 0) FROM usage_daily
+			WHERE scope = ? AND subject_id = ? AND day = ?`, s.scope, s.id, day); err != nil {
+			return err
+		}
+		if used+ports > l.PortsPerDay {
+			return exceeded("%s has %d of %d ports left today, %d requested",
+				s.scope, max64(l.PortsPerDay-used, 0), l.PortsPerDay, ports)
+		}
+	}
+	return nil
+}
+
+// CheckPorts returns a 429 error if scanning ports now would take the user or
+// the workspace over its daily limit. Nothing is charged; that happens when
+// the scan starts.
+func CheckPorts(db *sqlx.DB, userID, workspaceID int64, ports int64) error {
+	subs := subjects(userID, workspaceID)
+	limits, err := limitsOf(db, subs)
+	if err != nil {
+		return err
+	}
+	return portsLeft(db, subs, limits, today(), ports)
+}
+
+// UsePorts charges ports to today's usage of the user and the workspace, or
+// returns a 429 error without charging anything if either would go over its
+// daily limit.
+func UsePorts(db *sqlx.DB, userID, workspaceID int64, ports int64) error {
+	subs := subjects(userID, workspaceID)
+	limits, err := limitsOf(db, subs)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	day := today()
+	if err := portsLeft(tx, subs, limits, day, ports); err != nil {
+		return err
+	}
+	for _, s := range subs {
+		if _, err := tx.Exec(`INSERT INTO usage_daily (scope, subject_id, day, ports, scans) VALUES (?, ?, ?, ?, 1)
+			ON CONFLICT(scope, subject_id, day) DO UPDATE SET ports = ports + excluded.ports, scans = scans + 1`,
+			s.scope, s.id, day, ports); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// running counts in-flight scans per scope and id, ad-hoc and scheduled. Both
+// run inside this process, so process memory is the right place to track them.
+var running = struct {
+	sync.Mutex
+	n map[string]int
+}{n: map[string]int{}}
+
+func key(scope string, id int64) string {
+	return scope + ":" + strconv.FormatInt(id, 10)
+}
+
+// Acquire reserves a concurrent scan slot for the user and the workspace, for
+// an ad-hoc scan or a scheduled run. The returned release func must be called
+// when the scan ends.
+func Acquire(db *sqlx.DB, userID, workspaceID int64) (func(), error) {
+	subs := subjects(userID, workspaceID)
+	limits, err := limitsOf(db, subs)
+	if err != nil {
+		return nil, err
+	}
+
+	running.Lock()
+	defer running.Unlock()
+	for _, s := range subs {
+		l := limits[s.scope].ConcurrentScans
+		if l > 0 && running.n[key(s.scope, s.id)] >= l {
+			return nil, exceeded("%s already runs %d concurrent scans", s.scope, l)
+		}
+	}
+	for _, s := range subs {
+		running.n[key(s.scope, s.id)]++
+	}
+	return func() {
+		running.Lock()
+		defer running.Unlock()
+		for _, s := range subs {
+			running.n[key(s.scope, s.id)]--
+		}
+	}, nil
+}
+
+func activeSchedules(db *sqlx.DB, scope string, id, excludeJobID int64) (int, error) {
+	column := "user_id"
+	if scope == ScopeWorkspace {
+		column = "workspace_id"
+	}
+	var count int
+	err := db.Get(&count, `SELECT COUNT(*) FROM jobs WHERE active = 1 AND `+column+` = ? AND id != ?`, id, excludeJobID)
+	return count, err
+}
+
+// CheckSchedule validates a schedule being created or changed. interval is
+// the schedule's interval in seconds, portsPerRun how many ports one run scans
+// and active whether it will run; jobID is the schedule being changed, or 0
+// for a new one. A run larger than the daily port limit could never succeed,
+// so it is refused here rather than failing every run.
+func CheckSchedule(db *sqlx.DB, userID, workspaceID, jobID int64, interval int, portsPerRun int64, active bool) error {
+	for _, s := range subjects(userID, workspaceID) {
+		l, err := Get(db, s.scope, s.id)
+		if err != nil {
+			return err
+		}
+		if l.MinIntervalSeconds > 0 && interval < l.MinIntervalSeconds {
+			return fiber.NewError(fiber.StatusBadRequest,
+				fmt.Sprintf("interval_seconds must be at least %d", l.MinIntervalSeconds))
+		}
+		if l.PortsPerDay > 0 && portsPerRun > l.PortsPerDay {
+			return exceeded("a run scans %d ports but %s may scan %d per day", portsPerRun, s.scope, l.PortsPerDay)
+		}
+		if !active || l.ActiveSchedules == 0 {
+			continue
+		}
+		count, err := activeSchedules(db, s.scope, s.id, jobID)
+		if err != nil {
+			return err
+		}
+		if count >= l.ActiveSchedules {
+			return exceeded("%s already has %d active schedules", s.scope, l.ActiveSchedules)
+		}
+	}
+	return nil
+}
+
+// Report is the usage and limits of a user or workspace.
+type Report struct {
+	Limits Limits `json:"limits"`
+	Usage  Usage  `json:"usage"`
+}
+
+// UsageOf reports today's consumption of a user or workspace.
+func UsageOf(db *sqlx.DB, scope string, id int64) (Report, error) {
+	l, err := Get(db, scope, id)
+	if err != nil {
+		return Report{}, err
+	}
+	var u Usage
+	err = db.Get(&u, `SELECT COALESCE(SUM(ports), 0) AS ports, COALESCE(SUM(scans), 0) AS scans
+		FROM usage_daily WHERE scope = ? AND subject_id = ? AND day = ?`, scope, id, today())
+	if err != nil {
+		return Report{}, err
+	}
+	if u.ActiveSchedules, err = activeSchedules(db, scope, id, 0); err != nil {
+		return Report{}, err
+	}
+	running.Lock()
+	u.ConcurrentScans = running.n[key(scope, id)]
+	running.Unlock()
+	return Report{Limits: l, Usage: u}, nil
+}
@@ -2,56 +2,113 @@ package realtime
 
 import (
 	"encoding/json"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/gofiber/websocket/v2"
 )
 
+const (
+	// queueSize is how many messages may wait for a slow client before it is
+	// disconnected.
+	queueSize = 64
+	// writeTimeout bounds a single write, so a stalled client is noticed.
+	writeTimeout = 10 * time.Second
+)
+
+// client is one connection and the messages waiting to be written to it. Its
+// own writer drains the queue, so a slow client only ever delays itself.
+type client struct {
+	userID int64
+	send chan []byte
+	done chan struct{}
+	// dropped is set, under the manager's lock, once the client fell behind
+	// and its connection was closed.
+	dropped bool
+}
+
+// Manager fans messages out to connected clients. Each client is registered
+// with the user it authenticated as, or 0 if it did not, so messages can be
+// addressed to a single user's connections. Sending never blocks: a client
+// whose queue is full is disconnected instead.
 type Manager struct {
 	mu sync.Mutex
-	clients map[*websocket.Conn] bool
-	broadcast chan WSMessage
+	clients map[*websocket.Conn]*client
 }
 
 func NewManager() *Manager{
-	m := &Manager{
-		clients: make(map[*websocket.Conn]bool),
-		broadcast: make(chan WSMessage),
-	}
-	go m.start()
-	return m
+	return &Manager{clients: make(map[*websocket.Conn]*client)}
 }
 
 func (m *Manager) Broadcast(msgType string, data interface{}){
-	m.broadcast <-WSMessage{Type: msgType, Data: data}
+	m.send(0, WSMessage{Type: msgType, Data: data})
+}
+
+// SendToUser sends a message to the connections of userID only.
+func (m *Manager) SendToUser(userID int64, msgType string, data interface{}){
+	if userID == 0{
+		return
+	}
+	m.send(userID, WSMessage{Type: msgType, Data: data})
 }
 
-func (m *Manager) start(){
-	for msg := range m.broadcast{
-		messageBytes, err := json.Marshal(msg)
-		if err != nil{
+// send queues msg for the clients of userID, or every client if userID is 0.
+func (m *Manager) send(userID int64, msg WSMessage){
+	messageBytes, err := json.Marshal(msg)
+	if err != nil{
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for conn, cl := range m.clients{
+		if cl.dropped || userID != 0 && userID != cl.userID{
 			continue
 		}
+		select{
+		case cl.send <- messageBytes:
+		default:
+			// Closing the connection ends the handler's read loop, which
+			// unregisters the client.
+			fmt.Printf("[WS] client of user %d is not keeping up, disconnecting\n", cl.userID)
+			cl.dropped = true
+			conn.Close()
+		}
+	}
+}
 
-		m.mu.Lock()
-		for conn := range m.clients{
-			if err := conn.WriteMessage(websocket.TextMessage, messageBytes); err!= nil{
-				conn.Close()
-				delete(m.clients, conn)
-			}
+// write sends queued messages to conn until the queue is closed. A failed
+// write closes the connection, which ends the handler's read loop.
+func (cl *client) write(conn *websocket.Conn){
+	defer close(cl.done)
+	for messageBytes := range cl.send{
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := conn.WriteMessage(websocket.TextMessage, messageBytes); err != nil{
+			conn.Close()
+			return
 		}
-		m.mu.Unlock()
 	}
 }
 
-func (m *Manager) Register(c *websocket.Conn){
+// Register adds a client; userID is 0 for connections without a token.
+func (m *Manager) Register(c *websocket.Conn, userID int64){
+	cl := &client{userID: userID, send: make(chan []byte, queueSize), done: make(chan struct{})}
 	m.mu.Lock()
-	m.clients[c] = true
+	m.clients[c] = cl
 	m.mu.Unlock()
+	go cl.write(c)
 }
 
+// Unregister removes a client and waits for its writer to stop, since the
+// connection must not be written to once its handler returns.
 func (m *Manager) Unregister(c *websocket.Conn){
 	m.mu.Lock()
+	cl, ok := m.clients[c]
 	delete(m.clients, c)
 	m.mu.Unlock()
-}
\ No newline at end of file
+	if ok{
+		close(cl.send)
+		<-cl.done
+	}
+}
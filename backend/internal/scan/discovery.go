@@ -0,0 +1,146 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MaxExpandHosts bounds how many hosts a CIDR target may expand to.
+const MaxExpandHosts = 4096
+
+// DefaultDiscoveryPorts are probed to decide whether a host is up.
+var DefaultDiscoveryPorts = []int{22, 80, 443, 445, 3389}
+
+// portWorkers bounds concurrent connection attempts of a single scan.
+const portWorkers = 256
+
+// IsCIDR reports whether target is a network rather than a single host.
+func IsCIDR(target string) bool {
+	_, _, err := net.ParseCIDR(target)
+	return err == nil
+}
+
+// ExpandTarget returns the hosts covered by target. A CIDR expands to its
+// addresses without the network and broadcast addresses; anything else is
+// returned as is.
+func ExpandTarget(target string) ([]string, error) {
+	ip, ipnet, err := net.ParseCIDR(target)
+	if err != nil {
+		return []string{target}, nil
+	}
+
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones > 30 || 1<<(bits-ones) > MaxExpandHosts+2 {
+		return nil, fmt.Errorf("%s expands to more than %d hosts", target, MaxExpandHosts)
+	}
+
+	hosts := []string{}
+	for cur := ip.Mask(ipnet.Mask); ipnet.Contains(cur); cur = nextIP(cur) {
+		hosts = append(hosts, cur.String())
+	}
+	if len(hosts) > 2 && ip.To4() != nil {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts, nil
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// DiscoverHosts returns the hosts that answer on any of the given ports. A
+// refused connection counts as an answer since only a live host sends it.
+func DiscoverHosts(ctx context.Context, hosts []string, ports []int) ([]string, error) {
+	if len(ports) == 0 {
+		ports = DefaultDiscoveryPorts
+	}
+
+	var mu sync.Mutex
+	live := make(map[string]bool)
+	sem := make(chan struct{}, portWorkers)
+	var wg sync.WaitGroup
+
+	for _, host := range hosts {
+		for _, port := range ports {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				wg.Wait()
+				return nil, ctx.Err()
+			}
+			wg.Add(1)
+			go func(h string, p int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				if hostAnswers(ctx, h, p) {
+					mu.Lock()
+					live[h] = true
+					mu.Unlock()
+				}
+			}(host, port)
+		}
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	up := []string{}
+	for _, h := range hosts {
+		if live[h] {
+			up = append(up, h)
+		}
+	}
+	return up, nil
+}
+
+func hostAnswers(ctx context.Context, host string, port int) bool {
+	dialer := net.Dialer{Timeout: 2 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, fmt.Sprintf("%d", port)))
+	if err == nil {
+		conn.Close()
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// ScanPortsContext scans the given ports of host with bounded concurrency.
+func ScanPortsContext(ctx context.Context, host string, ports []int) ([]PortResult, error) {
+	results := make([]PortResult, 0, len(ports))
+	var mu sync.Mutex
+	sem := make(chan struct{}, portWorkers)
+	var wg sync.WaitGroup
+
+	for _, port := range ports {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return results, ctx.Err()
+		}
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			r := scanPortContext(ctx, host, p)
+			mu.Lock()
+			results = append(results, r)
+			mu.Unlock()
+		}(port)
+	}
+	wg.Wait()
+	return results, ctx.Err()
+}
@@ -0,0 +1,44 @@
+package scan
+
+// commonPorts is the nmap top 100 TCP port list. TopPorts extends it with the
+// remaining ports in ascending order.
+var commonPorts = []int{
+	7, 9, 13, 21, 22, 23, 25, 26, 37, 53, 79, 80, 81, 88, 106, 110, 111, 113, 119, 135,
+	139, 143, 144, 179, 199, 389, 427, 443, 444, 445, 465, 513, 514, 515, 543, 544, 548, 554,
+	587, 631, 646, 873, 990, 993, 995, 1025, 1026, 1027, 1028, 1029, 1110, 1433, 1720, 1723,
+	1755, 1900, 2000, 2001, 2049, 2121, 2717, 3000, 3128, 3306, 3389, 3986, 4899, 5000, 5009,
+	5051, 5060, 5101, 5190, 5357, 5432, 5631, 5666, 5800, 5900, 6000, 6001, 6646, 7070, 8000,
+	8008, 8009, 8080, 8081, 8443, 8888, 9100, 9999, 10000, 32768, 49152, 49153, 49154, 49155,
+	49156, 49157,
+}
+
+// TopPorts returns n ports, most commonly open first.
+func TopPorts(n int) []int {
+	if n > 65535 {
+		n = 65535
+	}
+	ports := make([]int, 0, n)
+	seen := make(map[int]bool, len(commonPorts))
+	for _, p := range commonPorts {
+		if len(ports) == n {
+			return ports
+		}
+		ports = append(ports, p)
+		seen[p] = true
+	}
+	for p := 1; p <= 65535 && len(ports) < n; p++ {
+		if !seen[p] {
+			ports = append(ports, p)
+		}
+	}
+	return ports
+}
+
+// PortRange returns the ports from start to end inclusive.
+func PortRange(start, end int) []int {
+	ports := make([]int, 0, end-start+1)
+	for p := start; p <= end; p++ {
+		ports = append(ports, p)
+	}
+	return ports
+}
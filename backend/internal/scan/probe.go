@@ -0,0 +1,108 @@
+package scan
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const probeTimeout = 5 * time.Second
+
+// DefaultTLSPorts and DefaultHTTPPorts are the open ports probed by the
+// pipeline TLS and HTTP stages when a stage does not list its own.
+var (
+	DefaultTLSPorts  = []int{443, 465, 636, 993, 995, 8443}
+	DefaultHTTPPorts = []int{80, 443, 8000, 8008, 8080, 8081, 8443, 8888}
+)
+
+type TLSInfo struct {
+	Version  string    `json:"version"`
+	Subject  string    `json:"subject"`
+	Issuer   string    `json:"issuer"`
+	NotAfter time.Time `json:"not_after"`
+	DNSNames []string  `json:"dns_names,omitempty"`
+}
+
+type HTTPInfo struct {
+	Status int    `json:"status"`
+	Server string `json:"server"`
+	Title  string `json:"title"`
+}
+
+// ProbeTLS performs a TLS handshake and reports the leaf certificate. The
+// certificate is not verified: expired and self-signed certificates are
+// exactly what the probe is meant to find.
+func ProbeTLS(ctx context.Context, host string, port int) (TLSInfo, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: probeTimeout},
+		Config:    &tls.Config{InsecureSkipVerify: true, ServerName: host},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, fmt.Sprintf("%d", port)))
+	if err != nil {
+		return TLSInfo{}, err
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return TLSInfo{}, fmt.Errorf("no peer certificate")
+	}
+	cert := state.PeerCertificates[0]
+	return TLSInfo{
+		Version:  tls.VersionName(state.Version),
+		Subject:  cert.Subject.String(),
+		Issuer:   cert.Issuer.String(),
+		NotAfter: cert.NotAfter,
+		DNSNames: cert.DNSNames,
+	}, nil
+}
+
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// probeClient is shared by every HTTP probe. Probes hit each host once, so
+// keep-alives are off and no connection outlives its probe.
+var probeClient = &http.Client{
+	Timeout: probeTimeout,
+	Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// ProbeHTTP issues a GET / and reports the status, Server header and page
+// title. Redirects are not followed.
+func ProbeHTTP(ctx context.Context, host string, port int, useTLS bool) (HTTPInfo, error) {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(host, fmt.Sprintf("%d", port)))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return HTTPInfo{}, err
+	}
+	req.Header.Set("User-Agent", "Sentrinet-Probe/1.0")
+
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return HTTPInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	info := HTTPInfo{Status: resp.StatusCode, Server: resp.Header.Get("Server")}
+	if m := titlePattern.FindSubmatch(body); m != nil {
+		info.Title = strings.TrimSpace(string(m[1]))
+	}
+	return info, nil
+}
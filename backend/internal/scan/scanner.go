@@ -1,6 +1,7 @@
 package scan
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"time"
@@ -40,20 +41,38 @@ func ScanPort(target string, port int) PortResult{
 	return PortResult{Port: port, IsOpen: true, Duration: duration}
 }
 
+// ScanRange scans the range without a deadline, at most portWorkers ports
+// at a time.
 func ScanRange(target string, startPort, endPort int) []PortResult{
-	results := make([]PortResult, 0)
-	ch := make(chan PortResult)
+	results, _ := ScanPortsContext(context.Background(), target, PortRange(startPort, endPort))
+	return results
+}
 
-	for port := startPort; port <= endPort; port++{
-		go func(p int){
-			ch <- ScanPort(target, p)
-		}(port)
+// ScanRangeContext scans the range like ScanRange but stops when ctx is done,
+// and fails up front if the target does not resolve.
+func ScanRangeContext(ctx context.Context, target string, startPort, endPort int) ([]PortResult, error){
+	if _, err := net.DefaultResolver.LookupHost(ctx, target); err != nil{
+		return nil, fmt.Errorf("resolve %s: %w", target, err)
 	}
+	return ScanPortsContext(ctx, target, PortRange(startPort, endPort))
+}
 
-	for i := startPort; i <= endPort; i++{
-		result := <-ch
-		results = append(results, result)
+func scanPortContext(ctx context.Context, target string, port int) PortResult{
+	start := time.Now()
+	address := net.JoinHostPort(target, fmt.Sprintf("%d", port))
+
+	dialer := net.Dialer{Timeout: 5*time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	duration := time.Since(start).Milliseconds()
+
+	if err != nil{
+		metrics.ClosedPorts.Inc()
+		return PortResult{Port: port, IsOpen: false, Duration: duration}
 	}
 
-	return results
-}
\ No newline at end of file
+	conn.Close()
+	metrics.OpenPorts.Inc()
+	metrics.TotalScans.Inc()
+	metrics.ScanDurationMs.Observe(float64(duration))
+	return PortResult{Port: port, IsOpen: true, Duration: duration}
+}
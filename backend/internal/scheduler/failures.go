@@ -0,0 +1,133 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/handlers"
+	"github.com/KuberTheGreat/Sentrinet/internal/models"
+)
+
+const (
+	RunTimedOut = "timed_out"
+	RunSkipped  = "skipped"
+	// RunQuotaExceeded is a run refused by the owner's or the workspace's
+	// quota. It is not a failure of the job: it neither backs off nor counts
+	// towards max_failures.
+	RunQuotaExceeded = "quota_exceeded"
+
+	defaultMaxRunSeconds = 600
+	defaultMaxFailures   = 5
+
+	// maxBackoffShift caps the backoff multiplier at 2^6 = 64x the interval.
+	maxBackoffShift = 6
+	maxBackoff      = 24 * time.Hour
+)
+
+// backoff returns the effective interval after the given number of
+// consecutive failures: interval, 2x, 4x, ... capped at maxBackoff.
+func backoff(interval time.Duration, failures int32) time.Duration {
+	if failures <= 0 {
+		return interval
+	}
+	shift := failures
+	if shift > maxBackoffShift {
+		shift = maxBackoffShift
+	}
+	d := interval << uint(shift)
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	if d < interval {
+		d = interval
+	}
+	return d
+}
+
+// backoffRemaining reports how much longer a failing job must wait before
+// its next run. It is zero for healthy jobs.
+func (s *runState) backoffRemaining(interval time.Duration) time.Duration {
+	failures := s.failures.Load()
+	last := s.lastRun.Load()
+	if failures == 0 || last == 0 {
+		return 0
+	}
+	return time.Until(time.Unix(0, last).Add(backoff(interval, failures)))
+}
+
+func (m *Manager) maxRunDuration(jr JobRow) time.Duration {
+	secs := jr.MaxRunSeconds
+	if secs <= 0 {
+		secs = envInt("SENTRINET_SCHEDULER_MAX_RUN_SECONDS", defaultMaxRunSeconds)
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func maxFailures(jr JobRow) int {
+	if jr.MaxFailures > 0 {
+		return jr.MaxFailures
+	}
+	return defaultMaxFailures
+}
+
+// recordOutcome updates the failure streak of a job after run runID. Every
+// failure notifies the job's owner; once the streak reaches the job's limit
+// the job is disabled.
+func (m *Manager) recordOutcome(jr JobRow, state *runState, runID int64, runErr error) {
+	if runErr == nil {
+		if state.failures.Swap(0) != 0 {
+			if _, err := m.db.Exec("UPDATE jobs SET consecutive_failures = 0 WHERE id = ?", jr.ID); err != nil {
+				fmt.Printf("[Scheduler] job %d failure reset error: %v\n", jr.ID, err)
+			}
+		}
+		return
+	}
+
+	failures := state.failures.Add(1)
+	if _, err := m.db.Exec("UPDATE jobs SET consecutive_failures = ? WHERE id = ?", failures, jr.ID); err != nil {
+		fmt.Printf("[Scheduler] job %d failure count error: %v\n", jr.ID, err)
+	}
+
+	limit := maxFailures(jr)
+	if int(failures) < limit {
+		fmt.Printf("[Scheduler] job %d failed %d time(s), backing off to %s\n",
+			jr.ID, failures, backoff(time.Duration(jr.IntervalSeconds)*time.Second, failures))
+		msg := fmt.Sprintf("Scheduled scan of %s (job %d) failed: %v", jr.Target, jr.ID, runErr)
+		m.notifyRun(jr, runID, "job_failed", models.SeverityMedium, msg, fmt.Sprintf("job_failed:%d", jr.ID))
+		return
+	}
+
+	fmt.Printf("[Scheduler] job %d disabled after %d consecutive failures\n", jr.ID, failures)
+	if err := m.StopJob(jr.ID); err != nil {
+		fmt.Printf("[Scheduler] job %d disable error: %v\n", jr.ID, err)
+	}
+	msg := fmt.Sprintf("Scheduled scan of %s (job %d) was disabled after %d consecutive failures. Last error: %v",
+		jr.Target, jr.ID, failures, runErr)
+	m.notifyRun(jr, runID, "job_disabled", models.SeverityHigh, msg, "")
+}
+
+// notifyRun notifies the owner of jr about run runID. Notifications sharing a
+// non-empty dedupKey are deduplicated.
+func (m *Manager) notifyRun(jr JobRow, runID int64, notifType, severity, msg, dedupKey string) {
+	n := models.Notification{
+		UserID: int(jr.UserID), WorkspaceID: jr.WorkspaceID, Type: notifType, Message: msg,
+		Severity: severity, RunID: &runID,
+	}
+	if dedupKey != "" {
+		n.DedupKey = &dedupKey
+	}
+	if _, err := handlers.Notify(m.db, n); err != nil {
+		fmt.Printf("[Scheduler] job %d notification error: %v\n", jr.ID, err)
+	}
+}
+
+// recordSkipped stores a tick that could not run because the previous run
+// was still queued or executing.
+func (m *Manager) recordSkipped(jobID int64, reason string) {
+	if _, err := m.db.Exec(
+		`INSERT INTO job_runs (job_id, trigger_type, status, error, finished_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		jobID, TriggerSchedule, RunSkipped, reason,
+	); err != nil {
+		fmt.Printf("[Scheduler] job %d skip record error: %v\n", jobID, err)
+	}
+}
@@ -3,11 +3,18 @@ package scheduler
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/KuberTheGreat/Sentrinet/internal/alerts"
+	"github.com/KuberTheGreat/Sentrinet/internal/metrics"
+	"github.com/KuberTheGreat/Sentrinet/internal/models"
+	"github.com/KuberTheGreat/Sentrinet/internal/quota"
 	"github.com/KuberTheGreat/Sentrinet/internal/scan"
 	"github.com/jmoiron/sqlx"
 )
@@ -21,6 +28,28 @@ type JobRow struct{
 	Active int `db:"active"`
 	CreatedAt time.Time `db:"created_at"`
 	UserID int64 `db:"user_id"`
+	MaxRunSeconds int `db:"max_run_seconds"`
+	MaxFailures int `db:"max_failures"`
+	ConsecutiveFailures int `db:"consecutive_failures"`
+	JitterSeconds int `db:"jitter_seconds"`
+	ScheduleMode string `db:"schedule_mode"`
+	Pipeline *string `db:"pipeline"`
+	WorkspaceID int64 `db:"workspace_id"`
+}
+
+// JobSpec is the user supplied definition of a job, used for creation and as
+// the target of validation for updates.
+type JobSpec struct{
+	Target string `json:"target"`
+	StartPort int `json:"start_port"`
+	EndPort int `json:"end_port"`
+	IntervalSeconds int `json:"interval_seconds"`
+	Active bool `json:"active"`
+	MaxRunSeconds int `json:"max_run_seconds"`
+	MaxFailures int `json:"max_failures"`
+	JitterSeconds int `json:"jitter_seconds"`
+	ScheduleMode string `json:"schedule_mode"`
+	Pipeline *Pipeline `json:"pipeline,omitempty"`
 }
 
 type Manager struct{
@@ -29,26 +58,97 @@ type Manager struct{
 	cancel context.CancelFunc
 	mu sync.Mutex
 	runners map[int64]*jobRunner
+	states map[int64]*runState
 	wg sync.WaitGroup
+
+	queue *runQueue
+	concurrency int
+	active int32
+	// activeIn counts the running runs of each workspace for QueueStatus.
+	activeMu sync.Mutex
+	activeIn map[int64]int
 }
 
 type jobRunner struct{
 	cancel context.CancelFunc
-	running int32
+	state *runState
 	jobRow JobRow
 }
 
+// runState survives runner restarts so that an update does not cause a
+// duplicate or a missed run. running is set from the moment a run is queued
+// until it finishes.
+type runState struct{
+	running int32
+	lastRun atomic.Int64
+	failures atomic.Int32
+}
+
+// nextDelay returns how long a restarted runner should wait before its first
+// run so that the schedule continues from the last run at the new interval.
+func (s *runState) nextDelay(interval time.Duration) time.Duration{
+	last := s.lastRun.Load()
+	if last == 0{
+		return 0
+	}
+	d := time.Until(time.Unix(0, last).Add(interval))
+	if d < 0{
+		return 0
+	}
+	return d
+}
+
+var (
+	ErrJobBusy = errors.New("job is already running")
+	ErrShuttingDown = errors.New("scheduler is shutting down")
+)
+
+const defaultConcurrency = 4
+
 func NewManager(parentCtx context.Context, db *sqlx.DB) *Manager{
 	ctx, cancel := context.WithCancel(parentCtx)
-	return &Manager{
+	m := &Manager{
 		db: db,
 		ctx: ctx,
 		cancel: cancel,
 		runners: make(map[int64]*jobRunner),
+		states: make(map[int64]*runState),
+		activeIn: make(map[int64]int),
+		queue: newRunQueue(ctx, envInt("SENTRINET_SCHEDULER_PER_TARGET", defaultPerTarget)),
+		concurrency: envInt("SENTRINET_SCHEDULER_CONCURRENCY", defaultConcurrency),
+	}
+
+	for i := 0; i < m.concurrency; i++{
+		m.wg.Add(1)
+		go m.worker()
+	}
+	return m
+}
+
+func envInt(name string, def int) int{
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || v <= 0{
+		return def
+	}
+	return v
+}
+
+// stateLocked returns the run state of a job, creating it on first use.
+// m.mu must be held.
+func (m *Manager) stateLocked(id int64) *runState{
+	st, ok := m.states[id]
+	if !ok{
+		st = &runState{}
+		m.states[id] = st
 	}
+	return st
 }
 
 func (m *Manager) LoadAndStartAll() error{
+	if err := m.RecoverInterrupted(); err != nil{
+		fmt.Printf("[Scheduler] failed to recover interrupted runs: %v\n", err)
+	}
+
 	rows := []JobRow{}
 	if err := m.db.Select(&rows, "SELECT * FROM jobs WHERE active = 1"); err != nil{
 		if err == sql.ErrNoRows{
@@ -65,17 +165,84 @@ func (m *Manager) LoadAndStartAll() error{
 	return nil
 }
 
-func (m *Manager) CreateJob(target string, startPort, endPort int, interval time.Duration, active bool, userID int64) (int64, error){
-	intervalSec := int(interval.Seconds())
+// Validate checks the fields shared by job creation and updates.
+// Pipeline jobs take their ports from their stages and may target a CIDR.
+func (s JobSpec) Validate() error{
+	if s.Pipeline != nil && len(s.Pipeline.Stages) > 0 {
+		if s.Target == "" || s.IntervalSeconds <= 0 {
+			return fmt.Errorf("target and interval seconds are required")
+		}
+		if err := s.Pipeline.Validate(); err != nil {
+			return err
+		}
+		if _, err := scan.ExpandTarget(s.Target); err != nil {
+			return err
+		}
+	} else {
+		if s.Target == "" || s.StartPort <= 0 || s.EndPort <= 0 || s.IntervalSeconds <= 0 {
+			return fmt.Errorf("target, ports and interval seconds are required")
+		}
+		if s.StartPort > 65535 || s.EndPort > 65535 || s.StartPort > s.EndPort {
+			return fmt.Errorf("invalid port range %d-%d", s.StartPort, s.EndPort)
+		}
+		if scan.IsCIDR(s.Target) {
+			return fmt.Errorf("CIDR targets need a pipeline")
+		}
+	}
+	if s.MaxRunSeconds < 0 || s.MaxFailures < 0 {
+		return fmt.Errorf("max_run_seconds and max_failures must not be negative")
+	}
+	if s.JitterSeconds < 0 || s.JitterSeconds >= s.IntervalSeconds {
+		return fmt.Errorf("jitter_seconds must be between 0 and interval_seconds")
+	}
+	switch s.ScheduleMode {
+	case "", ModeFixed, ModeSpread:
+	default:
+		return fmt.Errorf("schedule_mode must be %q or %q", ModeFixed, ModeSpread)
+	}
+	return nil
+}
+
+// Spec returns the user editable definition of the job.
+func (jr JobRow) Spec() JobSpec{
+	spec := JobSpec{
+		Target: jr.Target,
+		StartPort: jr.StartPort,
+		EndPort: jr.EndPort,
+		IntervalSeconds: jr.IntervalSeconds,
+		Active: jr.Active == 1,
+		MaxRunSeconds: jr.MaxRunSeconds,
+		MaxFailures: jr.MaxFailures,
+		JitterSeconds: jr.JitterSeconds,
+		ScheduleMode: jr.ScheduleMode,
+	}
+	// Stored pipelines were validated on write.
+	spec.Pipeline, _ = jr.pipeline()
+	return spec
+}
+
+func (m *Manager) CreateJob(spec JobSpec, userID, workspaceID int64) (int64, error){
+	if err := spec.Validate(); err != nil{
+		return 0, err
+	}
 	activeInt := 0
-	if active{
+	if spec.Active{
 		activeInt = 1
 	}
+	if spec.ScheduleMode == ""{
+		spec.ScheduleMode = ModeFixed
+	}
+	pipeline, err := encodePipeline(spec.Pipeline)
+	if err != nil{
+		return 0, err
+	}
 
 	res, err := m.db.Exec(
-		`INSERT INTO jobs (target, start_port, end_port, interval_seconds, active, user_id)
-		VALUES (?, ?, ?, ?, ?, ?)`,
-		target, startPort, endPort, intervalSec, activeInt, userID,
+		`INSERT INTO jobs (target, start_port, end_port, interval_seconds, active, user_id, workspace_id,
+		max_run_seconds, max_failures, jitter_seconds, schedule_mode, pipeline)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		spec.Target, spec.StartPort, spec.EndPort, spec.IntervalSeconds, activeInt, userID, workspaceID,
+		spec.MaxRunSeconds, spec.MaxFailures, spec.JitterSeconds, spec.ScheduleMode, pipeline,
 	)
 	if err != nil{
 		return 0, err
@@ -83,15 +250,10 @@ func (m *Manager) CreateJob(target string, startPort, endPort int, interval time
 
 	id, _ := res.LastInsertId()
 
-	if active{
-		jr := JobRow{
-			ID: id,
-			Target: target,
-			StartPort: startPort,
-			EndPort: endPort,
-			IntervalSeconds: intervalSec,
-			Active: activeInt,
-			UserID: userID,
+	if spec.Active{
+		jr, err := m.GetJob(id)
+		if err != nil{
+			return id, err
 		}
 		if err := m.startRunner(jr); err != nil{
 			fmt.Printf("[Scheduler] created job %d but failed to start runner: %v\n", id, err)
@@ -109,81 +271,261 @@ func (m *Manager) startRunner(jr JobRow) error{
 		return fmt.Errorf("job %d already running", jr.ID)
 	}
 
+	m.startRunnerLocked(jr, m.stateLocked(jr.ID), m.initialDelay(jr))
+	return nil
+}
+
+// startRunnerLocked launches the ticker loop for jr. The first run fires after
+// delay; state is shared with any previous runner of the same job so that an
+// in-flight run is never duplicated across a restart. m.mu must be held.
+func (m *Manager) startRunnerLocked(jr JobRow, state *runState, delay time.Duration){
 	ctx, cancel := context.WithCancel(m.ctx)
 	runner := &jobRunner{
 		cancel: cancel,
+		state: state,
 		jobRow: jr,
 	}
+	state.failures.Store(int32(jr.ConsecutiveFailures))
 
 	m.runners[jr.ID] = runner
 	m.wg.Add(1)
 
 	go func(){
 		defer m.wg.Done()
-		ticker := time.NewTicker(time.Duration(jr.IntervalSeconds) * time.Second)
-		defer ticker.Stop()
-
-		if err := m.executeOnce(ctx, jr, runner); err != nil{
-			fmt.Printf("[Scheduler] initial run error for job %d: %v\n", jr.ID, err)
-		}
+		interval := time.Duration(jr.IntervalSeconds) * time.Second
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
 
 		for{
 			select{
 			case <-ctx.Done():
 				fmt.Printf("[Scheduler] job %d stopped\n", jr.ID)
 				return
-			case <-ticker.C:
-				if !atomic.CompareAndSwapInt32(&runner.running, 0, 1){
-					fmt.Printf("[Scheduler] job %d previous run still active, skipping tick\n", jr.ID)
+			case <-timer.C:
+				if ctx.Err() != nil{
 					continue
 				}
-
-				go func(){
-					defer atomic.StoreInt32(&runner.running, 0)
-					if err := m.executeScanAndSave(jr); err != nil{
-						fmt.Printf("[Scheduler] job %d run error: %v\n", jr.ID, err)
-					}
-				}()
+				if wait := state.backoffRemaining(interval); wait > 0{
+					timer.Reset(wait)
+					continue
+				}
+				m.trigger(jr, runner)
+				timer.Reset(interval + jitter(jr))
 			}
 		}
 	}()
+}
 
-	return nil
+func (m *Manager) trigger(jr JobRow, runner *jobRunner){
+	if _, err := m.enqueue(jr, runner.state, TriggerSchedule, PriorityNormal); err != nil{
+		if err == ErrJobBusy{
+			m.recordSkipped(jr.ID, "previous run still active")
+			return
+		}
+		fmt.Printf("[Scheduler] job %d enqueue error: %v\n", jr.ID, err)
+	}
 }
 
-func (m *Manager) executeOnce(ctx context.Context, jr JobRow, runner *jobRunner) error{
-	if !atomic.CompareAndSwapInt32(&runner.running, 0, 1){
-		return nil
+// enqueue records a run and hands it to the execution queue. It fails with
+// ErrJobBusy if the job already has a queued or running run.
+func (m *Manager) enqueue(jr JobRow, state *runState, trigger string, p Priority) (int64, error){
+	if !atomic.CompareAndSwapInt32(&state.running, 0, 1){
+		return 0, ErrJobBusy
 	}
-	defer atomic.StoreInt32(&runner.running, 0)
+	state.lastRun.Store(time.Now().UnixNano())
 
-	select{
-	case <-ctx.Done():
-		return fmt.Errorf("job %d cancelled before start", jr.ID)
-	default:
+	runID, err := m.insertRun(jr.ID, trigger, p)
+	if err != nil{
+		atomic.StoreInt32(&state.running, 0)
+		return 0, err
 	}
 
-	if err := m.executeScanAndSave(jr); err != nil{
-		return err
+	queued := m.queue.push(&runRequest{
+		RunID: runID,
+		Job: jr,
+		Priority: p,
+		Trigger: trigger,
+		EnqueuedAt: time.Now(),
+		state: state,
+	})
+	if !queued{
+		atomic.StoreInt32(&state.running, 0)
+		_ = m.markRunFinished(runID, RunInterrupted, ErrShuttingDown)
+		return 0, ErrShuttingDown
 	}
+	metrics.SchedulerQueueDepth.Set(float64(m.queue.len()))
+	return runID, nil
+}
 
-	return nil
+// RunNow queues an immediate, high priority run of a job. If the job is
+// already waiting in the queue its priority is raised instead.
+func (m *Manager) RunNow(id int64) (int64, error){
+	jr, err := m.GetJob(id)
+	if err != nil{
+		return 0, err
+	}
+
+	m.mu.Lock()
+	state := m.stateLocked(id)
+	m.mu.Unlock()
+
+	runID, err := m.enqueue(jr, state, TriggerManual, PriorityHigh)
+	if err == ErrJobBusy{
+		if queuedID, ok := m.queue.bump(id, PriorityHigh); ok{
+			_, _ = m.db.Exec("UPDATE job_runs SET priority = ? WHERE id = ?", int(PriorityHigh), queuedID)
+			return queuedID, nil
+		}
+	}
+	return runID, err
+}
+
+func (m *Manager) worker(){
+	defer m.wg.Done()
+	for{
+		req, ok := m.queue.pop()
+		if !ok{
+			return
+		}
+		m.execute(req)
+		m.queue.release(req)
+	}
 }
 
-func(m *Manager) executeScanAndSave(jr JobRow) error{
+func (m *Manager) execute(req *runRequest){
+	defer atomic.StoreInt32(&req.state.running, 0)
+
+	metrics.SchedulerQueueDepth.Set(float64(m.queue.len()))
+	metrics.SchedulerQueueWaitSeconds.Observe(time.Since(req.EnqueuedAt).Seconds())
+
+	// Reload the job so a run queued before an update uses the new settings.
+	jr, err := m.GetJob(req.Job.ID)
+	if err == sql.ErrNoRows{
+		_ = m.markRunFinished(req.RunID, RunSkipped, fmt.Errorf("job was deleted while queued"))
+		return
+	}
+	if err != nil{
+		_ = m.markRunFinished(req.RunID, RunFailed, fmt.Errorf("load job: %w", err))
+		return
+	}
+	// A job stopped or auto-disabled after the run was queued must not scan
+	// again. A manual run of a job that was already stopped still goes ahead.
+	if jr.Active == 0 && req.Job.Active == 1{
+		_ = m.markRunFinished(req.RunID, RunSkipped, fmt.Errorf("job was stopped while queued"))
+		return
+	}
+
+	atomic.AddInt32(&m.active, 1)
+	m.countActive(jr.WorkspaceID, 1)
+	metrics.SchedulerRunningJobs.Inc()
+	defer func(){
+		atomic.AddInt32(&m.active, -1)
+		m.countActive(jr.WorkspaceID, -1)
+		metrics.SchedulerRunningJobs.Dec()
+	}()
+
+	if err := m.markRunStarted(req.RunID); err != nil{
+		fmt.Printf("[Scheduler] run %d status error: %v\n", req.RunID, err)
+	}
+
+	maxRun := m.maxRunDuration(jr)
+	ctx, cancel := context.WithTimeout(m.ctx, maxRun)
+	defer cancel()
+
+	status := RunSucceeded
+	runErr := m.executeRun(ctx, req.RunID, jr)
+	if runErr != nil{
+		status = RunFailed
+		switch{
+		case m.ctx.Err() != nil:
+			status = RunInterrupted
+			runErr = ErrShuttingDown
+		case errors.Is(runErr, context.DeadlineExceeded):
+			status = RunTimedOut
+			runErr = fmt.Errorf("run exceeded max duration of %s", maxRun)
+		case quota.IsExceeded(runErr):
+			status = RunQuotaExceeded
+		}
+		fmt.Printf("[Scheduler] job %d run error: %v\n", jr.ID, runErr)
+	}
+
+	if err := m.markRunFinished(req.RunID, status, runErr); err != nil{
+		fmt.Printf("[Scheduler] run %d status error: %v\n", req.RunID, err)
+	}
+	if status == RunQuotaExceeded{
+		msg := fmt.Sprintf("Scheduled scan of %s (job %d) did not run: %v", jr.Target, jr.ID, runErr)
+		m.notifyRun(jr, req.RunID, "job_quota_exceeded", models.SeverityLow, msg, fmt.Sprintf("job_quota:%d", jr.ID))
+	} else if status != RunInterrupted{
+		m.recordOutcome(jr, req.state, req.RunID, runErr)
+		// Partial results of a failed run are still worth alerting on.
+		if err := alerts.EvaluateRun(m.db, req.RunID); err != nil{
+			fmt.Printf("[Scheduler] run %d alert evaluation error: %v\n", req.RunID, err)
+		}
+	}
+}
+
+// QueueStatus describes the execution queue for the API.
+type QueueStatus struct{
+	Depth int `json:"depth"`
+	Running int `json:"running"`
+	Concurrency int `json:"concurrency"`
+	OldestWaitMs int64 `json:"oldest_wait_ms"`
+	Items []QueueItem `json:"items"`
+}
+
+func (m *Manager) countActive(workspaceID int64, delta int){
+	m.activeMu.Lock()
+	defer m.activeMu.Unlock()
+	m.activeIn[workspaceID] += delta
+	if m.activeIn[workspaceID] == 0{
+		delete(m.activeIn, workspaceID)
+	}
+}
+
+// QueueStatus describes the queue as seen from workspaceID: its waiting and
+// running runs only, so one workspace cannot watch another's activity. A
+// workspaceID of 0 describes the whole queue. Concurrency is always the
+// server wide worker count.
+func (m *Manager) QueueStatus(workspaceID int64) QueueStatus{
+	st := QueueStatus{Concurrency: m.concurrency, Items: []QueueItem{}}
+	for _, it := range m.queue.snapshot(){
+		if workspaceID != 0 && it.WorkspaceID != workspaceID{
+			continue
+		}
+		st.Items = append(st.Items, it)
+		if it.WaitingMs > st.OldestWaitMs{
+			st.OldestWaitMs = it.WaitingMs
+		}
+	}
+	st.Depth = len(st.Items)
+	if workspaceID == 0{
+		st.Running = int(atomic.LoadInt32(&m.active))
+	} else{
+		m.activeMu.Lock()
+		st.Running = m.activeIn[workspaceID]
+		m.activeMu.Unlock()
+	}
+	return st
+}
+
+func(m *Manager) executeScanAndSave(ctx context.Context, runID int64, jr JobRow) error{
 	fmt.Printf("[Scheduler] Running recurring scan for %s (job %d)\n", jr.Target, jr.ID)
-	results := scan.ScanRange(jr.Target, jr.StartPort, jr.EndPort)
+	results, err := scan.ScanRangeContext(ctx, jr.Target, jr.StartPort, jr.EndPort)
+	if err != nil{
+		return err
+	}
 	tx, err := m.db.Beginx()
 	if err != nil{
 		for _, r := range results{
 			if _, e := m.db.NamedExec(
-				`INSERT INTO scans (target, port, is_open, duration_ms, user_id) VALUES (:target, :port, :is_open, :duration_ms, :user_id)`,
+				`INSERT INTO scans (target, port, is_open, duration_ms, user_id, workspace_id, run_id) VALUES (:target, :port, :is_open, :duration_ms, :user_id, :workspace_id, :run_id)`,
 				map[string]interface{}{
 					"target": jr.Target,
 					"port": r.Port,
 					"is_open": r.IsOpen,
 					"duration_ms": r.Duration,
 					"user_id": jr.UserID,
+					"workspace_id": jr.WorkspaceID,
+					"run_id": runID,
 				},
 			); e != nil {
 				fmt.Printf("[Scheduler] recurring insert error: %v\n", e)
@@ -194,16 +536,20 @@ func(m *Manager) executeScanAndSave(jr JobRow) error{
 
 	for _, r := range results{
 		if _, e := tx.NamedExec(
-			`INSERT INTO scans (target, port, is_open, duration_ms, user_id) VALUES (:target, :port, :is_open, :duration_ms, :user_id)`,
+			`INSERT INTO scans (target, port, is_open, duration_ms, user_id, workspace_id, run_id) VALUES (:target, :port, :is_open, :duration_ms, :user_id, :workspace_id, :run_id)`,
 			map[string]interface{}{
 				"target": jr.Target,
 				"port": r.Port,
 				"is_open": r.IsOpen,
 				"duration_ms": r.Duration,
 				"user_id": jr.UserID,
+				"workspace_id": jr.WorkspaceID,
+				"run_id": runID,
 			},
 		); e != nil {
 			fmt.Printf("[Scheduler] recurring insert error(tx): %v\n", e)
+			_ = tx.Rollback()
+			return e
 		}
 	}
 
@@ -240,34 +586,160 @@ func (m *Manager) StartJobByID(id int64) error{
 		return fmt.Errorf("job already active")
 	}
 
-	if _, err := m.db.Exec("UPDATE jobs SET active = 1 WHERE id  = ?", id); err != nil{
+	if _, err := m.db.Exec("UPDATE jobs SET active = 1, consecutive_failures = 0 WHERE id  = ?", id); err != nil{
 		return err
 	}
 
 	jr.Active = 1
+	jr.ConsecutiveFailures = 0
 	return m.startRunner(jr)
 }
 
+// JobUpdate holds the fields of a PATCH request; nil fields are left unchanged.
+type JobUpdate struct{
+	Target *string `json:"target"`
+	StartPort *int `json:"start_port"`
+	EndPort *int `json:"end_port"`
+	IntervalSeconds *int `json:"interval_seconds"`
+	Active *bool `json:"active"`
+	MaxRunSeconds *int `json:"max_run_seconds"`
+	MaxFailures *int `json:"max_failures"`
+	JitterSeconds *int `json:"jitter_seconds"`
+	ScheduleMode *string `json:"schedule_mode"`
+	Pipeline *Pipeline `json:"pipeline"`
+}
+
+// Apply returns jr with upd applied. It does not validate the result.
+func (upd JobUpdate) Apply(jr JobRow) (JobRow, error){
+	if upd.Target != nil{
+		jr.Target = *upd.Target
+	}
+	if upd.StartPort != nil{
+		jr.StartPort = *upd.StartPort
+	}
+	if upd.EndPort != nil{
+		jr.EndPort = *upd.EndPort
+	}
+	if upd.IntervalSeconds != nil{
+		jr.IntervalSeconds = *upd.IntervalSeconds
+	}
+	if upd.Active != nil{
+		if *upd.Active && jr.Active == 0{
+			// Re-enabling a job, possibly one that was disabled after
+			// repeated failures, starts it with a clean slate.
+			jr.ConsecutiveFailures = 0
+		}
+		jr.Active = 0
+		if *upd.Active{
+			jr.Active = 1
+		}
+	}
+	if upd.MaxRunSeconds != nil{
+		jr.MaxRunSeconds = *upd.MaxRunSeconds
+	}
+	if upd.MaxFailures != nil{
+		jr.MaxFailures = *upd.MaxFailures
+	}
+	if upd.JitterSeconds != nil{
+		jr.JitterSeconds = *upd.JitterSeconds
+	}
+	if upd.ScheduleMode != nil{
+		jr.ScheduleMode = *upd.ScheduleMode
+	}
+	if upd.Pipeline != nil{
+		// An empty stage list turns the job back into a plain port scan.
+		p, err := encodePipeline(upd.Pipeline)
+		if err != nil{
+			return jr, err
+		}
+		jr.Pipeline = p
+	}
+
+	return jr, nil
+}
+
+func (m *Manager) GetJob(id int64) (JobRow, error){
+	var jr JobRow
+	err := m.db.Get(&jr, "SELECT * FROM jobs WHERE id = ?", id)
+	return jr, err
+}
+
+// UpdateJob applies upd to the stored job and swaps its runner for one using
+// the new settings. The whole read, change and write happens under the
+// manager lock, so concurrent updates apply one after the other instead of
+// the later one overwriting the earlier from a stale row. The new runner
+// picks up the schedule from the previous run, so no run is duplicated or lost.
+func (m *Manager) UpdateJob(id int64, upd JobUpdate) (JobRow, error){
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jr, err := m.GetJob(id)
+	if err != nil{
+		return jr, err
+	}
+
+	jr, err = upd.Apply(jr)
+	if err != nil{
+		return jr, err
+	}
+	if err := jr.Spec().Validate(); err != nil{
+		return jr, err
+	}
+
+	if _, err := m.db.Exec(
+		`UPDATE jobs SET target = ?, start_port = ?, end_port = ?, interval_seconds = ?, active = ?,
+		max_run_seconds = ?, max_failures = ?, consecutive_failures = ?,
+		jitter_seconds = ?, schedule_mode = ?, pipeline = ? WHERE id = ?`,
+		jr.Target, jr.StartPort, jr.EndPort, jr.IntervalSeconds, jr.Active,
+		jr.MaxRunSeconds, jr.MaxFailures, jr.ConsecutiveFailures,
+		jr.JitterSeconds, jr.ScheduleMode, jr.Pipeline, id,
+	); err != nil{
+		return jr, err
+	}
+
+	state := m.stateLocked(id)
+	delay := m.initialDelay(jr)
+	if old, ok := m.runners[id]; ok{
+		old.cancel()
+		delete(m.runners, id)
+		delay = state.nextDelay(time.Duration(jr.IntervalSeconds) * time.Second) + jitter(jr)
+	}
+
+	if jr.Active == 1{
+		m.startRunnerLocked(jr, state, delay)
+	}
+
+	return jr, nil
+}
+
 func (m *Manager) DeleteJob(id int64) error{
 	_ = m.StopJob(id)
+	m.mu.Lock()
+	delete(m.states, id)
+	m.mu.Unlock()
 	_, err := m.db.Exec("DELETE FROM jobs WHERE id = ?", id)
 	return err
 }
 
-func (m *Manager) ListJobs() ([]JobRow, error){
+// ListJobs returns the jobs of workspaceID, or every job if workspaceID is 0.
+func (m *Manager) ListJobs(workspaceID int64) ([]JobRow, error){
 	rows := []JobRow{}
-	if err := m.db.Select(&rows, "SELECT * FROM jobs ORDER BY created_at DESC"); err != nil{
+	query := "SELECT * FROM jobs"
+	args := []interface{}{}
+	if workspaceID != 0{
+		query += " WHERE workspace_id = ?"
+		args = append(args, workspaceID)
+	}
+	query += " ORDER BY created_at DESC"
+	if err := m.db.Select(&rows, query, args...); err != nil{
 		return nil, err
 	}
 	return rows, nil
 }
 
+// StopAll stops every job immediately, cancelling in-flight runs.
 func (m *Manager) StopAll(){
-	m.cancel()
-	m.mu.Lock()
-	for _, r := range m.runners{
-		r.cancel()
-	}
-	m.mu.Unlock()
-	m.wg.Wait()
-}
\ No newline at end of file
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = m.Shutdown(ctx)
+}
@@ -0,0 +1,398 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/audit"
+	"github.com/KuberTheGreat/Sentrinet/internal/quota"
+	"github.com/KuberTheGreat/Sentrinet/internal/scan"
+	"github.com/KuberTheGreat/Sentrinet/internal/targets"
+)
+
+const (
+	StageDiscovery = "discovery"
+	StagePortScan  = "port_scan"
+	StageTLSProbe  = "tls_probe"
+	StageHTTPProbe = "http_probe"
+
+	StagePending = "pending"
+)
+
+// Pipeline chains stages against a job's target. Each stage consumes the
+// result of the one before it; the first stage starts from the target, with
+// CIDR targets expanded to their hosts.
+type Pipeline struct {
+	Stages []Stage `json:"stages"`
+}
+
+type Stage struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// Ports are probed by discovery, scanned by port_scan and filter the
+	// open ports handed to tls_probe and http_probe.
+	Ports          []int       `json:"ports,omitempty"`
+	StartPort      int         `json:"start_port,omitempty"`
+	EndPort        int         `json:"end_port,omitempty"`
+	TopPorts       int         `json:"top_ports,omitempty"`
+	TimeoutSeconds int         `json:"timeout_seconds,omitempty"`
+	Retry          RetryPolicy `json:"retry"`
+}
+
+type RetryPolicy struct {
+	MaxAttempts    int `json:"max_attempts"`
+	BackoffSeconds int `json:"backoff_seconds"`
+}
+
+// Service is an open port found by a port_scan stage.
+type Service struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	TLS  bool   `json:"tls,omitempty"`
+}
+
+// StageResult is passed from one stage to the next and stored as the
+// stage's output.
+type StageResult struct {
+	Hosts    []string  `json:"hosts"`
+	Services []Service `json:"services"`
+	Probes   int       `json:"probes,omitempty"`
+}
+
+func (p *Pipeline) Validate() error {
+	if len(p.Stages) == 0 {
+		return fmt.Errorf("pipeline needs at least one stage")
+	}
+	for i, st := range p.Stages {
+		if st.Name == "" {
+			return fmt.Errorf("stage %d needs a name", i)
+		}
+		for _, port := range st.Ports {
+			if port <= 0 || port > 65535 {
+				return fmt.Errorf("stage %q: invalid port %d", st.Name, port)
+			}
+		}
+		if st.TimeoutSeconds < 0 || st.Retry.MaxAttempts < 0 || st.Retry.BackoffSeconds < 0 {
+			return fmt.Errorf("stage %q: timeout and retry settings must not be negative", st.Name)
+		}
+
+		switch st.Kind {
+		case StageDiscovery:
+			if i != 0 {
+				return fmt.Errorf("stage %q: discovery must be the first stage", st.Name)
+			}
+		case StagePortScan:
+			if len(st.Ports) == 0 && st.TopPorts <= 0 && st.StartPort <= 0 {
+				return fmt.Errorf("stage %q: port_scan needs ports, top_ports or a port range", st.Name)
+			}
+			if st.StartPort > 0 && (st.EndPort < st.StartPort || st.EndPort > 65535) {
+				return fmt.Errorf("stage %q: invalid port range %d-%d", st.Name, st.StartPort, st.EndPort)
+			}
+		case StageTLSProbe, StageHTTPProbe:
+			if !hasPortScanBefore(p.Stages[:i]) {
+				return fmt.Errorf("stage %q: %s needs an earlier port_scan stage", st.Name, st.Kind)
+			}
+		default:
+			return fmt.Errorf("stage %q: unknown kind %q", st.Name, st.Kind)
+		}
+	}
+	return nil
+}
+
+func hasPortScanBefore(stages []Stage) bool {
+	for _, st := range stages {
+		if st.Kind == StagePortScan {
+			return true
+		}
+	}
+	return false
+}
+
+func (st Stage) scanPorts() []int {
+	switch {
+	case len(st.Ports) > 0:
+		return st.Ports
+	case st.TopPorts > 0:
+		return scan.TopPorts(st.TopPorts)
+	default:
+		return scan.PortRange(st.StartPort, st.EndPort)
+	}
+}
+
+// PortsPerRun is the number of ports a run of the job probes, which is what
+// the daily port quota is charged. Probe stages only revisit ports found
+// open and are not counted.
+func (s JobSpec) PortsPerRun() int64 {
+	if s.Pipeline == nil || len(s.Pipeline.Stages) == 0 {
+		if s.EndPort < s.StartPort {
+			return 0
+		}
+		return int64(s.EndPort - s.StartPort + 1)
+	}
+	hosts := int64(1)
+	if list, err := scan.ExpandTarget(s.Target); err == nil {
+		hosts = int64(len(list))
+	}
+	var ports int64
+	for _, st := range s.Pipeline.Stages {
+		switch st.Kind {
+		case StageDiscovery:
+			n := len(st.Ports)
+			if n == 0 {
+				n = len(scan.DefaultDiscoveryPorts)
+			}
+			ports += int64(n) * hosts
+		case StagePortScan:
+			ports += int64(len(st.scanPorts())) * hosts
+		}
+	}
+	return ports
+}
+
+func (jr JobRow) pipeline() (*Pipeline, error) {
+	if jr.Pipeline == nil || *jr.Pipeline == "" {
+		return nil, nil
+	}
+	var p Pipeline
+	if err := json.Unmarshal([]byte(*jr.Pipeline), &p); err != nil {
+		return nil, fmt.Errorf("job %d has an invalid pipeline: %w", jr.ID, err)
+	}
+	return &p, nil
+}
+
+func encodePipeline(p *Pipeline) (*string, error) {
+	if p == nil || len(p.Stages) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
+// executeRun runs the job's pipeline if it has one, or its plain port range
+// scan otherwise. The target rules and the workspace's ownership proof are
+// checked on every run since either may have changed after the job was
+// created. The run takes a concurrent scan slot of the owner and the
+// workspace and is charged to the daily port quota; if either is used up it
+// fails with a quota error.
+func (m *Manager) executeRun(ctx context.Context, runID int64, jr JobRow) error {
+	if err := targets.Authorize(m.db, jr.WorkspaceID, jr.Target); err != nil {
+		if denied, ok := err.(*targets.DeniedError); ok {
+			audit.Record(m.db, audit.Event{
+				ActorID: jr.UserID, WorkspaceID: jr.WorkspaceID, Action: audit.ActionTargetDenied,
+				ResourceType: "job", ResourceID: fmt.Sprint(jr.ID), Target: jr.Target, Detail: denied.Reason,
+			})
+		}
+		return err
+	}
+	release, err := quota.Acquire(m.db, jr.UserID, jr.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := quota.UsePorts(m.db, jr.UserID, jr.WorkspaceID, jr.Spec().PortsPerRun()); err != nil {
+		return err
+	}
+	p, err := jr.pipeline()
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return m.executeScanAndSave(ctx, runID, jr)
+	}
+	return m.runPipeline(ctx, runID, jr, *p)
+}
+
+func (m *Manager) runPipeline(ctx context.Context, runID int64, jr JobRow, p Pipeline) error {
+	fmt.Printf("[Scheduler] Running pipeline for %s (job %d, %d stages)\n", jr.Target, jr.ID, len(p.Stages))
+
+	stageIDs := make([]int64, len(p.Stages))
+	for i, st := range p.Stages {
+		id, err := m.insertStageRun(runID, i, st)
+		if err != nil {
+			return err
+		}
+		stageIDs[i] = id
+	}
+
+	hosts, err := scan.ExpandTarget(jr.Target)
+	if err != nil {
+		return err
+	}
+
+	in := StageResult{Hosts: hosts}
+	for i, st := range p.Stages {
+		out, err := m.runStage(ctx, stageIDs[i], runID, jr, st, in)
+		if err != nil {
+			for _, id := range stageIDs[i+1:] {
+				_ = m.finishStageRun(id, RunSkipped, nil, nil)
+			}
+			return fmt.Errorf("stage %q: %w", st.Name, err)
+		}
+		in = out
+	}
+	return nil
+}
+
+// runStage executes one stage under its retry policy and records every
+// attempt on the stage's run record.
+func (m *Manager) runStage(ctx context.Context, stageID, runID int64, jr JobRow, st Stage, in StageResult) (StageResult, error) {
+	attempts := st.Retry.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := m.startStageRun(stageID, attempt); err != nil {
+			return StageResult{}, err
+		}
+
+		sctx, cancel := ctx, context.CancelFunc(func() {})
+		if st.TimeoutSeconds > 0 {
+			sctx, cancel = context.WithTimeout(ctx, time.Duration(st.TimeoutSeconds)*time.Second)
+		}
+		out, err := m.execStage(sctx, stageID, runID, jr, st, in)
+		cancel()
+
+		if err == nil {
+			return out, m.finishStageRun(stageID, RunSucceeded, &out, nil)
+		}
+		if attempt >= attempts || ctx.Err() != nil {
+			_ = m.finishStageRun(stageID, RunFailed, nil, err)
+			return StageResult{}, err
+		}
+
+		fmt.Printf("[Scheduler] job %d stage %q attempt %d failed: %v\n", jr.ID, st.Name, attempt, err)
+		select {
+		case <-time.After(time.Duration(st.Retry.BackoffSeconds) * time.Second):
+		case <-ctx.Done():
+			_ = m.finishStageRun(stageID, RunFailed, nil, ctx.Err())
+			return StageResult{}, ctx.Err()
+		}
+	}
+}
+
+func (m *Manager) execStage(ctx context.Context, stageID, runID int64, jr JobRow, st Stage, in StageResult) (StageResult, error) {
+	switch st.Kind {
+	case StageDiscovery:
+		live, err := scan.DiscoverHosts(ctx, in.Hosts, st.Ports)
+		if err != nil {
+			return StageResult{}, err
+		}
+		return StageResult{Hosts: live}, nil
+
+	case StagePortScan:
+		return m.portScanStage(ctx, runID, jr, st, in)
+
+	case StageTLSProbe, StageHTTPProbe:
+		return m.probeStage(ctx, stageID, runID, jr, st, in)
+	}
+	return StageResult{}, fmt.Errorf("unknown stage kind %q", st.Kind)
+}
+
+func (m *Manager) portScanStage(ctx context.Context, runID int64, jr JobRow, st Stage, in StageResult) (StageResult, error) {
+	ports := st.scanPorts()
+	out := StageResult{Hosts: in.Hosts, Services: []Service{}}
+	all := make(map[string][]scan.PortResult, len(in.Hosts))
+
+	for _, host := range in.Hosts {
+		results, err := scan.ScanPortsContext(ctx, host, ports)
+		if err != nil {
+			return StageResult{}, err
+		}
+		all[host] = results
+		for _, r := range results {
+			if r.IsOpen {
+				out.Services = append(out.Services, Service{Host: host, Port: r.Port})
+			}
+		}
+	}
+
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return StageResult{}, err
+	}
+	for _, host := range in.Hosts {
+		for _, r := range all[host] {
+			if _, err := tx.Exec(
+				`INSERT INTO scans (target, port, is_open, duration_ms, user_id, workspace_id, run_id) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				host, r.Port, r.IsOpen, r.Duration, jr.UserID, jr.WorkspaceID, runID,
+			); err != nil {
+				_ = tx.Rollback()
+				return StageResult{}, err
+			}
+		}
+	}
+	return out, tx.Commit()
+}
+
+func (m *Manager) probeStage(ctx context.Context, stageID, runID int64, jr JobRow, st Stage, in StageResult) (StageResult, error) {
+	allowed := st.Ports
+	if len(allowed) == 0 {
+		allowed = scan.DefaultTLSPorts
+		if st.Kind == StageHTTPProbe {
+			allowed = scan.DefaultHTTPPorts
+		}
+	}
+	want := make(map[int]bool, len(allowed))
+	for _, p := range allowed {
+		want[p] = true
+	}
+
+	out := StageResult{Hosts: in.Hosts, Services: make([]Service, len(in.Services))}
+	copy(out.Services, in.Services)
+
+	// Probe first and write afterwards so that no write transaction is held
+	// open across network round trips.
+	probes := []ProbeRow{}
+	for i, svc := range out.Services {
+		if !want[svc.Port] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return StageResult{}, err
+		}
+
+		row := ProbeRow{RunID: runID, StageRunID: stageID, Target: svc.Host, Port: svc.Port, Kind: st.Kind, UserID: jr.UserID, WorkspaceID: jr.WorkspaceID}
+		if st.Kind == StageTLSProbe {
+			info, err := scan.ProbeTLS(ctx, svc.Host, svc.Port)
+			if err != nil {
+				continue
+			}
+			out.Services[i].TLS = true
+			notAfter := info.NotAfter.UTC()
+			row.TLSVersion, row.TLSSubject, row.TLSIssuer, row.TLSNotAfter = &info.Version, &info.Subject, &info.Issuer, &notAfter
+		} else {
+			useTLS := svc.TLS || svc.Port == 443 || svc.Port == 8443
+			info, err := scan.ProbeHTTP(ctx, svc.Host, svc.Port, useTLS)
+			if err != nil {
+				continue
+			}
+			row.HTTPStatus, row.HTTPServer, row.HTTPTitle = &info.Status, &info.Server, &info.Title
+		}
+		probes = append(probes, row)
+	}
+	out.Probes = len(probes)
+
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return StageResult{}, err
+	}
+	for _, row := range probes {
+		if _, err := tx.NamedExec(
+			`INSERT INTO probe_results (run_id, stage_run_id, target, port, kind, tls_version, tls_subject, tls_issuer,
+			tls_not_after, http_status, http_server, http_title, user_id, workspace_id)
+			VALUES (:run_id, :stage_run_id, :target, :port, :kind, :tls_version, :tls_subject, :tls_issuer,
+			:tls_not_after, :http_status, :http_server, :http_title, :user_id, :workspace_id)`, row,
+		); err != nil {
+			_ = tx.Rollback()
+			return StageResult{}, err
+		}
+	}
+	return out, tx.Commit()
+}
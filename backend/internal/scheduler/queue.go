@@ -0,0 +1,221 @@
+package scheduler
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Priority int
+
+const (
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 10
+)
+
+// runRequest is a single queued execution of a job.
+type runRequest struct {
+	RunID      int64
+	Job        JobRow
+	Priority   Priority
+	Trigger    string
+	EnqueuedAt time.Time
+
+	state *runState
+	key   string
+	seq   uint64
+	index int
+}
+
+// QueueItem is the API view of a queued run.
+type QueueItem struct {
+	RunID       int64  `json:"run_id"`
+	JobID       int64  `json:"job_id"`
+	UserID      int64  `json:"user_id"`
+	WorkspaceID int64  `json:"workspace_id"`
+	Target      string `json:"target"`
+	Priority    int    `json:"priority"`
+	Trigger     string `json:"trigger"`
+	WaitingMs   int64  `json:"waiting_ms"`
+}
+
+type requestHeap []*runRequest
+
+func (h requestHeap) Len() int { return len(h) }
+
+func (h requestHeap) Less(i, j int) bool {
+	if h[i].Priority != h[j].Priority {
+		return h[i].Priority > h[j].Priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h requestHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *requestHeap) Push(x interface{}) {
+	r := x.(*runRequest)
+	r.index = len(*h)
+	*h = append(*h, r)
+}
+
+func (h *requestHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	r := old[n-1]
+	old[n-1] = nil
+	r.index = -1
+	*h = old[:n-1]
+	return r
+}
+
+// runQueue orders pending runs by priority, then by arrival. At most
+// perTarget runs against the same target key execute at once; requests for a
+// saturated target wait while later ones for other targets go ahead.
+type runQueue struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	items     requestHeap
+	seq       uint64
+	closed    bool
+	perTarget int
+	inflight  map[string]int
+}
+
+func newRunQueue(ctx context.Context, perTarget int) *runQueue {
+	q := &runQueue{
+		perTarget: perTarget,
+		inflight:  make(map[string]int),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	go func() {
+		<-ctx.Done()
+		q.close()
+	}()
+	return q
+}
+
+// close stops the queue and returns the requests that never started.
+// Blocked workers return from pop.
+func (q *runQueue) close() []*runRequest {
+	q.mu.Lock()
+	q.closed = true
+	pending := q.items
+	q.items = nil
+	q.mu.Unlock()
+	q.cond.Broadcast()
+	return pending
+}
+
+// push adds r to the queue. It reports false if the queue is closed.
+func (q *runQueue) push(r *runRequest) bool {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return false
+	}
+	q.seq++
+	r.seq = q.seq
+	r.key = targetKey(r.Job.Target)
+	heap.Push(&q.items, r)
+	q.mu.Unlock()
+	q.cond.Signal()
+	return true
+}
+
+// pop blocks until a request whose target has spare capacity is available or
+// the queue is closed. The caller must release the request when done.
+func (q *runQueue) pop() (*runRequest, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.closed {
+			return nil, false
+		}
+		if i := q.nextEligible(); i >= 0 {
+			r := heap.Remove(&q.items, i).(*runRequest)
+			q.inflight[r.key]++
+			return r, true
+		}
+		q.cond.Wait()
+	}
+}
+
+// nextEligible returns the heap index of the highest priority request whose
+// target is below its concurrency limit, or -1. q.mu must be held.
+func (q *runQueue) nextEligible() int {
+	best := -1
+	for i, r := range q.items {
+		if q.inflight[r.key] >= q.perTarget {
+			continue
+		}
+		if best < 0 || q.items.Less(i, best) {
+			best = i
+		}
+	}
+	return best
+}
+
+func (q *runQueue) release(r *runRequest) {
+	q.mu.Lock()
+	q.inflight[r.key]--
+	if q.inflight[r.key] <= 0 {
+		delete(q.inflight, r.key)
+	}
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// bump raises the priority of the queued run of jobID, if any.
+func (q *runQueue) bump(jobID int64, p Priority) (int64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, r := range q.items {
+		if r.Job.ID == jobID {
+			if p > r.Priority {
+				r.Priority = p
+				heap.Fix(&q.items, r.index)
+			}
+			return r.RunID, true
+		}
+	}
+	return 0, false
+}
+
+func (q *runQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (q *runQueue) snapshot() []QueueItem {
+	q.mu.Lock()
+	items := make(requestHeap, 0, len(q.items))
+	for _, r := range q.items {
+		cp := *r
+		items = append(items, &cp)
+	}
+	q.mu.Unlock()
+	sort.Slice(items, items.Less)
+
+	out := make([]QueueItem, 0, len(items))
+	now := time.Now()
+	for _, r := range items {
+		out = append(out, QueueItem{
+			RunID:       r.RunID,
+			JobID:       r.Job.ID,
+			UserID:      r.Job.UserID,
+			WorkspaceID: r.Job.WorkspaceID,
+			Target:      r.Job.Target,
+			Priority:    int(r.Priority),
+			Trigger:     r.Trigger,
+			WaitingMs:   now.Sub(r.EnqueuedAt).Milliseconds(),
+		})
+	}
+	return out
+}
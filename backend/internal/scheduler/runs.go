@@ -0,0 +1,170 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	RunQueued    = "queued"
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+type RunRow struct {
+	ID          int64      `db:"id" json:"id"`
+	JobID       int64      `db:"job_id" json:"job_id"`
+	TriggerType string     `db:"trigger_type" json:"trigger"`
+	Priority    int        `db:"priority" json:"priority"`
+	Status      string     `db:"status" json:"status"`
+	Error       *string    `db:"error" json:"error,omitempty"`
+	QueuedAt    time.Time  `db:"queued_at" json:"queued_at"`
+	StartedAt   *time.Time `db:"started_at" json:"started_at,omitempty"`
+	FinishedAt  *time.Time `db:"finished_at" json:"finished_at,omitempty"`
+	RetryOf     *int64     `db:"retry_of" json:"retry_of,omitempty"`
+}
+
+func (m *Manager) insertRun(jobID int64, trigger string, p Priority) (int64, error) {
+	res, err := m.db.Exec(
+		`INSERT INTO job_runs (job_id, trigger_type, priority, status) VALUES (?, ?, ?, ?)`,
+		jobID, trigger, int(p), RunQueued,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (m *Manager) markRunStarted(runID int64) error {
+	_, err := m.db.Exec(`UPDATE job_runs SET status = ?, started_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		RunRunning, runID)
+	return err
+}
+
+func (m *Manager) markRunFinished(runID int64, status string, runErr error) error {
+	var msg interface{}
+	if runErr != nil {
+		msg = runErr.Error()
+	}
+	_, err := m.db.Exec(`UPDATE job_runs SET status = ?, error = ?, finished_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		status, msg, runID)
+	return err
+}
+
+// ListRuns returns the most recent runs of a job, newest first.
+func (m *Manager) ListRuns(jobID int64, limit int) ([]RunRow, error) {
+	rows := []RunRow{}
+	err := m.db.Select(&rows,
+		`SELECT * FROM job_runs WHERE job_id = ? ORDER BY id DESC LIMIT ?`, jobID, limit)
+	return rows, err
+}
+
+// StageRunRow is the record of one pipeline stage within a run.
+type StageRunRow struct {
+	ID         int64      `db:"id" json:"id"`
+	RunID      int64      `db:"run_id" json:"run_id"`
+	StageIndex int        `db:"stage_index" json:"stage_index"`
+	Name       string     `db:"name" json:"name"`
+	Kind       string     `db:"kind" json:"kind"`
+	Status     string     `db:"status" json:"status"`
+	Attempts   int        `db:"attempts" json:"attempts"`
+	Error      *string    `db:"error" json:"error,omitempty"`
+	Output     *string    `db:"output" json:"-"`
+	StartedAt  *time.Time `db:"started_at" json:"started_at,omitempty"`
+	FinishedAt *time.Time `db:"finished_at" json:"finished_at,omitempty"`
+
+	Result *StageResult `db:"-" json:"result,omitempty"`
+}
+
+// ProbeRow is a TLS or HTTP probe result of a pipeline stage.
+type ProbeRow struct {
+	ID          int64      `db:"id" json:"id"`
+	RunID       int64      `db:"run_id" json:"run_id"`
+	StageRunID  int64      `db:"stage_run_id" json:"stage_run_id"`
+	Target      string     `db:"target" json:"target"`
+	Port        int        `db:"port" json:"port"`
+	Kind        string     `db:"kind" json:"kind"`
+	TLSVersion  *string    `db:"tls_version" json:"tls_version,omitempty"`
+	TLSSubject  *string    `db:"tls_subject" json:"tls_subject,omitempty"`
+	TLSIssuer   *string    `db:"tls_issuer" json:"tls_issuer,omitempty"`
+	TLSNotAfter *time.Time `db:"tls_not_after" json:"tls_not_after,omitempty"`
+	HTTPStatus  *int       `db:"http_status" json:"http_status,omitempty"`
+	HTTPServer  *string    `db:"http_server" json:"http_server,omitempty"`
+	HTTPTitle   *string    `db:"http_title" json:"http_title,omitempty"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	UserID      int64      `db:"user_id" json:"user_id"`
+	WorkspaceID int64      `db:"workspace_id" json:"workspace_id"`
+}
+
+func (m *Manager) insertStageRun(runID int64, index int, st Stage) (int64, error) {
+	res, err := m.db.Exec(
+		`INSERT INTO stage_runs (run_id, stage_index, name, kind, status) VALUES (?, ?, ?, ?, ?)`,
+		runID, index, st.Name, st.Kind, StagePending,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (m *Manager) startStageRun(id int64, attempt int) error {
+	_, err := m.db.Exec(
+		`UPDATE stage_runs SET status = ?, attempts = ?, error = NULL,
+		started_at = COALESCE(started_at, CURRENT_TIMESTAMP) WHERE id = ?`,
+		RunRunning, attempt, id)
+	return err
+}
+
+func (m *Manager) finishStageRun(id int64, status string, out *StageResult, runErr error) error {
+	var msg, output interface{}
+	if runErr != nil {
+		msg = runErr.Error()
+	}
+	if out != nil {
+		b, err := json.Marshal(out)
+		if err != nil {
+			return err
+		}
+		output = string(b)
+	}
+	_, err := m.db.Exec(
+		`UPDATE stage_runs SET status = ?, error = ?, output = ?, finished_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		status, msg, output, id)
+	return err
+}
+
+// ListStageRuns returns the stage records of a pipeline run in stage order.
+func (m *Manager) ListStageRuns(runID int64) ([]StageRunRow, error) {
+	rows := []StageRunRow{}
+	if err := m.db.Select(&rows, `SELECT * FROM stage_runs WHERE run_id = ? ORDER BY stage_index`, runID); err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		if rows[i].Output == nil {
+			continue
+		}
+		var res StageResult
+		if err := json.Unmarshal([]byte(*rows[i].Output), &res); err == nil {
+			rows[i].Result = &res
+		}
+	}
+	return rows, nil
+}
+
+// ListProbes returns the probe results recorded by a run.
+func (m *Manager) ListProbes(runID int64) ([]ProbeRow, error) {
+	rows := []ProbeRow{}
+	err := m.db.Select(&rows, `SELECT * FROM probe_results WHERE run_id = ? ORDER BY target, port, kind`, runID)
+	return rows, err
+}
+
+// GetRun returns a single run of a job.
+func (m *Manager) GetRun(jobID, runID int64) (RunRow, error) {
+	var run RunRow
+	err := m.db.Get(&run, `SELECT * FROM job_runs WHERE id = ? AND job_id = ?`, runID, jobID)
+	return run, err
+}
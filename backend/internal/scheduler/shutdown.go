@@ -0,0 +1,102 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/metrics"
+)
+
+const (
+	RunInterrupted = "interrupted"
+
+	TriggerRecovery = "recovery"
+)
+
+// Shutdown stops scheduling new runs and waits for in-flight runs to finish.
+// Runs still executing when ctx expires are cancelled. Cancelled runs and
+// runs that were still queued are marked interrupted so that the next start
+// re-queues them.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	for id, r := range m.runners {
+		r.cancel()
+		delete(m.runners, id)
+	}
+	m.mu.Unlock()
+
+	for _, req := range m.queue.close() {
+		if err := m.markRunFinished(req.RunID, RunInterrupted, ErrShuttingDown); err != nil {
+			fmt.Printf("[Scheduler] run %d status error: %v\n", req.RunID, err)
+		}
+	}
+	metrics.SchedulerQueueDepth.Set(0)
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		m.cancel()
+		return nil
+	case <-ctx.Done():
+		if atomic.LoadInt32(&m.active) > 0 {
+			fmt.Println("[Scheduler] shutdown deadline reached, cancelling running jobs")
+		}
+		m.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// RecoverInterrupted re-queues the latest unfinished run of every active job.
+// Runs left queued or running by a crash are first marked interrupted. Each
+// interrupted run is recovered at most once, and only while no later run of
+// the job has been recorded; skipped runs do not count.
+func (m *Manager) RecoverInterrupted() error {
+	if _, err := m.db.Exec(
+		`UPDATE job_runs SET status = ?, error = ?, finished_at = CURRENT_TIMESTAMP WHERE status IN (?, ?)`,
+		RunInterrupted, "scheduler stopped unexpectedly", RunQueued, RunRunning,
+	); err != nil {
+		return err
+	}
+
+	runs := []RunRow{}
+	err := m.db.Select(&runs,
+		`SELECT r.* FROM job_runs r
+		JOIN jobs j ON j.id = r.job_id
+		WHERE r.status = ? AND j.active = 1
+		AND NOT EXISTS (SELECT 1 FROM job_runs n WHERE n.retry_of = r.id)
+		AND NOT EXISTS (SELECT 1 FROM job_runs l WHERE l.job_id = r.job_id AND l.id > r.id AND l.status != ?)`,
+		RunInterrupted, RunSkipped)
+	if err != nil {
+		return err
+	}
+
+	for _, run := range runs {
+		jr, err := m.GetJob(run.JobID)
+		if err != nil {
+			fmt.Printf("[Scheduler] recover run %d: %v\n", run.ID, err)
+			continue
+		}
+
+		m.mu.Lock()
+		state := m.stateLocked(jr.ID)
+		m.mu.Unlock()
+
+		runID, err := m.enqueue(jr, state, TriggerRecovery, Priority(run.Priority))
+		if err != nil {
+			fmt.Printf("[Scheduler] recover run %d: %v\n", run.ID, err)
+			continue
+		}
+		if _, err := m.db.Exec("UPDATE job_runs SET retry_of = ? WHERE id = ?", run.ID, runID); err != nil {
+			fmt.Printf("[Scheduler] recover run %d: %v\n", run.ID, err)
+		}
+		fmt.Printf("[Scheduler] re-queued interrupted run %d of job %d as run %d\n", run.ID, jr.ID, runID)
+	}
+	return nil
+}
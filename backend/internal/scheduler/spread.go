@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// ModeFixed runs a job as soon as it starts and every interval after.
+	ModeFixed = "fixed"
+	// ModeSpread offsets the first run of each job so that jobs sharing an
+	// interval are evenly distributed across it.
+	ModeSpread = "spread"
+
+	defaultPerTarget = 2
+)
+
+// jitter returns a random delay in [0, jitter_seconds] for a single tick.
+func jitter(jr JobRow) time.Duration {
+	if jr.JitterSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(jr.JitterSeconds)*int64(time.Second) + 1))
+}
+
+// initialDelay returns how long a freshly started runner waits before its
+// first run.
+func (m *Manager) initialDelay(jr JobRow) time.Duration {
+	delay := jitter(jr)
+	if jr.ScheduleMode == ModeSpread {
+		d, err := m.spreadDelay(jr, time.Now())
+		if err != nil {
+			fmt.Printf("[Scheduler] job %d spread error: %v\n", jr.ID, err)
+		}
+		delay += d
+	}
+	return delay
+}
+
+// spreadDelay places the job in one of n evenly spaced slots of its interval,
+// where n is the number of active spread jobs with the same interval and the
+// slot is the job's rank by id. Slots are aligned to the Unix epoch so that
+// jobs started at different times still land in their own slot.
+func (m *Manager) spreadDelay(jr JobRow, now time.Time) (time.Duration, error) {
+	ids := []int64{}
+	err := m.db.Select(&ids,
+		`SELECT id FROM jobs WHERE active = 1 AND schedule_mode = ? AND interval_seconds = ? ORDER BY id`,
+		ModeSpread, jr.IntervalSeconds)
+	if err != nil {
+		return 0, err
+	}
+
+	rank := len(ids)
+	for i, id := range ids {
+		if id == jr.ID {
+			rank = i
+			break
+		}
+	}
+	slots := len(ids)
+	if rank == len(ids) {
+		slots++
+	}
+
+	interval := time.Duration(jr.IntervalSeconds) * time.Second
+	offset := interval * time.Duration(rank) / time.Duration(slots)
+	phase := time.Duration(now.UnixNano()) % interval
+	delay := offset - phase
+	if delay < 0 {
+		delay += interval
+	}
+	return delay, nil
+}
+
+// targetKey groups targets for the per-target concurrency limit. IPv4
+// addresses and ranges share a key per /24, IPv6 per /64 and hostnames
+// per name.
+func targetKey(target string) string {
+	host := strings.ToLower(strings.TrimSpace(target))
+	if ip, _, err := net.ParseCIDR(host); err == nil {
+		return subnetKey(ip)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return subnetKey(ip)
+	}
+	return host
+}
+
+func subnetKey(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
@@ -0,0 +1,356 @@
+// Package targets decides which hosts Sentrinet may scan. Admins maintain
+// allow and deny rules of CIDRs and domains, and workspaces prove ownership of
+// the hosts they scan; every scan target, whether ad-hoc, scheduled or a CIDR
+// expanded by a pipeline, is checked before any packet is sent.
+package targets
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	KindAllow = "allow"
+	KindDeny  = "deny"
+)
+
+// alwaysDenied are cloud metadata endpoints. Scanning them from a server is
+// never legitimate and they hand out credentials, so they cannot be allowed.
+var alwaysDenied = []string{
+	"169.254.0.0/16",
+	"fd00:ec2::254/128",
+	"metadata.google.internal",
+}
+
+// resolveTimeout bounds the DNS lookup done for hostname targets.
+const resolveTimeout = 5 * time.Second
+
+// IPResolver looks up the addresses of a host. *net.Resolver satisfies it.
+type IPResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// ipResolver resolves hostname targets; tests replace it.
+var ipResolver IPResolver = net.DefaultResolver
+
+type Rule struct {
+	ID        int64     `db:"id" json:"id"`
+	Kind      string    `db:"kind" json:"kind"`
+	Pattern   string    `db:"pattern" json:"pattern"`
+	Comment   string    `db:"comment" json:"comment"`
+	CreatedBy *int64    `db:"created_by" json:"created_by,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// DeniedError is returned by Check and Authorize when a target is not allowed.
+type DeniedError struct {
+	Target string
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("target %s is not allowed: %s", e.Target, e.Reason)
+}
+
+// Normalize validates pattern and returns its canonical form: a CIDR with its
+// host bits cleared, a single IP as a /32 or /128, or a lower-case domain.
+func Normalize(pattern string) (string, error) {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "" {
+		return "", fmt.Errorf("pattern is required")
+	}
+	if _, ipnet, err := net.ParseCIDR(pattern); err == nil {
+		return ipnet.String(), nil
+	}
+	if ip := net.ParseIP(pattern); ip != nil {
+		if ip.To4() != nil {
+			return ip.String() + "/32", nil
+		}
+		return ip.String() + "/128", nil
+	}
+	domain := strings.TrimSuffix(strings.TrimPrefix(pattern, "*."), ".")
+	if domain == "" || strings.ContainsAny(domain, " /:*") || !strings.Contains(domain, ".") && domain != "localhost" {
+		return "", fmt.Errorf("%q is neither a CIDR, an IP nor a domain", pattern)
+	}
+	return domain, nil
+}
+
+func ListRules(db *sqlx.DB) ([]Rule, error) {
+	rules := []Rule{}
+	err := db.Select(&rules, `SELECT * FROM target_rules ORDER BY kind, pattern`)
+	return rules, err
+}
+
+func AddRule(db *sqlx.DB, kind, pattern, comment string, createdBy int64) (Rule, error) {
+	if kind != KindAllow && kind != KindDeny {
+		return Rule{}, fmt.Errorf("kind must be %q or %q", KindAllow, KindDeny)
+	}
+	pattern, err := Normalize(pattern)
+	if err != nil {
+		return Rule{}, err
+	}
+	res, err := db.Exec(`INSERT INTO target_rules (kind, pattern, comment, created_by) VALUES (?, ?, ?, ?)`,
+		kind, pattern, comment, createdBy)
+	if err != nil {
+		return Rule{}, err
+	}
+	id, _ := res.LastInsertId()
+	var r Rule
+	err = db.Get(&r, `SELECT * FROM target_rules WHERE id = ?`, id)
+	return r, err
+}
+
+// DeleteRule removes a rule and returns it, or sql.ErrNoRows if it does not
+// exist.
+func DeleteRule(db *sqlx.DB, id int64) (Rule, error) {
+	var r Rule
+	if err := db.Get(&r, `SELECT * FROM target_rules WHERE id = ?`, id); err != nil {
+		return Rule{}, err
+	}
+	_, err := db.Exec(`DELETE FROM target_rules WHERE id = ?`, id)
+	return r, err
+}
+
+// ruleSet is the parsed form of the rule table.
+type ruleSet struct {
+	allowNets, denyNets       []*net.IPNet
+	allowDomains, denyDomains []string
+}
+
+func (rs *ruleSet) add(kind, pattern string) {
+	nets, domains := &rs.denyNets, &rs.denyDomains
+	if kind == KindAllow {
+		nets, domains = &rs.allowNets, &rs.allowDomains
+	}
+	if _, ipnet, err := net.ParseCIDR(pattern); err == nil {
+		*nets = append(*nets, ipnet)
+	} else {
+		*domains = append(*domains, pattern)
+	}
+}
+
+// restricted reports whether an allowlist is in effect. Without allow rules
+// everything not denied may be scanned.
+func (rs *ruleSet) restricted() bool {
+	return len(rs.allowNets) > 0 || len(rs.allowDomains) > 0
+}
+
+func loadRules(db *sqlx.DB) (*ruleSet, error) {
+	rules, err := ListRules(db)
+	if err != nil {
+		return nil, err
+	}
+	rs := &ruleSet{}
+	for _, p := range alwaysDenied {
+		rs.add(KindDeny, p)
+	}
+	for _, r := range rules {
+		rs.add(r.Kind, r.Pattern)
+	}
+	return rs, nil
+}
+
+// overlaps reports whether two networks share at least one address.
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// within reports whether network n lies entirely inside outer.
+func within(n, outer *net.IPNet) bool {
+	nOnes, nBits := n.Mask.Size()
+	oOnes, oBits := outer.Mask.Size()
+	return nBits == oBits && nOnes >= oOnes && outer.Contains(n.IP)
+}
+
+func matchDomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// checkNet applies the CIDR rules to a network. A network is denied if any of
+// its addresses is, so a CIDR target cannot sweep across a denied range.
+func (rs *ruleSet) checkNet(n *net.IPNet) string {
+	for _, d := range rs.denyNets {
+		if overlaps(n, d) {
+			return "matches deny rule " + d.String()
+		}
+	}
+	if !rs.restricted() {
+		return ""
+	}
+	for _, a := range rs.allowNets {
+		if within(n, a) {
+			return ""
+		}
+	}
+	return "not covered by an allow rule"
+}
+
+func hostNet(ip net.IP) *net.IPNet {
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// checkHost applies the domain rules to a hostname and the CIDR rules to every
+// address it resolves to, so a name pointing at a denied range is denied too.
+func (rs *ruleSet) checkHost(ctx context.Context, host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, d := range rs.denyDomains {
+		if matchDomain(host, d) {
+			return "matches deny rule " + d
+		}
+	}
+	allowedByName := false
+	for _, a := range rs.allowDomains {
+		if matchDomain(host, a) {
+			allowedByName = true
+		}
+	}
+
+	addrs, err := ipResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		if rs.restricted() && !allowedByName {
+			return "cannot be resolved to check against the allow rules"
+		}
+		return ""
+	}
+	for _, addr := range addrs {
+		if reason := rs.checkAddr(addr.IP, allowedByName); reason != "" {
+			return fmt.Sprintf("resolves to %s which %s", addr.IP, reason)
+		}
+	}
+	return ""
+}
+
+// checkAddr applies the CIDR rules to one address of a hostname. Deny rules
+// always apply; allow rules are skipped when an allow rule covers the name.
+func (rs *ruleSet) checkAddr(ip net.IP, allowedByName bool) string {
+	n := hostNet(ip)
+	for _, d := range rs.denyNets {
+		if overlaps(n, d) {
+			return "matches deny rule " + d.String()
+		}
+	}
+	if rs.restricted() && !allowedByName {
+		if reason := rs.checkNet(n); reason != "" {
+			return "is " + reason
+		}
+	}
+	return ""
+}
+
+func (rs *ruleSet) check(target string) error {
+	var reason string
+	if _, ipnet, err := net.ParseCIDR(target); err == nil {
+		reason = rs.checkNet(ipnet)
+	} else if ip := net.ParseIP(target); ip != nil {
+		reason = rs.checkNet(hostNet(ip))
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+		defer cancel()
+		reason = rs.checkHost(ctx, target)
+	}
+	if reason != "" {
+		return &DeniedError{Target: target, Reason: reason}
+	}
+	return nil
+}
+
+// allows reports whether an allow rule covers target by itself, without
+// resolving it.
+func (rs *ruleSet) allows(target string) bool {
+	var n *net.IPNet
+	if _, ipnet, err := net.ParseCIDR(target); err == nil {
+		n = ipnet
+	} else if ip := net.ParseIP(target); ip != nil {
+		n = hostNet(ip)
+	}
+	if n != nil {
+		for _, a := range rs.allowNets {
+			if within(n, a) {
+				return true
+			}
+		}
+		return false
+	}
+	host := strings.TrimSuffix(strings.ToLower(target), ".")
+	for _, a := range rs.allowDomains {
+		if matchDomain(host, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns a *DeniedError if target may not be scanned. Deny rules win
+// over allow rules; once any allow rule exists, only allowed targets pass.
+// target may be a hostname, an IP or a CIDR.
+func Check(db *sqlx.DB, target string) error {
+	rs, err := loadRules(db)
+	if err != nil {
+		return err
+	}
+	return rs.check(target)
+}
+
+// Authorize is Check plus proof of ownership: unless an admin allow rule
+// covers the target, workspaceID must have verified it. Networks cannot be
+// verified, so CIDR targets need an allow rule. A verified hostname only
+// proves control of the name, so every address it resolves to must be
+// verified or allowed as well; otherwise pointing the name at someone else's
+// host would make that host scannable.
+func Authorize(db *sqlx.DB, workspaceID int64, target string) error {
+	rs, err := loadRules(db)
+	if err != nil {
+		return err
+	}
+	if err := rs.check(target); err != nil {
+		return err
+	}
+	if rs.allows(target) {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(target); err == nil {
+		return &DeniedError{Target: target, Reason: "networks must be covered by an allow rule"}
+	}
+	ok, err := verified(db, workspaceID, target)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &DeniedError{Target: target, Reason: "ownership has not been verified in this workspace"}
+	}
+	if net.ParseIP(target) != nil {
+		return nil
+	}
+	return rs.addressesOwned(db, workspaceID, target)
+}
+
+func (rs *ruleSet) addressesOwned(db *sqlx.DB, workspaceID int64, host string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := ipResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return &DeniedError{Target: host, Reason: "cannot be resolved to check that its addresses are verified"}
+	}
+	for _, addr := range addrs {
+		ip := addr.IP.String()
+		if rs.allows(ip) {
+			continue
+		}
+		ok, err := verified(db, workspaceID, ip)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return &DeniedError{Target: host, Reason: fmt.Sprintf("resolves to %s which has not been verified in this workspace", ip)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package targets
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	MethodDNS  = "dns"
+	MethodHTTP = "http"
+
+	StatusPending  = "pending"
+	StatusVerified = "verified"
+	StatusFailed   = "failed"
+)
+
+// dnsLabel is prepended to a domain to name the TXT record holding the token,
+// and httpPath is where the token is served for the HTTP method.
+const (
+	dnsLabel  = "_sentrinet-challenge."
+	dnsPrefix = "sentrinet-verification="
+	httpPath  = "/.well-known/sentrinet-verification/"
+)
+
+// checkTimeout bounds a single DNS or HTTP check.
+const checkTimeout = 10 * time.Second
+
+// Resolver looks up TXT records. *net.Resolver satisfies it.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// HTTPClient fetches the well-known file. *http.Client satisfies it.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Verification is a workspace's claim of control over a target and the token
+// proving it. A DNS proof covers the domain and its subdomains; an HTTP proof
+// covers only the exact host it was served from.
+type Verification struct {
+	ID          int64      `db:"id" json:"id"`
+	WorkspaceID int64      `db:"workspace_id" json:"workspace_id"`
+	Target      string     `db:"target" json:"target"`
+	Method      string     `db:"method" json:"method"`
+	Token       string     `db:"token" json:"token"`
+	Status      string     `db:"status" json:"status"`
+	LastError   *string    `db:"last_error" json:"last_error,omitempty"`
+	CreatedBy   *int64     `db:"created_by" json:"created_by,omitempty"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	CheckedAt   *time.Time `db:"checked_at" json:"checked_at,omitempty"`
+	VerifiedAt  *time.Time `db:"verified_at" json:"verified_at,omitempty"`
+}
+
+// Instructions tells the user where to publish the token.
+func (v Verification) Instructions() map[string]string {
+	if v.Method == MethodDNS {
+		return map[string]string{"record_type": "TXT", "name": dnsLabel + v.Target, "value": dnsPrefix + v.Token}
+	}
+	return map[string]string{"url": v.url(), "body": v.Token}
+}
+
+// url is where the HTTP method expects the token. IPv6 targets are bracketed.
+func (v Verification) url() string {
+	host := v.Target
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return "http://" + host + httpPath + v.Token
+}
+
+// Verifier issues and checks ownership challenges. Resolver and Client are
+// fields so tests can substitute local stand-ins.
+type Verifier struct {
+	DB       *sqlx.DB
+	Resolver Resolver
+	Client   HTTPClient
+}
+
+func NewVerifier(db *sqlx.DB) *Verifier {
+	return &Verifier{
+		DB:       db,
+		Resolver: net.DefaultResolver,
+		Client: &http.Client{
+			Timeout: checkTimeout,
+			// No proxy: the rules must see the address actually dialled.
+			Transport: &http.Transport{DialContext: guardedDialer(db).DialContext},
+			// The token must be served by the host itself, not by wherever
+			// it redirects to.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+// normalizeHost validates a verification target: a hostname, or an IP for the
+// HTTP method. Networks cannot be verified.
+func normalizeHost(target, method string) (string, error) {
+	host := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(target)), ".")
+	if host == "" || strings.ContainsAny(host, " /:*") && net.ParseIP(host) == nil {
+		return "", fmt.Errorf("%q is not a hostname or IP", target)
+	}
+	if net.ParseIP(host) != nil && method == MethodDNS {
+		return "", fmt.Errorf("IP targets can only be verified over http")
+	}
+	return host, nil
+}
+
+// Start issues a new token for target in workspaceID. Starting again for the
+// same target replaces the token and drops any earlier verification.
+func (v *Verifier) Start(workspaceID, userID int64, target, method string) (Verification, error) {
+	if method != MethodDNS && method != MethodHTTP {
+		return Verification{}, fmt.Errorf("method must be %q or %q", MethodDNS, MethodHTTP)
+	}
+	host, err := normalizeHost(target, method)
+	if err != nil {
+		return Verification{}, err
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return Verification{}, err
+	}
+	token := hex.EncodeToString(buf)
+
+	_, err = v.DB.Exec(`INSERT INTO target_verifications (workspace_id, target, method, token, created_by)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(workspace_id, target) DO UPDATE SET method = excluded.method, token = excluded.token,
+		status = 'pending', last_error = NULL, created_by = excluded.created_by, created_at = CURRENT_TIMESTAMP,
+		checked_at = NULL, verified_at = NULL`, workspaceID, host, method, token, userID)
+	if err != nil {
+		return Verification{}, err
+	}
+	var ver Verification
+	err = v.DB.Get(&ver, `SELECT * FROM target_verifications WHERE workspace_id = ? AND target = ?`, workspaceID, host)
+	return ver, err
+}
+
+func (v *Verifier) Get(workspaceID, id int64) (Verification, error) {
+	var ver Verification
+	err := v.DB.Get(&ver, `SELECT * FROM target_verifications WHERE id = ? AND workspace_id = ?`, id, workspaceID)
+	return ver, err
+}
+
+func (v *Verifier) List(workspaceID int64) ([]Verification, error) {
+	list := []Verification{}
+	err := v.DB.Select(&list, `SELECT * FROM target_verifications WHERE workspace_id = ? ORDER BY target`, workspaceID)
+	return list, err
+}
+
+// Delete removes a verification. It returns sql.ErrNoRows if it does not
+// exist in workspaceID.
+func (v *Verifier) Delete(workspaceID, id int64) error {
+	res, err := v.DB.Exec(`DELETE FROM target_verifications WHERE id = ? AND workspace_id = ?`, id, workspaceID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Check looks for the token of verification id and records the outcome. A
+// failed check of an already verified target revokes the verification, so
+// removing the token is how a target owner withdraws consent.
+func (v *Verifier) Check(ctx context.Context, workspaceID, id int64) (Verification, error) {
+	ver, err := v.Get(workspaceID, id)
+	if err != nil {
+		return ver, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	var checkErr error
+	if ver.Method == MethodDNS {
+		checkErr = v.checkDNS(ctx, ver)
+	} else {
+		checkErr = v.checkHTTP(ctx, ver)
+	}
+
+	if checkErr == nil {
+		_, err = v.DB.Exec(`UPDATE target_verifications SET status = ?, last_error = NULL,
+			checked_at = CURRENT_TIMESTAMP, verified_at = CURRENT_TIMESTAMP WHERE id = ?`, StatusVerified, id)
+	} else {
+		_, err = v.DB.Exec(`UPDATE target_verifications SET status = ?, last_error = ?,
+			checked_at = CURRENT_TIMESTAMP, verified_at = NULL WHERE id = ?`, StatusFailed, checkErr.Error(), id)
+	}
+	if err != nil {
+		return ver, err
+	}
+	return v.Get(workspaceID, id)
+}
+
+func (v *Verifier) checkDNS(ctx context.Context, ver Verification) error {
+	records, err := v.Resolver.LookupTXT(ctx, dnsLabel+ver.Target)
+	if err != nil {
+		return fmt.Errorf("TXT lookup failed: %v", err)
+	}
+	for _, r := range records {
+		if strings.TrimSpace(r) == dnsPrefix+ver.Token {
+			return nil
+		}
+	}
+	return fmt.Errorf("no TXT record at %s%s holds the token", dnsLabel, ver.Target)
+}
+
+// dialHost carries the target of an HTTP check to guardedDialer.
+type dialHost struct{}
+
+// guardedDialer applies the target rules to the address a check actually
+// dials. Check resolves the target on its own, and the name may point
+// somewhere else by the time the client resolves it again.
+func guardedDialer(db *sqlx.DB) *net.Dialer {
+	return &net.Dialer{
+		Timeout: checkTimeout,
+		ControlContext: func(ctx context.Context, network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("dialling %s: not an IP address", address)
+			}
+			rs, err := loadRules(db)
+			if err != nil {
+				return err
+			}
+			target, _ := ctx.Value(dialHost{}).(string)
+			if reason := rs.checkAddr(ip, target != "" && rs.allows(target)); reason != "" {
+				return &DeniedError{Target: target, Reason: fmt.Sprintf("connects to %s which %s", ip, reason)}
+			}
+			return nil
+		},
+	}
+}
+
+func (v *Verifier) checkHTTP(ctx context.Context, ver Verification) error {
+	// The server makes this request, so it is held to the target rules like
+	// any scan, here and again when the client dials.
+	if err := Check(v.DB, ver.Target); err != nil {
+		return err
+	}
+	ctx = context.WithValue(ctx, dialHost{}, ver.Target)
+	url := ver.url()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s returned %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(body)) != ver.Token {
+		return fmt.Errorf("%s does not contain the token", url)
+	}
+	return nil
+}
+
+// verified reports whether workspaceID has proven control of host, a
+// hostname or an IP.
+func verified(db *sqlx.DB, workspaceID int64, host string) (bool, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	ip := net.ParseIP(host)
+	rows := []struct {
+		Target string `db:"target"`
+		Method string `db:"method"`
+	}{}
+	if err := db.Select(&rows, `SELECT target, method FROM target_verifications
+		WHERE workspace_id = ? AND status = ?`, workspaceID, StatusVerified); err != nil {
+		return false, err
+	}
+	for _, r := range rows {
+		if ip != nil {
+			if ip.Equal(net.ParseIP(r.Target)) {
+				return true, nil
+			}
+			continue
+		}
+		if r.Target == host || r.Method == MethodDNS && strings.HasSuffix(host, "."+r.Target) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,230 @@
+package targets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/db/dbtest"
+	"github.com/jmoiron/sqlx"
+)
+
+// fakeResolver answers TXT and address lookups from maps.
+type fakeResolver struct {
+	txt   map[string][]string
+	addrs map[string][]string
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if records, ok := r.txt[name]; ok {
+		return records, nil
+	}
+	return nil, fmt.Errorf("no such host %s", name)
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	list := []net.IPAddr{}
+	for _, a := range r.addrs[host] {
+		list = append(list, net.IPAddr{IP: net.ParseIP(a)})
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+	return list, nil
+}
+
+type verifyTest struct {
+	t        *testing.T
+	db       *sqlx.DB
+	resolver *fakeResolver
+	verifier *Verifier
+	// served is what the local web server answers at the well-known path,
+	// by token.
+	served map[string]string
+}
+
+func newVerifyTest(t *testing.T) *verifyTest {
+	database := dbtest.New(t)
+	vt := &verifyTest{
+		t: t, db: database,
+		resolver: &fakeResolver{txt: map[string][]string{}, addrs: map[string][]string{}},
+		served:   map[string]string{},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := vt.served[strings.TrimPrefix(r.URL.Path, httpPath)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	// Every host is dialled at the local server, as if its name had been
+	// re-pointed there after the check, and the guard sees that address.
+	dialer := guardedDialer(database)
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+	}}
+	vt.verifier = &Verifier{DB: database, Resolver: vt.resolver, Client: client}
+
+	previous := ipResolver
+	ipResolver = vt.resolver
+	t.Cleanup(func() { ipResolver = previous })
+	return vt
+}
+
+func (vt *verifyTest) start(target, method string) Verification {
+	ver, err := vt.verifier.Start(1, 1, target, method)
+	if err != nil {
+		vt.t.Fatal(err)
+	}
+	return ver
+}
+
+func (vt *verifyTest) check(ver Verification) Verification {
+	ver, err := vt.verifier.Check(context.Background(), 1, ver.ID)
+	if err != nil {
+		vt.t.Fatal(err)
+	}
+	return ver
+}
+
+func TestCheckDNS(t *testing.T) {
+	vt := newVerifyTest(t)
+	ver := vt.start("Example.test.", MethodDNS)
+	if ver.Target != "example.test" {
+		t.Fatalf("target = %q, want it normalized", ver.Target)
+	}
+
+	if got := vt.check(ver); got.Status != StatusFailed || got.LastError == nil {
+		t.Fatalf("without a record: status %s", got.Status)
+	}
+
+	vt.resolver.txt[dnsLabel+"example.test"] = []string{"unrelated", dnsPrefix + ver.Token}
+	if got := vt.check(ver); got.Status != StatusVerified || got.VerifiedAt == nil {
+		t.Fatalf("with the record: status %s", got.Status)
+	}
+	for host, want := range map[string]bool{"example.test": true, "www.example.test": true, "badexample.test": false} {
+		if ok, _ := verified(vt.db, 1, host); ok != want {
+			t.Errorf("verified(%s) = %v, want %v", host, ok, want)
+		}
+	}
+
+	// Removing the record withdraws consent.
+	delete(vt.resolver.txt, dnsLabel+"example.test")
+	if got := vt.check(ver); got.Status != StatusFailed || got.VerifiedAt != nil {
+		t.Fatalf("after removing the record: status %s", got.Status)
+	}
+}
+
+func TestCheckHTTP(t *testing.T) {
+	vt := newVerifyTest(t)
+	vt.resolver.addrs["app.example.test"] = []string{"203.0.113.7"}
+	ver := vt.start("app.example.test", MethodHTTP)
+
+	vt.served[ver.Token] = "wrong"
+	if got := vt.check(ver); got.Status != StatusFailed {
+		t.Fatalf("wrong body: status %s", got.Status)
+	}
+	vt.served[ver.Token] = ver.Token + "\n"
+	if got := vt.check(ver); got.Status != StatusVerified {
+		t.Fatalf("token served: status %s (%v)", got.Status, got.LastError)
+	}
+	// An HTTP proof covers only the exact host.
+	if ok, _ := verified(vt.db, 1, "www.app.example.test"); ok {
+		t.Fatal("HTTP proof covered a subdomain")
+	}
+
+	// The fetch is held to the target rules.
+	if _, err := AddRule(vt.db, KindDeny, "203.0.113.0/24", "", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := vt.check(ver); got.Status != StatusFailed {
+		t.Fatalf("denied target: status %s", got.Status)
+	}
+}
+
+func TestCheckHTTPDialsCheckedAddress(t *testing.T) {
+	vt := newVerifyTest(t)
+	vt.resolver.addrs["app.example.test"] = []string{"203.0.113.7"}
+	ver := vt.start("app.example.test", MethodHTTP)
+	vt.served[ver.Token] = ver.Token
+
+	// The name resolves to an allowed address, but the connection goes to
+	// the local server, which a deny rule covers.
+	if _, err := AddRule(vt.db, KindDeny, "127.0.0.0/8", "", 1); err != nil {
+		t.Fatal(err)
+	}
+	got := vt.check(ver)
+	if got.Status != StatusFailed || got.LastError == nil || !strings.Contains(*got.LastError, "connects to 127.0.0.1") {
+		t.Fatalf("re-pointed name: status %s (%v)", got.Status, got.LastError)
+	}
+}
+
+func TestHTTPURL(t *testing.T) {
+	for target, want := range map[string]string{
+		"app.example.test": "http://app.example.test" + httpPath + "tok",
+		"203.0.113.7":      "http://203.0.113.7" + httpPath + "tok",
+		"2001:db8::1":      "http://[2001:db8::1]" + httpPath + "tok",
+	} {
+		ver := Verification{Target: target, Method: MethodHTTP, Token: "tok"}
+		if got := ver.Instructions()["url"]; got != want {
+			t.Errorf("url of %s = %q, want %q", target, got, want)
+		}
+		if _, err := http.NewRequest(http.MethodGet, ver.url(), nil); err != nil {
+			t.Errorf("request to %s: %v", target, err)
+		}
+	}
+}
+
+func TestAuthorizeChecksResolvedAddresses(t *testing.T) {
+	vt := newVerifyTest(t)
+	vt.resolver.addrs["example.test"] = []string{"203.0.113.7", "198.51.100.9"}
+
+	var denied *DeniedError
+	if err := Authorize(vt.db, 1, "example.test"); !errors.As(err, &denied) {
+		t.Fatalf("unverified name: %v", err)
+	}
+
+	ver := vt.start("example.test", MethodDNS)
+	vt.resolver.txt[dnsLabel+"example.test"] = []string{dnsPrefix + ver.Token}
+	vt.check(ver)
+	err := Authorize(vt.db, 1, "example.test")
+	if !errors.As(err, &denied) || !strings.Contains(denied.Reason, "203.0.113.7") {
+		t.Fatalf("verified name pointing at unverified addresses: %v", err)
+	}
+
+	ipVer := vt.start("203.0.113.7", MethodHTTP)
+	vt.served[ipVer.Token] = ipVer.Token
+	if got := vt.check(ipVer); got.Status != StatusVerified {
+		t.Fatalf("IP verification: status %s (%v)", got.Status, got.LastError)
+	}
+	err = Authorize(vt.db, 1, "example.test")
+	if !errors.As(err, &denied) || !strings.Contains(denied.Reason, "198.51.100.9") {
+		t.Fatalf("one address still unverified: %v", err)
+	}
+
+	if _, err := AddRule(vt.db, KindAllow, "198.51.100.0/24", "", 1); err != nil {
+		t.Fatal(err)
+	}
+	vt.resolver.addrs["other.test"] = []string{"198.51.100.20"}
+	ver = vt.start("other.test", MethodDNS)
+	vt.resolver.txt[dnsLabel+"other.test"] = []string{dnsPrefix + ver.Token}
+	vt.check(ver)
+	if err := Authorize(vt.db, 1, "other.test"); err != nil {
+		t.Fatalf("verified name on allowed addresses: %v", err)
+	}
+
+	// Another workspace's proofs do not count.
+	if err := Authorize(vt.db, 2, "other.test"); !errors.As(err, &denied) {
+		t.Fatalf("other workspace: %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/egress"
+	"github.com/jmoiron/sqlx"
+)
+
+// Delivery states. A delivery is pending until a 2xx response marks it
+// delivered or it runs out of attempts and is dead-lettered.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+const (
+	pollInterval   = 5 * time.Second
+	requestTimeout = 10 * time.Second
+	batchSize      = 20
+	firstBackoff   = 30 * time.Second
+	maxBackoff     = 6 * time.Hour
+)
+
+type Delivery struct {
+	ID             int64      `db:"id" json:"id"`
+	WebhookID      int64      `db:"webhook_id" json:"webhook_id"`
+	NotificationID *int64     `db:"notification_id" json:"notification_id,omitempty"`
+	EventType      string     `db:"event_type" json:"event_type"`
+	Payload        string     `db:"payload" json:"payload"`
+	Status         string     `db:"status" json:"status"`
+	Attempts       int        `db:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time  `db:"next_attempt_at" json:"next_attempt_at"`
+	LastError      *string    `db:"last_error" json:"last_error,omitempty"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+	DeliveredAt    *time.Time `db:"delivered_at" json:"delivered_at,omitempty"`
+}
+
+// Attempt is one HTTP request made for a delivery.
+type Attempt struct {
+	ID         int64     `db:"id" json:"id"`
+	DeliveryID int64     `db:"delivery_id" json:"delivery_id"`
+	Attempt    int       `db:"attempt" json:"attempt"`
+	StatusCode *int      `db:"status_code" json:"status_code,omitempty"`
+	Error      *string   `db:"error" json:"error,omitempty"`
+	DurationMS int64     `db:"duration_ms" json:"duration_ms"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+}
+
+// MaxAttempts is how often a delivery is tried before it is dead-lettered,
+// SENTRINET_WEBHOOK_MAX_ATTEMPTS or 8.
+func MaxAttempts() int {
+	if v, err := strconv.Atoi(os.Getenv("SENTRINET_WEBHOOK_MAX_ATTEMPTS")); err == nil && v > 0 {
+		return v
+	}
+	return 8
+}
+
+// backoff is the delay after the given number of failed attempts: 30s
+// doubling each time, capped at six hours.
+func backoff(attempts int) time.Duration {
+	d := firstBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// Sign returns the X-Sentrinet-Signature value of body sent at timestamp:
+// the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the webhook secret.
+// Receivers recompute it and reject stale timestamps to stop replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// HTTPClient is the part of *http.Client the dispatcher uses.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+type Dispatcher struct {
+	DB     *sqlx.DB
+	Client HTTPClient
+}
+
+// NewDispatcher returns a dispatcher that does not follow redirects; a
+// receiver has to answer at the URL it registered.
+func NewDispatcher(db *sqlx.DB) *Dispatcher {
+	return &Dispatcher{DB: db, Client: &http.Client{
+		Timeout:   requestTimeout,
+		Transport: egress.Transport(),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// Start polls the outbox until ctx is cancelled. Deliveries survive restarts
+// because they live in the database; a delivery interrupted by a shutdown is
+// simply tried again.
+func (d *Dispatcher) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			if err := d.RunDue(ctx); err != nil {
+				fmt.Printf("[Webhooks] dispatch: %v\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunDue sends every pending delivery whose next attempt is due.
+func (d *Dispatcher) RunDue(ctx context.Context) error {
+	for {
+		due := []Delivery{}
+		if err := d.DB.Select(&due, `SELECT * FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY next_attempt_at LIMIT ?`, StatusPending, time.Now().UTC(), batchSize); err != nil {
+			return err
+		}
+		for _, del := range due {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err := d.send(ctx, del); err != nil {
+				fmt.Printf("[Webhooks] delivery %d: %v\n", del.ID, err)
+			}
+		}
+		if len(due) < batchSize {
+			return nil
+		}
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, del Delivery) error {
+	w, err := Get(d.DB, del.WebhookID)
+	if err == sql.ErrNoRows || err == nil && !w.Enabled {
+		return d.finish(del, StatusDead, "webhook deleted or disabled")
+	}
+	if err != nil {
+		return err
+	}
+
+	attempt := del.Attempts + 1
+	start := time.Now()
+	code, sendErr := d.post(ctx, w, del)
+	elapsed := time.Since(start).Milliseconds()
+
+	var codeArg, errArg interface{}
+	if code != 0 {
+		codeArg = code
+	}
+	if sendErr != nil {
+		errArg = sendErr.Error()
+	}
+	if _, err := d.DB.Exec(`INSERT INTO webhook_attempts (delivery_id, attempt, status_code, error, duration_ms)
+		VALUES (?, ?, ?, ?, ?)`, del.ID, attempt, codeArg, errArg, elapsed); err != nil {
+		return err
+	}
+
+	if sendErr == nil {
+		_, err := d.DB.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, last_error = NULL,
+			delivered_at = ? WHERE id = ?`, StatusDelivered, attempt, time.Now().UTC(), del.ID)
+		return err
+	}
+	if attempt >= MaxAttempts() {
+		fmt.Printf("[Webhooks] delivery %d dead after %d attempts: %v\n", del.ID, attempt, sendErr)
+		_, err := d.DB.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, last_error = ? WHERE id = ?`,
+			StatusDead, attempt, sendErr.Error(), del.ID)
+		return err
+	}
+	_, err = d.DB.Exec(`UPDATE webhook_deliveries SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?`,
+		attempt, sendErr.Error(), time.Now().UTC().Add(backoff(attempt)), del.ID)
+	return err
+}
+
+func (d *Dispatcher) finish(del Delivery, status, reason string) error {
+	_, err := d.DB.Exec(`UPDATE webhook_deliveries SET status = ?, last_error = ? WHERE id = ?`, status, reason, del.ID)
+	return err
+}
+
+// post sends one attempt and returns the response status, or 0 if none was
+// received. Any non-2xx answer is an error.
+func (d *Dispatcher) post(ctx context.Context, w Webhook, del Delivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	body := []byte(del.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Sentrinet-Webhooks/1.0")
+	req.Header.Set("X-Sentrinet-Event", del.EventType)
+	req.Header.Set("X-Sentrinet-Delivery", strconv.FormatInt(del.ID, 10))
+	req.Header.Set("X-Sentrinet-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("X-Sentrinet-Signature", Sign(w.Secret, ts, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Deliveries lists the most recent deliveries of a webhook, optionally only
+// those in status.
+func Deliveries(db *sqlx.DB, webhookID int64, status string, limit int) ([]Delivery, error) {
+	query := `SELECT * FROM webhook_deliveries WHERE webhook_id = ?`
+	args := []interface{}{webhookID}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+	list := []Delivery{}
+	err := db.Select(&list, query, args...)
+	return list, err
+}
+
+// GetDelivery returns a delivery of webhookID with its attempts, oldest first.
+func GetDelivery(db *sqlx.DB, webhookID, id int64) (Delivery, []Attempt, error) {
+	var del Delivery
+	if err := db.Get(&del, `SELECT * FROM webhook_deliveries WHERE id = ? AND webhook_id = ?`, id, webhookID); err != nil {
+		return del, nil, err
+	}
+	attempts := []Attempt{}
+	err := db.Select(&attempts, `SELECT * FROM webhook_attempts WHERE delivery_id = ? ORDER BY id`, id)
+	return del, attempts, err
+}
+
+// Redeliver moves a dead or delivered delivery back into the outbox with a
+// fresh attempt budget. Its attempt history is kept.
+func Redeliver(db *sqlx.DB, webhookID, id int64) error {
+	res, err := db.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ?
+		WHERE id = ? AND webhook_id = ? AND status != ?`, StatusPending, time.Now().UTC(), id, webhookID, StatusPending)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+// Package webhooks pushes notifications to HTTP endpoints. Every notification
+// is written to a persistent outbox, one delivery per matching subscription,
+// and a dispatcher posts the deliveries with retries.
+package webhooks
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/KuberTheGreat/Sentrinet/internal/egress"
+	"github.com/KuberTheGreat/Sentrinet/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// ScopeUser subscriptions receive the notifications addressed to their
+	// owner; ScopeWorkspace subscriptions receive every notification of the
+	// workspace.
+	ScopeUser      = "user"
+	ScopeWorkspace = "workspace"
+
+	// EventPing is sent by the test endpoint regardless of event filters.
+	EventPing = "ping"
+)
+
+type Webhook struct {
+	ID          int64     `db:"id" json:"id"`
+	Scope       string    `db:"scope" json:"scope"`
+	UserID      int64     `db:"user_id" json:"user_id"`
+	WorkspaceID *int64    `db:"workspace_id" json:"workspace_id,omitempty"`
+	URL         string    `db:"url" json:"url"`
+	Secret      string    `db:"secret" json:"-"`
+	EventList   string    `db:"event_types" json:"-"`
+	EventTypes  []string  `db:"-" json:"event_types"`
+	Enabled     bool      `db:"enabled" json:"enabled"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+func (w *Webhook) decode() {
+	w.EventTypes = []string{}
+	if w.EventList != "" {
+		w.EventTypes = strings.Split(w.EventList, ",")
+	}
+}
+
+// wants reports whether the subscription filters let eventType through. No
+// filter means every event.
+func (w Webhook) wants(eventType string) bool {
+	if len(w.EventTypes) == 0 || eventType == EventPing {
+		return true
+	}
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Create stores a subscription and returns it with its signing secret, which
+// is only shown once.
+func Create(db *sqlx.DB, w Webhook) (Webhook, string, error) {
+	if err := egress.CheckURL(w.URL); err != nil {
+		return w, "", err
+	}
+	if w.Scope != ScopeUser && w.Scope != ScopeWorkspace {
+		return w, "", fmt.Errorf("scope must be %q or %q", ScopeUser, ScopeWorkspace)
+	}
+	if w.Scope == ScopeUser {
+		w.WorkspaceID = nil
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return w, "", err
+	}
+	w.Secret = "whsec_" + hex.EncodeToString(buf)
+	for i, t := range w.EventTypes {
+		w.EventTypes[i] = strings.TrimSpace(t)
+	}
+	w.EventList = strings.Join(w.EventTypes, ",")
+
+	res, err := db.NamedExec(`INSERT INTO webhooks (scope, user_id, workspace_id, url, secret, event_types, enabled)
+		VALUES (:scope, :user_id, :workspace_id, :url, :secret, :event_types, :enabled)`, w)
+	if err != nil {
+		return w, "", err
+	}
+	id, _ := res.LastInsertId()
+	created, err := Get(db, id)
+	return created, w.Secret, err
+}
+
+func Get(db *sqlx.DB, id int64) (Webhook, error) {
+	var w Webhook
+	if err := db.Get(&w, `SELECT * FROM webhooks WHERE id = ?`, id); err != nil {
+		return w, err
+	}
+	w.decode()
+	return w, nil
+}
+
+// List returns userID's own subscriptions and those of workspaceID.
+func List(db *sqlx.DB, userID, workspaceID int64) ([]Webhook, error) {
+	hooks := []Webhook{}
+	if err := db.Select(&hooks, `SELECT * FROM webhooks
+		WHERE (scope = 'user' AND user_id = ?) OR (scope = 'workspace' AND workspace_id = ?)
+		ORDER BY id`, userID, workspaceID); err != nil {
+		return nil, err
+	}
+	for i := range hooks {
+		hooks[i].decode()
+	}
+	return hooks, nil
+}
+
+func Delete(db *sqlx.DB, id int64) error {
+	if _, err := db.Exec(`DELETE FROM webhook_attempts WHERE delivery_id IN
+		(SELECT id FROM webhook_deliveries WHERE webhook_id = ?)`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return err
+	}
+	res, err := db.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// payload is the JSON body posted for a notification.
+type payload struct {
+	Event        string              `json:"event"`
+	SentAt       time.Time           `json:"sent_at"`
+	Notification models.Notification `json:"notification"`
+}
+
+func enqueue(db *sqlx.DB, w Webhook, eventType string, n models.Notification) error {
+	body, err := json.Marshal(payload{Event: eventType, SentAt: time.Now().UTC(), Notification: n})
+	if err != nil {
+		return err
+	}
+	var notificationID interface{}
+	if n.ID != 0 {
+		notificationID = n.ID
+	}
+	_, err = db.Exec(`INSERT INTO webhook_deliveries (webhook_id, notification_id, event_type, payload, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?)`, w.ID, notificationID, eventType, string(body), time.Now().UTC())
+	return err
+}
+
+// Enqueue adds a delivery of n to the outbox of every enabled subscription it
+// matches. Failures are logged; a notification is never lost because a
+// webhook could not be queued.
+func Enqueue(db *sqlx.DB, n models.Notification) {
+	hooks := []Webhook{}
+	err := db.Select(&hooks, `SELECT * FROM webhooks WHERE enabled = 1 AND
+		((scope = 'user' AND user_id = ?) OR (scope = 'workspace' AND workspace_id = ?))`, n.UserID, n.WorkspaceID)
+	if err != nil {
+		fmt.Printf("[Webhooks] subscriptions for notification %d: %v\n", n.ID, err)
+		return
+	}
+	for _, w := range hooks {
+		w.decode()
+		if !w.wants(n.Type) {
+			continue
+		}
+		if err := enqueue(db, w, n.Type, n); err != nil {
+			fmt.Printf("[Webhooks] enqueue for webhook %d: %v\n", w.ID, err)
+		}
+	}
+}
+
+// Ping queues a test delivery to w.
+func Ping(db *sqlx.DB, w Webhook) error {
+	n := models.Notification{
+		UserID: int(w.UserID), Type: EventPing, Message: "Webhook test from Sentrinet",
+		Severity: models.SeverityInfo, CreatedAt: time.Now().UTC(),
+	}
+	if w.WorkspaceID != nil {
+		n.WorkspaceID = *w.WorkspaceID
+	}
+	return enqueue(db, w, EventPing, n)
+}
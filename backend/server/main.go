@@ -5,15 +5,21 @@ import (
 
 	"context"
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/KuberTheGreat/Sentrinet/internal/api"
+	"github.com/KuberTheGreat/Sentrinet/internal/auth"
 	"github.com/KuberTheGreat/Sentrinet/internal/db"
+	"github.com/KuberTheGreat/Sentrinet/internal/email"
+	"github.com/KuberTheGreat/Sentrinet/internal/handlers"
 	"github.com/KuberTheGreat/Sentrinet/internal/metrics"
 	"github.com/KuberTheGreat/Sentrinet/internal/realtime"
+	"github.com/KuberTheGreat/Sentrinet/internal/scheduler"
+	"github.com/KuberTheGreat/Sentrinet/internal/webhooks"
 	"github.com/ansrivas/fiberprometheus/v2"
 	"github.com/prometheus/client_golang/prometheus"
 
@@ -27,8 +33,23 @@ func main(){
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	
+	if err := auth.LoadKeys(); err != nil {
+		log.Fatal(err)
+	}
+
 	database := db.InitDB()
+	if err := auth.SeedRoles(database); err != nil {
+		log.Fatal(err)
+	}
+	if err := auth.EnsureWorkspaces(database); err != nil {
+		log.Fatal(err)
+	}
+	if err := auth.PromoteAdmins(database, os.Getenv("SENTRINET_ADMINS")); err != nil {
+		fmt.Println("[Auth] failed to promote admins: ", err)
+	}
 	db.StartCleanupScheduler(database)
+	webhooks.NewDispatcher(database).Start(ctx)
+	email.Start(ctx, database, email.ConfigFromEnv())
 
 	app := fiber.New()
 
@@ -45,13 +66,21 @@ func main(){
 
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
-		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
+		AllowMethods: "GET,POST,PUT,PATCH,DELETE,OPTIONS",
 		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
 	}))
 
 	wsManager := realtime.NewManager()
+	handlers.UseRealtime(wsManager)
+
+	// The scheduler gets its own context so that a shutdown signal drains
+	// runs through Shutdown instead of cancelling them outright.
+	schManager := scheduler.NewManager(context.Background(), database)
+	if err := schManager.LoadAndStartAll(); err != nil {
+		fmt.Println("[Scheduler] failed to load jobs: ", err)
+	}
 	
-	api.SetupRoutes(app, database, wsManager)
+	api.SetupRoutes(app, database, wsManager, schManager)
 
 	go func() {
 		if err := app.Listen(":8080"); err != nil{
@@ -69,8 +98,11 @@ func main(){
 	case <-ctx.Done():
 	}
 
-	_, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
-	_ = app.Shutdown()
+	_ = app.ShutdownWithContext(shutdownCtx)
+	if err := schManager.Shutdown(shutdownCtx); err != nil {
+		fmt.Println("[Scheduler] shutdown: ", err)
+	}
 	fmt.Println("Server stopped")
 }
\ No newline at end of file